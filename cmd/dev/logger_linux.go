@@ -0,0 +1,22 @@
+//go:build linux
+
+package main
+
+import (
+	"github.com/d2r2/go-logger"
+
+	"github.com/egregors/hk/log"
+)
+
+// setupLogger quiets i2c and bsbmp loggers of the BME280 driver, it's linux only
+func setupLogger() {
+	err := logger.ChangePackageLogLevel("i2c", logger.InfoLevel)
+	if err != nil {
+		log.Erro.Printf("can't setup i2c logger to INTO: %s", err.Error())
+	}
+
+	err = logger.ChangePackageLogLevel("bsbmp", logger.InfoLevel)
+	if err != nil {
+		log.Erro.Printf("can't setup bsbmp logger to INTO: %s", err.Error())
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+// setupLogger does nothing, there is no BME280 driver to quiet
+func setupLogger() {}
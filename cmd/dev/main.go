@@ -8,14 +8,17 @@ import (
 
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/brutella/hap"
-	"github.com/d2r2/go-logger"
 
+	"github.com/egregors/hk/internal/filter"
 	"github.com/egregors/hk/internal/homekit"
+	"github.com/egregors/hk/internal/host"
 	"github.com/egregors/hk/internal/metrics"
+	"github.com/egregors/hk/internal/mqtt"
 	"github.com/egregors/hk/internal/sensors"
 	"github.com/egregors/hk/log"
 	"github.com/egregors/hk/srv"
@@ -23,6 +26,9 @@ import (
 
 const (
 	metricsRetention = 3600 * time.Hour
+	calibrationPath  = "hk-calibration.json"
+	sensorDriver     = "sim"
+	powerDriver      = "noop"
 )
 
 var revision string = "HEAD"
@@ -40,6 +46,8 @@ func main() {
 		makeFakeHkSrv(),
 		m,
 		notifier.NewNoop(),
+		makeBroker(),
+		host.New(),
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -51,6 +59,15 @@ func main() {
 	}
 }
 
+func getFromEnv(name string, def string) string {
+	val := os.Getenv(name)
+	if val == "" {
+		return def
+	}
+
+	return val
+}
+
 func graceful(cancel context.CancelFunc, dumpFn metrics.DumpFn) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -79,20 +96,109 @@ func makeMetrics() (m srv.Metrics, dump metrics.DumpFn) {
 	return metrics.New(metrics.WithRetention(metricsRetention), metrics.WithBackup())
 }
 
-func makeClimate() srv.ClimateSensor {
-	bme280, err := sensors.NewBME280()
+func makeClimate() []srv.Sensor {
+	calibrations, err := sensors.NewCalibrationStore(calibrationPath)
+	if err != nil {
+		log.Erro.Printf("can't load sensor calibrations: %s", err.Error())
+		os.Exit(1)
+	}
+
+	// BMX_SETTINGS applies to the home sensor with "*" key, e.g. "*=mode:normal,standby:1s"
+	settings, err := sensors.ParseSettingsByID(getFromEnv("BMX_SETTINGS", ""))
+	if err != nil {
+		log.Erro.Printf("can't parse BMX_SETTINGS: %s", err.Error())
+		os.Exit(1)
+	}
+
+	home, err := sensors.Open(sensors.Spec{
+		ID:       "",
+		Room:     "Home",
+		Bus:      sensors.DefaultBus,
+		Addr:     sensors.AddrAuto,
+		Driver:   getFromEnv("SENSOR_DRIVER", sensorDriver),
+		Settings: settings["*"],
+	})
+	if err != nil {
+		log.Erro.Printf("can't create sensor: %s", err.Error())
+		os.Exit(1)
+	}
+
+	// the second room is simulated: replay SIM_TRACE if set, or a daily curve
+	// with a bus failure for 2 minutes every hour to see offline handling
+	// and a temperature spike for a minute to see filters rejecting it
+	var source sensors.Source = sensors.DefaultDiurnal
+	if path := os.Getenv("SIM_TRACE"); path != "" {
+		trace, err := sensors.LoadTrace(path)
+		if err != nil {
+			log.Erro.Printf("can't load sensor trace: %s", err.Error())
+			os.Exit(1)
+		}
+		trace.Loop = true
+		source = trace
+	}
+	sim := sensors.NewSimulated(source, sensors.WithFaults(sensors.Fault{
+		Kind:  sensors.FaultIOError,
+		From:  50 * time.Minute,
+		To:    52 * time.Minute,
+		Every: time.Hour,
+	}, sensors.Fault{
+		Kind:  sensors.FaultSpike,
+		From:  20 * time.Minute,
+		To:    21 * time.Minute,
+		Every: time.Hour,
+		Spike: 40,
+	}))
+
+	filters := makeFilters()
+
+	return []srv.Sensor{
+		{ID: "", Room: "Home", Climate: sensors.NewCalibrated(home, "", calibrations), Filters: filters},
+		{ID: "bedroom", Room: "Bedroom", Climate: sensors.NewCalibrated(sim, "bedroom", calibrations), Filters: filters},
+	}
+}
+
+// makeFilters reads reading filters from FILTERS env, see filter.ParseConfig
+func makeFilters() filter.Config {
+	filters, err := filter.ParseConfig(getFromEnv("FILTERS", filter.DefaultConfig))
 	if err != nil {
-		log.Erro.Printf("can't create BME280 sensor: %s", err.Error())
+		log.Erro.Printf("can't parse FILTERS: %s", err.Error())
 		os.Exit(1)
 	}
 
-	return bme280
+	filters.KeepRaw, err = strconv.ParseBool(getFromEnv("FILTERS_KEEP_RAW", "false"))
+	if err != nil {
+		log.Erro.Printf("can't parse FILTERS_KEEP_RAW: %s", err.Error())
+		os.Exit(1)
+	}
+
+	return filters
+}
+
+// makeBroker connects to MQTT broker from MQTT_URL env, e.g. "tcp://localhost:1883", no broker if it isn't set
+func makeBroker() srv.Broker {
+	url := getFromEnv("MQTT_URL", "")
+	if url == "" {
+		return nil
+	}
+
+	broker, err := mqtt.New(
+		url,
+		mqtt.WithPrefix(getFromEnv("MQTT_PREFIX", mqtt.DefaultPrefix)),
+		mqtt.WithClientID(getFromEnv("MQTT_CLIENT_ID", mqtt.DefaultClientID)),
+		mqtt.WithCredentials(getFromEnv("MQTT_USER", ""), getFromEnv("MQTT_PASSWORD", "")),
+	)
+	if err != nil {
+		log.Erro.Printf("can't create MQTT client: %s", err.Error())
+		os.Exit(1)
+	}
+
+	return broker
 }
 
 func makeLight() srv.USB2PowerCtrl {
 	// TODO: make two different external devices: required and options,
 	//  in case of fail of optional device setup just skip it.
-	garland, err := light.NewUsbGarland()
+	garland, err := light.Open(getFromEnv("POWER_DRIVER", powerDriver))
 	if err != nil {
 		log.Erro.Printf("can't create USB garland: %s", err.Error())
 		os.Exit(1)
@@ -104,15 +210,3 @@ func makeLight() srv.USB2PowerCtrl {
 func makeFakeHkSrv() *homekit.NoopHap {
 	return &homekit.NoopHap{}
 }
-
-func setupLogger() {
-	err := logger.ChangePackageLogLevel("i2c", logger.InfoLevel)
-	if err != nil {
-		log.Erro.Printf("can't setup i2c logger to INTO: %s", err.Error())
-	}
-
-	err = logger.ChangePackageLogLevel("bsbmp", logger.InfoLevel)
-	if err != nil {
-		log.Erro.Printf("can't setup bsbmp logger to INTO: %s", err.Error())
-	}
-}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+// cliTimeout covers a self-test of several sensors, every read is cut after 15s
+const cliTimeout = 2 * time.Minute
+
+const cliUsage = `usage: t-hk-srv [command [sensor]]
+
+commands:
+  diag [sensor]      show diagnostics of all sensors or one of them
+  selftest [sensor]  read sensors and compare readings with recent ones, exit 1 if it fails
+
+Commands ask the running hk at HK_URL (default: http://localhost).
+Use "" as the sensor for the single sensor setup.
+`
+
+// runCommand runs a CLI subcommand against the running hk web server and returns the exit code
+func runCommand(args []string) int {
+	var method, path string
+	switch args[0] {
+	case "diag":
+		method, path = http.MethodGet, "/diagnostics"
+	case "selftest":
+		method, path = http.MethodPost, "/diagnostics/selftest"
+	default:
+		_, _ = fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+
+	form := url.Values{}
+	if len(args) > 1 {
+		form.Set("sensor", args[1])
+	}
+
+	target := getFromEnv("HK_URL", "http://localhost") + path
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(form.Encode())
+	} else if len(form) > 0 {
+		target += "?" + form.Encode()
+	}
+
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		log.Erro.Printf("can't make request: %s", err.Error())
+		return 1
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := (&http.Client{Timeout: cliTimeout}).Do(req)
+	if err != nil {
+		log.Erro.Printf("can't reach hk: %s", err.Error())
+		return 1
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		log.Erro.Printf("can't read response: %s", err.Error())
+		return 1
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 1
+	}
+
+	return 0
+}
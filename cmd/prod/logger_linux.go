@@ -0,0 +1,24 @@
+//go:build linux
+
+package main
+
+import (
+	"github.com/d2r2/go-logger"
+
+	"github.com/egregors/hk/log"
+)
+
+// setupLogger turns debug logs off and quiets i2c and bsbmp loggers of the BME280 driver, it's linux only
+func setupLogger() {
+	log.Debg.Off()
+
+	err := logger.ChangePackageLogLevel("i2c", logger.InfoLevel)
+	if err != nil {
+		log.Erro.Printf("can't setup i2c logger to INTO: %s", err.Error())
+	}
+
+	err = logger.ChangePackageLogLevel("bsbmp", logger.InfoLevel)
+	if err != nil {
+		log.Erro.Printf("can't setup bsbmp logger to INTO: %s", err.Error())
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "github.com/egregors/hk/log"
+
+// setupLogger turns debug logs off, there is no BME280 driver to quiet
+func setupLogger() {
+	log.Debg.Off()
+}
@@ -8,15 +8,20 @@ import (
 
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/brutella/hap"
 	"github.com/brutella/hap/accessory"
-	"github.com/d2r2/go-logger"
 
+	"github.com/egregors/hk/internal/filter"
 	"github.com/egregors/hk/internal/homekit"
+	"github.com/egregors/hk/internal/host"
 	"github.com/egregors/hk/internal/metrics"
+	"github.com/egregors/hk/internal/mqtt"
 	"github.com/egregors/hk/internal/sensors"
 	"github.com/egregors/hk/log"
 	"github.com/egregors/hk/srv"
@@ -24,13 +29,42 @@ import (
 
 const (
 	metricsRetention = 30 * 24 * time.Hour
+	rollupRetention  = 180 * 24 * time.Hour
+	metricsDir       = "hk-metrics"
 	hapPIN           = "11112222" // TODO: use secure pin (not this one)
+	sensorDriver     = "bme280"
+	powerDriver      = "uhubctl"
+	calibrationPath  = "hk-calibration.json"
+	// remoteDriver marks remote nodes, they aren't opened from the sensors registry
+	remoteDriver = "remote"
+	// socDriver marks the Raspberry Pi SoC temperature sensor, it's read by the host collector
+	socDriver = "soc"
 )
 
 var revision = "HEAD"
 
+var (
+	bridgeInfo = accessory.Info{
+		Name:         "Raspberry Pi5",
+		SerialNumber: "-",
+		Manufacturer: "Raspberry Pi",
+		Model:        "Model 5",
+		Firmware:     "-",
+	}
+	usb2powerInfo = accessory.Info{
+		Name:         "RPi5 usb2.0 power",
+		SerialNumber: "-",
+		Manufacturer: "noname",
+		Model:        "-",
+		Firmware:     "-",
+	}
+)
+
 func main() {
 	setupLogger()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 	log.Info.Printf("🇭🇰 revision: %s", revision)
 
 	db := hap.NewFsStore("./db")
@@ -41,13 +75,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	notify := notifier.NewNtfy(ntfyURL)
+
+	specs := append(withSettings(withHeater(makeSensorSpecs())), makeW1Specs()...)
+	groups := makeGroups()
+	nodes := makeNodes()
+	socSpecs := makeSoCSpecs()
+	roomSpecs := append(append(groupSpecs(specs, groups), nodeSpecs(nodes)...), socSpecs...)
+	rooms := makeRooms(roomSpecs)
+	filters := makeFilters()
+	hostMonitor := makeHost()
+	local := makeGroupSensors(makeClimate(specs, filters, hostMonitor.SoCSensor()), groups, notify)
+	climate := append(local, makeRemoteSensors(nodes, filters)...)
 	server := srv.New(
 		db,
-		makeClimate(),
+		append(climate, makeSoCSensors(socSpecs, hostMonitor)...),
 		makeLight(),
-		makeHkSrv(db),
+		makeHkSrv(db, rooms),
 		m,
-		notifier.NewNtfy(ntfyURL),
+		notify,
+		makeBroker(roomSpecs, rooms),
+		hostMonitor,
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -92,28 +140,461 @@ func graceful(cancel context.CancelFunc, dumpFn metrics.DumpFn) {
 	os.Exit(0)
 }
 
+// makeMetrics keeps metrics on disk in METRICS_DIR, or in memory with an hourly dump if METRICS_STORAGE is "mem".
+// The dump is imported into an empty disk storage, so the history isn't lost on the switch.
+// On disk raw values are rolled up into 5 minute rollups kept for METRICS_ROLLUP_RETENTION,
+// and then into hourly ones kept forever.
 func makeMetrics() (m srv.Metrics, dump metrics.DumpFn) {
-	return metrics.New(
-		metrics.WithRetention(metricsRetention),
-		metrics.WithBackup(),
-		metrics.WithAutosave(60*time.Minute),
+	retention, err := time.ParseDuration(getFromEnv("METRICS_RETENTION", metricsRetention.String()))
+	if err != nil {
+		log.Erro.Printf("can't parse METRICS_RETENTION: %s", err.Error())
+		os.Exit(1)
+	}
+
+	switch storage := getFromEnv("METRICS_STORAGE", "disk"); storage {
+	case "mem":
+		return metrics.New(
+			metrics.WithRetention(retention),
+			metrics.WithBackup(),
+			metrics.WithAutosave(60*time.Minute),
+		)
+	case "disk":
+	default:
+		log.Erro.Printf("unknown METRICS_STORAGE %q, want disk or mem", storage)
+		os.Exit(1)
+	}
+
+	fineRetention, err := time.ParseDuration(getFromEnv("METRICS_ROLLUP_RETENTION", rollupRetention.String()))
+	if err != nil {
+		log.Erro.Printf("can't parse METRICS_ROLLUP_RETENTION: %s", err.Error())
+		os.Exit(1)
+	}
+
+	dir := getFromEnv("METRICS_DIR", metricsDir)
+	disk, err := metrics.OpenDisk(dir)
+	if err != nil {
+		log.Erro.Printf("can't open metrics storage: %s", err.Error())
+		os.Exit(1)
+	}
+	fine, err := metrics.OpenDisk(filepath.Join(dir, "5m"))
+	if err != nil {
+		log.Erro.Printf("can't open 5m rollups storage: %s", err.Error())
+		os.Exit(1)
+	}
+	coarse, err := metrics.OpenDisk(filepath.Join(dir, "1h"))
+	if err != nil {
+		log.Erro.Printf("can't open hourly rollups storage: %s", err.Error())
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(metrics.DumpPath); err == nil && disk.Len() == 0 {
+		n, err := metrics.ImportDump(disk, metrics.DumpPath)
+		if err != nil {
+			log.Erro.Printf("can't import metrics dump: %s", err.Error())
+			os.Exit(1)
+		}
+		if err := os.Rename(metrics.DumpPath, metrics.DumpPath+".imported"); err != nil {
+			log.Erro.Printf("can't rename imported dump: %s", err.Error())
+		}
+		log.Info.Printf("imported %d values from %s", n, metrics.DumpPath)
+	}
+
+	gauges := metrics.NewGauges(disk, retention,
+		metrics.WithTier(5*time.Minute, fineRetention, fine),
+		metrics.WithTier(time.Hour, 0, coarse),
 	)
+
+	return gauges, gauges.Close
+}
+
+// makeSensorSpecs reads sensors from SENSORS env, or makes a single legacy sensor
+// from I2C_BUS and I2C_ADDR if SENSORS isn't set
+func makeSensorSpecs() []sensors.Spec {
+	if raw := getFromEnv("SENSORS", ""); raw != "" {
+		specs, err := sensors.ParseSpecs(raw, getFromEnv("SENSOR_DRIVER", sensorDriver))
+		if err != nil {
+			log.Erro.Printf("can't parse SENSORS: %s", err.Error())
+			os.Exit(1)
+		}
+
+		return specs
+	}
+
+	bus, err := strconv.Atoi(getFromEnv("I2C_BUS", strconv.Itoa(sensors.DefaultBus)))
+	if err != nil {
+		log.Erro.Printf("can't parse I2C_BUS: %s", err.Error())
+		os.Exit(1)
+	}
+
+	addr, err := sensors.ParseAddr(getFromEnv("I2C_ADDR", "auto"))
+	if err != nil {
+		log.Erro.Printf("can't parse I2C_ADDR: %s", err.Error())
+		os.Exit(1)
+	}
+
+	// empty ID keeps metric keys of the single sensor setup
+	return []sensors.Spec{{
+		ID:     "",
+		Room:   "Home",
+		Bus:    bus,
+		Addr:   addr,
+		Driver: getFromEnv("SENSOR_DRIVER", sensorDriver),
+	}}
 }
 
-func makeClimate() srv.ClimateSensor {
-	bme280, err := sensors.NewBME280()
+// withHeater sets BME680 heater profile from BME680_HEATER env, e.g. "320:150ms"
+func withHeater(specs []sensors.Spec) []sensors.Spec {
+	raw := getFromEnv("BME680_HEATER", "")
+	if raw == "" {
+		return specs
+	}
+
+	heater, err := sensors.ParseHeaterProfile(raw)
 	if err != nil {
-		log.Erro.Printf("can't create BME280 sensor: %s", err.Error())
+		log.Erro.Printf("can't parse BME680_HEATER: %s", err.Error())
 		os.Exit(1)
 	}
+	for i := range specs {
+		specs[i].Heater = heater
+	}
 
-	return bme280
+	return specs
+}
+
+// withSettings sets BMx measurement settings from BMX_SETTINGS env by sensor ID, "*" is for all others,
+// e.g. "*=mode:normal,standby:1s;kitchen=t:1,p:1,h:1"
+func withSettings(specs []sensors.Spec) []sensors.Spec {
+	settings, err := sensors.ParseSettingsByID(getFromEnv("BMX_SETTINGS", ""))
+	if err != nil {
+		log.Erro.Printf("can't parse BMX_SETTINGS: %s", err.Error())
+		os.Exit(1)
+	}
+
+	for i := range specs {
+		if s, ok := settings[specs[i].ID]; ok {
+			specs[i].Settings = s
+		} else if s, ok := settings["*"]; ok {
+			specs[i].Settings = s
+		}
+	}
+
+	return specs
+}
+
+// makeW1Specs finds DS18B20 probes on 1-Wire bus, W1_PROBES names them by serial: "28-0316a2794aff:Outdoor,..."
+func makeW1Specs() []sensors.Spec {
+	rooms, err := sensors.ParseW1Rooms(getFromEnv("W1_PROBES", ""))
+	if err != nil {
+		log.Erro.Printf("can't parse W1_PROBES: %s", err.Error())
+		os.Exit(1)
+	}
+
+	specs, err := sensors.DiscoverDS18B20(getFromEnv("W1_ROOT", sensors.DefaultW1Root), rooms)
+	if err != nil {
+		log.Erro.Printf("can't discover 1-Wire probes: %s", err.Error())
+		os.Exit(1)
+	}
+	for _, spec := range specs {
+		log.Info.Printf("found DS18B20 probe %s in %q", spec.ID, spec.Room)
+	}
+
+	return specs
+}
+
+// makeGroups reads redundant groups of local sensors from GROUPS env: "id:room:member+member,..."
+func makeGroups() []srv.GroupSpec {
+	groups, err := srv.ParseGroups(getFromEnv("GROUPS", ""))
+	if err != nil {
+		log.Erro.Printf("can't parse GROUPS: %s", err.Error())
+		os.Exit(1)
+	}
+
+	return groups
+}
+
+// groupSpecs replaces specs of group members with the group in place of the first member,
+// the group gets accessories of the first member driver
+func groupSpecs(specs []sensors.Spec, groups []srv.GroupSpec) []sensors.Spec {
+	byID := make(map[string]sensors.Spec, len(specs))
+	for _, spec := range specs {
+		byID[spec.ID] = spec
+	}
+
+	first := make(map[string]srv.GroupSpec)
+	member := make(map[string]bool)
+	for _, g := range groups {
+		for _, id := range g.Members {
+			if _, ok := byID[id]; !ok {
+				log.Erro.Printf("member %q of group %q isn't in SENSORS", id, g.ID)
+				os.Exit(1)
+			}
+			member[id] = true
+		}
+		first[g.Members[0]] = g
+	}
+
+	grouped := make([]sensors.Spec, 0, len(specs))
+	for _, spec := range specs {
+		if g, ok := first[spec.ID]; ok {
+			grouped = append(grouped, sensors.Spec{ID: g.ID, Room: g.Room, Driver: spec.Driver})
+			continue
+		}
+		if !member[spec.ID] {
+			grouped = append(grouped, spec)
+		}
+	}
+
+	return grouped
+}
+
+// makeGroupSensors replaces group members with groups the same way groupSpecs does.
+// GROUP_TOLERANCE and GROUP_DISAGREE_FOR set when members are reported to disagree.
+func makeGroupSensors(climate []srv.Sensor, groups []srv.GroupSpec, n srv.Notifier) []srv.Sensor {
+	if len(groups) == 0 {
+		return climate
+	}
+
+	tolerance, err := srv.ParseTolerance(getFromEnv("GROUP_TOLERANCE", ""))
+	if err != nil {
+		log.Erro.Printf("can't parse GROUP_TOLERANCE: %s", err.Error())
+		os.Exit(1)
+	}
+	disagreeFor, err := time.ParseDuration(getFromEnv("GROUP_DISAGREE_FOR", srv.DefaultDisagreeFor.String()))
+	if err != nil {
+		log.Erro.Printf("can't parse GROUP_DISAGREE_FOR: %s", err.Error())
+		os.Exit(1)
+	}
+
+	byID := make(map[string]srv.Sensor, len(climate))
+	for _, sensor := range climate {
+		byID[sensor.ID] = sensor
+	}
+
+	first := make(map[string]srv.Sensor)
+	member := make(map[string]bool)
+	for _, g := range groups {
+		members := make([]srv.GroupMember, 0, len(g.Members))
+		for _, id := range g.Members {
+			members = append(members, srv.GroupMember{ID: id, Climate: byID[id].Climate})
+			member[id] = true
+		}
+
+		group, err := srv.NewGroupSensor(g.Room, members, n, srv.WithTolerance(tolerance), srv.WithDisagreeFor(disagreeFor))
+		if err != nil {
+			log.Erro.Printf("can't create group %q: %s", g.ID, err.Error())
+			os.Exit(1)
+		}
+		first[g.Members[0]] = srv.Sensor{ID: g.ID, Room: g.Room, Climate: group, Filters: byID[g.Members[0]].Filters}
+	}
+
+	grouped := make([]srv.Sensor, 0, len(climate))
+	for _, sensor := range climate {
+		if g, ok := first[sensor.ID]; ok {
+			grouped = append(grouped, g)
+			continue
+		}
+		if !member[sensor.ID] {
+			grouped = append(grouped, sensor)
+		}
+	}
+
+	return grouped
+}
+
+// makeNodes reads remote sensor nodes from NODES env: "id:room:token,..."
+func makeNodes() []srv.NodeSpec {
+	nodes, err := srv.ParseNodes(getFromEnv("NODES", ""))
+	if err != nil {
+		log.Erro.Printf("can't parse NODES: %s", err.Error())
+		os.Exit(1)
+	}
+
+	return nodes
+}
+
+func makeRemoteSensors(nodes []srv.NodeSpec, filters filter.Config) []srv.Sensor {
+	staleAfter, err := time.ParseDuration(getFromEnv("NODE_STALE_AFTER", srv.DefaultStaleAfter.String()))
+	if err != nil {
+		log.Erro.Printf("can't parse NODE_STALE_AFTER: %s", err.Error())
+		os.Exit(1)
+	}
+
+	remote := make([]srv.Sensor, 0, len(nodes))
+	for _, n := range nodes {
+		remote = append(remote, srv.Sensor{
+			ID:      n.ID,
+			Room:    n.Room,
+			Climate: srv.NewRemoteSensor(n.Token, staleAfter),
+			Filters: filters,
+		})
+	}
+
+	return remote
+}
+
+// nodeSpecs describes remote nodes for HomeKit accessories
+func nodeSpecs(nodes []srv.NodeSpec) []sensors.Spec {
+	specs := make([]sensors.Spec, 0, len(nodes))
+	for _, n := range nodes {
+		specs = append(specs, sensors.Spec{ID: n.ID, Room: n.Room, Driver: remoteDriver})
+	}
+
+	return specs
+}
+
+// makeHost collects host metrics, HOST_DISK_PATH is where metrics and HAP db are
+func makeHost() *host.Collector {
+	return host.New(
+		host.WithDiskPath(getFromEnv("HOST_DISK_PATH", ".")),
+		host.WithThermalZone(getFromEnv("HOST_THERMAL_ZONE", host.DefaultThermalZone)),
+	)
+}
+
+// makeSoCSpecs describes the SoC temperature sensor if HOST_HOMEKIT is true
+func makeSoCSpecs() []sensors.Spec {
+	expose, err := strconv.ParseBool(getFromEnv("HOST_HOMEKIT", "false"))
+	if err != nil {
+		log.Erro.Printf("can't parse HOST_HOMEKIT: %s", err.Error())
+		os.Exit(1)
+	}
+	if !expose {
+		return nil
+	}
+
+	return []sensors.Spec{{ID: "soc", Room: bridgeInfo.Name, Driver: socDriver}}
+}
+
+func makeSoCSensors(specs []sensors.Spec, c *host.Collector) []srv.Sensor {
+	soc := make([]srv.Sensor, 0, len(specs))
+	for _, spec := range specs {
+		soc = append(soc, srv.Sensor{ID: spec.ID, Room: spec.Room, Climate: c.SoCSensor()})
+	}
+
+	return soc
+}
+
+// makeFilters reads reading filters from FILTERS env, see filter.ParseConfig
+func makeFilters() filter.Config {
+	filters, err := filter.ParseConfig(getFromEnv("FILTERS", filter.DefaultConfig))
+	if err != nil {
+		log.Erro.Printf("can't parse FILTERS: %s", err.Error())
+		os.Exit(1)
+	}
+
+	filters.KeepRaw, err = strconv.ParseBool(getFromEnv("FILTERS_KEEP_RAW", "false"))
+	if err != nil {
+		log.Erro.Printf("can't parse FILTERS_KEEP_RAW: %s", err.Error())
+		os.Exit(1)
+	}
+
+	return filters
+}
+
+// makeClimate opens local sensors. Sensors listed in SELF_HEATING env, e.g. "home,kitchen" or "*" for all,
+// are compensated for heating by the SoC.
+func makeClimate(specs []sensors.Spec, filters filter.Config, soc sensors.Climate) []srv.Sensor {
+	calibrations, err := sensors.NewCalibrationStore(calibrationPath)
+	if err != nil {
+		log.Erro.Printf("can't load sensor calibrations: %s", err.Error())
+		os.Exit(1)
+	}
+
+	selfHeating := make(map[string]bool)
+	for _, id := range strings.Split(getFromEnv("SELF_HEATING", ""), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			selfHeating[id] = true
+		}
+	}
+
+	climate := make([]srv.Sensor, 0, len(specs))
+	for _, spec := range specs {
+		sensor, err := sensors.Open(spec)
+		if err != nil {
+			log.Erro.Printf("can't create %s sensor for %q: %s", spec.Driver, spec.Room, err.Error())
+			os.Exit(1)
+		}
+
+		var opts []sensors.CalibratedOption
+		if selfHeating["*"] || selfHeating[spec.ID] {
+			opts = append(opts, sensors.WithSoC(soc))
+		}
+
+		climate = append(climate, srv.Sensor{
+			ID:      spec.ID,
+			Room:    spec.Room,
+			Climate: sensors.NewCalibrated(sensor, spec.ID, calibrations, opts...),
+			Filters: filters,
+		})
+	}
+
+	return climate
+}
+
+// makeBroker connects to MQTT broker from MQTT_URL env, e.g. "tcp://localhost:1883", no broker if it isn't set.
+// Rooms are announced to Home Assistant unless MQTT_DISCOVERY is false.
+func makeBroker(specs []sensors.Spec, rooms []homekit.Room) srv.Broker {
+	url := getFromEnv("MQTT_URL", "")
+	if url == "" {
+		return nil
+	}
+
+	opts := []mqtt.Option{
+		mqtt.WithPrefix(getFromEnv("MQTT_PREFIX", mqtt.DefaultPrefix)),
+		mqtt.WithClientID(getFromEnv("MQTT_CLIENT_ID", mqtt.DefaultClientID)),
+		mqtt.WithCredentials(getFromEnv("MQTT_USER", ""), getFromEnv("MQTT_PASSWORD", "")),
+	}
+
+	discovery, err := strconv.ParseBool(getFromEnv("MQTT_DISCOVERY", "true"))
+	if err != nil {
+		log.Erro.Printf("can't parse MQTT_DISCOVERY: %s", err.Error())
+		os.Exit(1)
+	}
+	if discovery {
+		opts = append(opts, mqtt.WithDiscovery(makeDiscovery(specs, rooms)))
+	}
+
+	broker, err := mqtt.New(url, opts...)
+	if err != nil {
+		log.Erro.Printf("can't create MQTT client: %s", err.Error())
+		os.Exit(1)
+	}
+
+	return broker
+}
+
+// makeDiscovery describes for Home Assistant the same things HomeKit gets
+func makeDiscovery(specs []sensors.Spec, rooms []homekit.Room) mqtt.Discovery {
+	discovered := make([]mqtt.DiscoverySensor, 0, len(rooms))
+	for i, r := range rooms {
+		discovered = append(discovered, mqtt.DiscoverySensor{
+			ID:           r.SensorID,
+			Room:         specs[i].Room,
+			Manufacturer: r.Thermometer.Info.Manufacturer.Value(),
+			Model:        r.Thermometer.Info.Model.Value(),
+			Humidity:     r.Humidifier != nil,
+			Pressure:     !r.NoAirPressure,
+			CO2:          r.CO2 != nil,
+			AirQuality:   r.AirQuality != nil,
+		})
+	}
+
+	return mqtt.Discovery{
+		Prefix: getFromEnv("MQTT_DISCOVERY_PREFIX", mqtt.DefaultDiscoveryPrefix),
+		Device: mqtt.Device{
+			Name:         bridgeInfo.Name,
+			Manufacturer: bridgeInfo.Manufacturer,
+			Model:        bridgeInfo.Model,
+			Revision:     revision,
+		},
+		Sensors:   discovered,
+		USB2Power: usb2powerInfo.Name,
+	}
 }
 
 func makeLight() srv.USB2PowerCtrl {
 	// TODO: make two different external devices: required and options,
 	//  in case of fail of optional device setup just skip it.
-	garland, err := light.NewUsbGarland()
+	garland, err := light.Open(getFromEnv("POWER_DRIVER", powerDriver))
 	if err != nil {
 		log.Erro.Printf("can't create USB garland: %s", err.Error())
 		os.Exit(1)
@@ -122,57 +603,142 @@ func makeLight() srv.USB2PowerCtrl {
 	return garland
 }
 
-func makeHkSrv(db hap.Store) *homekit.HapSrv {
+func makeRooms(specs []sensors.Spec) []homekit.Room {
+	rooms := make([]homekit.Room, 0, len(specs))
+	for _, spec := range specs {
+		rooms = append(rooms, makeRoom(spec))
+	}
+
+	return rooms
+}
+
+func makeHkSrv(db hap.Store, rooms []homekit.Room) *homekit.HapSrv {
+	co2Threshold, err := strconv.ParseFloat(getFromEnv("CO2_THRESHOLD", strconv.Itoa(homekit.DefaultCO2Threshold)), 64)
+	if err != nil {
+		log.Erro.Printf("can't parse CO2_THRESHOLD: %s", err.Error())
+		os.Exit(1)
+	}
+
 	hk, err := homekit.NewHapSrv(&homekit.HapSrvOpts{
-		DB:  db,
-		Pin: hapPIN,
-		Bridge: accessory.NewBridge(accessory.Info{
-			Name:         "Raspberry Pi5",
-			SerialNumber: "-",
-			Manufacturer: "Raspberry Pi",
-			Model:        "Model 5",
-			Firmware:     "-",
-		}),
+		DB:           db,
+		Pin:          hapPIN,
+		Bridge:       accessory.NewBridge(bridgeInfo),
+		Rooms:        rooms,
+		CO2Threshold: co2Threshold,
+		USB2Power:    accessory.NewSwitch(usb2powerInfo),
+	})
+	if err != nil {
+		log.Erro.Printf("can't create HAP server: %s", err.Error())
+		os.Exit(1)
+	}
+
+	return hk
+}
+
+// makeRoom makes HomeKit accessories for quantities the sensor driver can measure
+func makeRoom(spec sensors.Spec) homekit.Room {
+	switch spec.Driver {
+	case "ds18b20":
+		return homekit.Room{
+			SensorID: spec.ID,
+			Thermometer: accessory.NewTemperatureSensor(accessory.Info{
+				Name:         spec.Room + " Temperature",
+				SerialNumber: spec.ID,
+				Manufacturer: "maxim",
+				Model:        "DS18B20",
+				Firmware:     "-",
+			}),
+			NoAirPressure: true,
+		}
+	case "scd4x":
+		info := func(kind string) accessory.Info {
+			return accessory.Info{
+				Name:         roomAccessoryName(spec, kind),
+				SerialNumber: "-",
+				Manufacturer: "sensirion",
+				Model:        "SCD4x",
+				Firmware:     "-",
+			}
+		}
+
+		return homekit.Room{
+			SensorID:      spec.ID,
+			Thermometer:   accessory.NewTemperatureSensor(info("Temperature")),
+			Humidifier:    accessory.NewHumidifier(info("Humidity")),
+			NoAirPressure: true,
+			CO2:           homekit.NewCO2Sensor(info("CO2")),
+		}
+	case socDriver:
+		return homekit.Room{
+			SensorID: spec.ID,
+			Thermometer: accessory.NewTemperatureSensor(accessory.Info{
+				Name:         spec.Room + " SoC",
+				SerialNumber: "-",
+				Manufacturer: bridgeInfo.Manufacturer,
+				Model:        bridgeInfo.Model,
+				Firmware:     "-",
+			}),
+			NoAirPressure: true,
+		}
+	case remoteDriver:
+		info := func(kind string) accessory.Info {
+			return accessory.Info{
+				Name:         roomAccessoryName(spec, kind),
+				SerialNumber: spec.ID,
+				Manufacturer: "-",
+				Model:        "remote node",
+				Firmware:     "-",
+			}
+		}
+
+		return homekit.Room{
+			SensorID:    spec.ID,
+			Thermometer: accessory.NewTemperatureSensor(info("Temperature")),
+			Humidifier:  accessory.NewHumidifier(info("Humidity")),
+		}
+	case "bme680":
+		info := func(kind string) accessory.Info {
+			return accessory.Info{
+				Name:         roomAccessoryName(spec, kind),
+				SerialNumber: "-",
+				Manufacturer: "bosch",
+				Model:        "BME680",
+				Firmware:     "-",
+			}
+		}
+
+		return homekit.Room{
+			SensorID:    spec.ID,
+			Thermometer: accessory.NewTemperatureSensor(info("Temperature")),
+			Humidifier:  accessory.NewHumidifier(info("Humidity")),
+			AirQuality:  homekit.NewAirQualitySensor(info("Air Quality")),
+		}
+	}
+
+	return homekit.Room{
+		SensorID: spec.ID,
 		Thermometer: accessory.NewTemperatureSensor(accessory.Info{
-			Name:         "Temperature",
+			Name:         roomAccessoryName(spec, "Temperature"),
 			SerialNumber: "-",
 			Manufacturer: "bosch",
 			Model:        "BME280",
 			Firmware:     "-",
 		}),
 		Humidifier: accessory.NewHumidifier(accessory.Info{
-			Name:         "Humidity",
+			Name:         roomAccessoryName(spec, "Humidity"),
 			SerialNumber: "-",
 			Manufacturer: "bosch",
 			Model:        "BME280",
 			Firmware:     "-",
 		}),
-		USB2Power: accessory.NewSwitch(accessory.Info{
-			Name:         "RPi5 usb2.0 power",
-			SerialNumber: "-",
-			Manufacturer: "noname",
-			Model:        "-",
-			Firmware:     "-",
-		}),
-	})
-	if err != nil {
-		log.Erro.Printf("can't create HAP server: %s", err.Error())
-		os.Exit(1)
 	}
-
-	return hk
 }
 
-func setupLogger() {
-	log.Debg.Off()
-
-	err := logger.ChangePackageLogLevel("i2c", logger.InfoLevel)
-	if err != nil {
-		log.Erro.Printf("can't setup i2c logger to INTO: %s", err.Error())
+// roomAccessoryName keeps the old accessory names for the single sensor setup
+func roomAccessoryName(spec sensors.Spec, kind string) string {
+	if spec.ID == "" {
+		return kind
 	}
 
-	err = logger.ChangePackageLogLevel("bsbmp", logger.InfoLevel)
-	if err != nil {
-		log.Erro.Printf("can't setup bsbmp logger to INTO: %s", err.Error())
-	}
+	return spec.Room + " " + kind
 }
@@ -7,6 +7,8 @@ require (
 	github.com/d2r2/go-bsbmp v0.0.0-20190515110334-3b4b3aea8375
 	github.com/d2r2/go-i2c v0.0.0-20191123181816-73a8a799d6bc
 	github.com/d2r2/go-logger v0.0.0-20210606094344-60e9d1233e22
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/mochi-mqtt/server/v2 v2.4.0
 	github.com/stretchr/testify v1.8.1
 	golang.org/x/sync v0.2.0
 )
@@ -15,9 +17,10 @@ require (
 	github.com/brutella/dnssd v1.2.11 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/go-chi/chi v1.5.4 // indirect
+	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/miekg/dns v1.1.54 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/objx v0.5.0 // indirect
+	github.com/rs/xid v1.4.0 // indirect
 	github.com/tadglines/go-pkgs v0.0.0-20210623144937-b983b20f54f9 // indirect
 	github.com/xiam/to v0.0.0-20200126224905-d60d31e03561 // indirect
 	golang.org/x/crypto v0.0.0-20220131195533-30dcbda58838 // indirect
@@ -0,0 +1,130 @@
+// Package derived computes psychrometric values from raw temperature,
+// relative humidity and pressure readings.
+package derived
+
+import "math"
+
+const (
+	DewPointKey    = "dew_point"
+	AbsHumidityKey = "absolute_humidity"
+	HumidexKey     = "humidex"
+	HeatIndexKey   = "heat_index"
+	MixingRatioKey = "mixing_ratio"
+)
+
+// Magnus formula coefficients (Sonntag 1990), valid for -45..60 °C over water
+const (
+	magnusA = 6.112 // hPa
+	magnusB = 17.62
+	magnusC = 243.12 // °C
+)
+
+// Values are derived from a single reading, unavailable values are NaN
+type Values struct {
+	DewPoint    float64 // °C
+	AbsHumidity float64 // g/m³
+	Humidex     float64 // dimensionless, "feels like" °C
+	HeatIndex   float64 // °C
+	MixingRatio float64 // g/kg of dry air
+}
+
+// Compute calculates all derived values, t in °C, rh in %, p in hPa.
+// If rh is NaN everything is NaN, if p is NaN only MixingRatio is NaN.
+func Compute(t, rh, p float64) Values {
+	if math.IsNaN(t) || math.IsNaN(rh) {
+		nan := math.NaN()
+		return Values{DewPoint: nan, AbsHumidity: nan, Humidex: nan, HeatIndex: nan, MixingRatio: nan}
+	}
+
+	return Values{
+		DewPoint:    DewPoint(t, rh),
+		AbsHumidity: AbsoluteHumidity(t, rh),
+		Humidex:     Humidex(t, rh),
+		HeatIndex:   HeatIndex(t, rh),
+		MixingRatio: MixingRatio(t, rh, p),
+	}
+}
+
+// Gauges returns values with their metric keys, NaN values are skipped
+func (v Values) Gauges() map[string]float64 {
+	all := map[string]float64{
+		DewPointKey:    v.DewPoint,
+		AbsHumidityKey: v.AbsHumidity,
+		HumidexKey:     v.Humidex,
+		HeatIndexKey:   v.HeatIndex,
+		MixingRatioKey: v.MixingRatio,
+	}
+
+	gauges := make(map[string]float64, len(all))
+	for k, val := range all {
+		if !math.IsNaN(val) {
+			gauges[k] = val
+		}
+	}
+
+	return gauges
+}
+
+// SaturationVaporPressure returns saturation vapor pressure over water in hPa
+func SaturationVaporPressure(t float64) float64 {
+	return magnusA * math.Exp(magnusB*t/(magnusC+t))
+}
+
+// VaporPressure returns actual vapor pressure in hPa
+func VaporPressure(t, rh float64) float64 {
+	return rh / 100 * SaturationVaporPressure(t)
+}
+
+// DewPoint returns the temperature in °C the air must be cooled to for saturation
+func DewPoint(t, rh float64) float64 {
+	gamma := math.Log(rh/100) + magnusB*t/(magnusC+t)
+
+	return magnusC * gamma / (magnusB - gamma)
+}
+
+// AbsoluteHumidity returns the mass of water vapor in g per m³ of air
+func AbsoluteHumidity(t, rh float64) float64 {
+	// 216.7 = 100 (hPa -> Pa) * 1000 (kg -> g) / 461.5 (specific gas constant of water vapor)
+	return 216.7 * VaporPressure(t, rh) / (273.15 + t)
+}
+
+// Humidex returns Canadian humidex index (Masterton & Richardson, 1979)
+func Humidex(t, rh float64) float64 {
+	td := DewPoint(t, rh)
+	e := 6.11 * math.Exp(5417.7530*(1/273.16-1/(273.15+td)))
+
+	return t + 0.5555*(e-10)
+}
+
+// HeatIndex returns NWS heat index in °C (Rothfusz regression with adjustments)
+func HeatIndex(t, rh float64) float64 {
+	tf := t*9/5 + 32
+
+	// simple formula is good enough for low values
+	hi := 0.5 * (tf + 61 + (tf-68)*1.2 + rh*0.094)
+	if (hi+tf)/2 >= 80 {
+		hi = -42.379 + 2.04901523*tf + 10.14333127*rh -
+			0.22475541*tf*rh - 0.00683783*tf*tf - 0.05481717*rh*rh +
+			0.00122874*tf*tf*rh + 0.00085282*tf*rh*rh - 0.00000199*tf*tf*rh*rh
+
+		switch {
+		case rh < 13 && tf >= 80 && tf <= 112:
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(tf-95))/17)
+		case rh > 85 && tf >= 80 && tf <= 87:
+			hi += (rh - 85) / 10 * (87 - tf) / 5
+		}
+	}
+
+	return (hi - 32) * 5 / 9
+}
+
+// MixingRatio returns mass of water vapor per mass of dry air in g/kg, p in hPa
+func MixingRatio(t, rh, p float64) float64 {
+	if math.IsNaN(p) {
+		return math.NaN()
+	}
+	e := VaporPressure(t, rh)
+
+	// 621.97 = 1000 * Mw/Md (molar mass ratio of water vapor to dry air)
+	return 621.97 * e / (p - e)
+}
@@ -0,0 +1,33 @@
+package derived
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompute(t *testing.T) {
+	v := Compute(25, 50, 1013.25)
+
+	assert.InDelta(t, 13.85, v.DewPoint, 0.05)
+	assert.InDelta(t, 11.5, v.AbsHumidity, 0.1)
+	assert.InDelta(t, 28.3, v.Humidex, 0.1)
+	assert.InDelta(t, 24.9, v.HeatIndex, 0.2)
+	assert.InDelta(t, 9.9, v.MixingRatio, 0.1)
+}
+
+func TestHeatIndexHot(t *testing.T) {
+	// NWS table: 90 °F and 70 % RH feels like 106 °F
+	assert.InDelta(t, (106.0-32)*5/9, HeatIndex((90.0-32)*5/9, 70), 0.5)
+}
+
+func TestComputeUnavailable(t *testing.T) {
+	v := Compute(25, math.NaN(), 1013.25)
+	assert.Empty(t, v.Gauges())
+
+	v = Compute(25, 50, math.NaN())
+	assert.True(t, math.IsNaN(v.MixingRatio))
+	assert.Len(t, v.Gauges(), 4)
+	assert.NotContains(t, v.Gauges(), MixingRatioKey)
+}
@@ -0,0 +1,161 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultConfig drops readings out of the sensors operating range and spikes of bus glitches
+const DefaultConfig = "current_temperature=range:-40:85,rate:2;" +
+	"current_humidity=range:0:100,rate:10;" +
+	"current_pressure=range:300:1100,rate:5;" +
+	"current_co2=range:0:40000"
+
+// Config keeps pipelines by metric keys, a key is a quantity for all sensors ("current_temperature")
+// or a quantity of a single sensor ("kitchen/current_temperature")
+type Config struct {
+	pipelines map[string]string
+	// KeepRaw keeps unfiltered values as well, e.g. to tune filters
+	KeepRaw bool
+}
+
+// ParseConfig parses pipelines separated by semicolon: "key=stage,stage;key=stage", see ParseStages
+func ParseConfig(raw string) (Config, error) {
+	c := Config{pipelines: make(map[string]string)}
+
+	for _, item := range strings.Split(raw, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		key, stages, ok := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return Config{}, fmt.Errorf("can't parse filters %q: want key=stage,stage", item)
+		}
+		if _, err := ParseStages(stages); err != nil {
+			return Config{}, fmt.Errorf("can't parse filters of %s: %w", key, err)
+		}
+		c.pipelines[key] = stages
+	}
+
+	return c, nil
+}
+
+// Pipeline makes a new pipeline for the first configured key, nil if there is none
+func (c Config) Pipeline(keys ...string) *Pipeline {
+	for _, key := range keys {
+		raw, ok := c.pipelines[key]
+		if !ok {
+			continue
+		}
+
+		// stages are validated by ParseConfig
+		stages, _ := ParseStages(raw)
+		return NewPipeline(stages...)
+	}
+
+	return nil
+}
+
+// ParseStages parses comma separated stages:
+//
+//	range:MIN:MAX             Range
+//	rate:MAX_PER_MIN[:N]      RateOfChange, N is MaxRejects
+//	median:N                  Median
+//	ema:ALPHA                 EMA
+//	kalman:Q:R                Kalman
+func ParseStages(raw string) ([]Stage, error) {
+	var stages []Stage
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		s, err := parseStage(item)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse stage %q: %w", item, err)
+		}
+		stages = append(stages, s)
+	}
+
+	return stages, nil
+}
+
+func parseStage(raw string) (Stage, error) {
+	fields := strings.Split(raw, ":")
+	name, args := fields[0], make([]float64, 0, len(fields)-1)
+	for _, f := range fields[1:] {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		// NaN passes every bound check and makes values unmeasured
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("argument %q isn't a finite number", f)
+		}
+		args = append(args, v)
+	}
+
+	want := func(n ...int) error {
+		for _, v := range n {
+			if len(args) == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s wants %v arguments, got %d", name, n, len(args))
+	}
+
+	switch name {
+	case "range":
+		if err := want(2); err != nil {
+			return nil, err
+		}
+		if args[0] > args[1] {
+			return nil, fmt.Errorf("min %g is greater than max %g", args[0], args[1])
+		}
+		return &Range{Min: args[0], Max: args[1]}, nil
+	case "rate":
+		if err := want(1, 2); err != nil {
+			return nil, err
+		}
+		if args[0] <= 0 {
+			return nil, fmt.Errorf("max rate must be positive")
+		}
+		r := &RateOfChange{MaxPerMinute: args[0]}
+		if len(args) == 2 {
+			r.MaxRejects = int(args[1])
+		}
+		return r, nil
+	case "median":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		if args[0] < 1 {
+			return nil, fmt.Errorf("window must be at least 1")
+		}
+		return &Median{N: int(args[0])}, nil
+	case "ema":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		if args[0] <= 0 || args[0] > 1 {
+			return nil, fmt.Errorf("alpha must be in (0, 1]")
+		}
+		return &EMA{Alpha: args[0]}, nil
+	case "kalman":
+		if err := want(2); err != nil {
+			return nil, err
+		}
+		if args[0] < 0 || args[1] <= 0 {
+			return nil, fmt.Errorf("noise must be positive")
+		}
+		return &Kalman{Q: args[0], R: args[1]}, nil
+	}
+
+	return nil, fmt.Errorf("unknown stage %q", name)
+}
@@ -0,0 +1,176 @@
+// Package filter checks readings for plausibility and smooths them before they get to metrics and HomeKit.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// ErrRejected is returned for implausible samples, e.g. a spike after a bus glitch
+var ErrRejected = errors.New("sample rejected")
+
+// Stage processes samples of a single quantity of a single sensor, it keeps the state between samples
+type Stage interface {
+	// Apply returns the value for the next stage, or an error wrapping ErrRejected to drop the sample
+	Apply(t time.Time, v float64) (float64, error)
+}
+
+// Range rejects samples out of [Min, Max], e.g. out of the sensor operating range
+type Range struct {
+	Min, Max float64
+}
+
+func (r *Range) Apply(_ time.Time, v float64) (float64, error) {
+	if v < r.Min || v > r.Max {
+		return v, fmt.Errorf("%w: %g is out of [%g, %g]", ErrRejected, v, r.Min, r.Max)
+	}
+
+	return v, nil
+}
+
+// DefaultMaxRejects is how many samples in a row RateOfChange rejects before it takes the new level
+const DefaultMaxRejects = 3
+
+// RateOfChange rejects samples changed faster than MaxPerMinute since the last accepted one.
+// After MaxRejects (DefaultMaxRejects if not set) rejects in a row the value has really changed,
+// so the sample is accepted.
+type RateOfChange struct {
+	MaxPerMinute float64
+	MaxRejects   int
+
+	last    float64
+	lastT   time.Time
+	rejects int
+}
+
+func (r *RateOfChange) Apply(t time.Time, v float64) (float64, error) {
+	maxRejects := r.MaxRejects
+	if maxRejects <= 0 {
+		maxRejects = DefaultMaxRejects
+	}
+
+	if r.lastT.IsZero() || r.rejects >= maxRejects {
+		r.accept(t, v)
+		return v, nil
+	}
+
+	minutes := t.Sub(r.lastT).Minutes()
+	if minutes > 0 {
+		if rate := math.Abs(v-r.last) / minutes; rate > r.MaxPerMinute {
+			r.rejects++
+			return v, fmt.Errorf("%w: %g changed by %0.2f/min, max %g/min", ErrRejected, v, rate, r.MaxPerMinute)
+		}
+	}
+
+	r.accept(t, v)
+
+	return v, nil
+}
+
+func (r *RateOfChange) accept(t time.Time, v float64) {
+	r.last, r.lastT, r.rejects = v, t, 0
+}
+
+// Median returns the median of the last N samples, it drops single outliers
+type Median struct {
+	N int
+
+	window []float64
+}
+
+func (m *Median) Apply(_ time.Time, v float64) (float64, error) {
+	m.window = append(m.window, v)
+	if len(m.window) > m.N {
+		m.window = m.window[len(m.window)-m.N:]
+	}
+
+	sorted := append([]float64(nil), m.window...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2, nil
+	}
+
+	return sorted[mid], nil
+}
+
+// EMA is exponential moving average, Alpha in (0, 1] is the weight of a new sample
+type EMA struct {
+	Alpha float64
+
+	v   float64
+	has bool
+}
+
+func (e *EMA) Apply(_ time.Time, v float64) (float64, error) {
+	if !e.has {
+		e.v, e.has = v, true
+		return v, nil
+	}
+
+	e.v += e.Alpha * (v - e.v)
+
+	return e.v, nil
+}
+
+// Kalman is one-dimensional Kalman filter for a slowly changing value.
+// Q is the process noise, how much the value changes between samples, R is the measurement noise.
+type Kalman struct {
+	Q, R float64
+
+	x, p float64
+	has  bool
+}
+
+func (k *Kalman) Apply(_ time.Time, v float64) (float64, error) {
+	if !k.has {
+		k.x, k.p, k.has = v, k.R, true
+		return v, nil
+	}
+
+	k.p += k.Q
+	gain := k.p / (k.p + k.R)
+	k.x += gain * (v - k.x)
+	k.p *= 1 - gain
+
+	return k.x, nil
+}
+
+// Pipeline runs samples through stages, a rejected sample doesn't get to the next stages
+type Pipeline struct {
+	stages   []Stage
+	rejected int
+}
+
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Apply processes a sample, NaN means the quantity isn't measured and passes as it is
+func (p *Pipeline) Apply(t time.Time, v float64) (float64, error) {
+	if math.IsNaN(v) {
+		return v, nil
+	}
+
+	for _, s := range p.stages {
+		var err error
+		v, err = s.Apply(t, v)
+		if err != nil {
+			if errors.Is(err, ErrRejected) {
+				p.rejected++
+			}
+			return v, err
+		}
+	}
+
+	return v, nil
+}
+
+// Rejected returns the number of rejected samples
+func (p *Pipeline) Rejected() int {
+	return p.rejected
+}
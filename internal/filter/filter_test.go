@@ -0,0 +1,128 @@
+package filter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// apply runs samples taken every 30 seconds through the stage
+func apply(s Stage, vals ...float64) (out []float64, rejected []bool) {
+	for i, v := range vals {
+		got, err := s.Apply(t0.Add(time.Duration(i)*30*time.Second), v)
+		out = append(out, got)
+		rejected = append(rejected, err != nil)
+	}
+
+	return out, rejected
+}
+
+func TestRange(t *testing.T) {
+	_, rejected := apply(&Range{Min: -40, Max: 85}, -40, 21.5, 85, 85.1, -41)
+	assert.Equal(t, []bool{false, false, false, true, true}, rejected)
+
+	_, err := (&Range{Min: 0, Max: 100}).Apply(t0, 101)
+	require.ErrorIs(t, err, ErrRejected)
+	assert.EqualError(t, err, "sample rejected: 101 is out of [0, 100]")
+}
+
+func TestRateOfChange(t *testing.T) {
+	// 2 °C/min is 1 °C between samples
+	_, rejected := apply(&RateOfChange{MaxPerMinute: 2}, 21, 21.5, 60, 22, 22.5)
+	assert.Equal(t, []bool{false, false, true, false, false}, rejected, "spike is dropped")
+
+	_, rejected = apply(&RateOfChange{MaxPerMinute: 2, MaxRejects: 2}, 21, 30, 30, 30, 30.2)
+	assert.Equal(t, []bool{false, true, true, false, false}, rejected, "new level is taken after max rejects")
+
+	_, rejected = apply(&RateOfChange{MaxPerMinute: 2}, 21, 30, 30, 30, 30, 30.1)
+	assert.Equal(t, []bool{false, true, true, true, false, false}, rejected, "default max rejects")
+}
+
+func TestMedian(t *testing.T) {
+	out, _ := apply(&Median{N: 3}, 21, 22, 60, 23, 24)
+	assert.Equal(t, []float64{21, 21.5, 22, 23, 24}, out)
+}
+
+func TestEMA(t *testing.T) {
+	out, _ := apply(&EMA{Alpha: 0.5}, 20, 22, 22, 22)
+	assert.Equal(t, []float64{20, 21, 21.5, 21.75}, out)
+}
+
+func TestKalman(t *testing.T) {
+	k := &Kalman{Q: 0.001, R: 0.5}
+	vals := make([]float64, 0, 100)
+	for i := 0; i < 100; i++ {
+		// noisy constant
+		vals = append(vals, 21+0.5*math.Sin(float64(i)*1.7))
+	}
+	out, _ := apply(k, vals...)
+
+	assert.Equal(t, vals[0], out[0])
+	for _, v := range out[50:] {
+		assert.InDelta(t, 21, v, 0.1)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	p := NewPipeline(&Range{Min: -40, Max: 85}, &RateOfChange{MaxPerMinute: 2}, &EMA{Alpha: 0.5})
+
+	out, rejected := apply(p, 20, 20.5, 100, 60, 21)
+	assert.Equal(t, []bool{false, false, true, true, false}, rejected)
+	assert.Equal(t, []float64{20, 20.25, 100, 60, 20.625}, out)
+	assert.Equal(t, 2, p.Rejected())
+
+	v, err := p.Apply(t0, math.NaN())
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(v))
+	assert.Equal(t, 2, p.Rejected())
+}
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig("current_temperature=range:-40:85,rate:2:5; kitchen/current_temperature=median:3,ema:0.3;" +
+		"current_co2=kalman:0.1:20")
+	require.NoError(t, err)
+
+	p := c.Pipeline("kitchen/current_temperature", "current_temperature")
+	require.NotNil(t, p)
+	assert.Equal(t, []Stage{&Median{N: 3}, &EMA{Alpha: 0.3}}, p.stages)
+
+	p = c.Pipeline("office/current_temperature", "current_temperature")
+	require.NotNil(t, p)
+	assert.Equal(t, []Stage{&Range{Min: -40, Max: 85}, &RateOfChange{MaxPerMinute: 2, MaxRejects: 5}}, p.stages)
+
+	assert.Nil(t, c.Pipeline("current_humidity"))
+	assert.NotSame(t, c.Pipeline("current_co2"), c.Pipeline("current_co2"), "every sensor has its own state")
+
+	_, err = ParseConfig(DefaultConfig)
+	require.NoError(t, err)
+
+	c, err = ParseConfig("")
+	require.NoError(t, err)
+	assert.Nil(t, c.Pipeline("current_temperature"))
+	assert.Nil(t, Config{}.Pipeline("current_temperature"))
+
+	for _, raw := range []string{
+		"range:0:1",
+		"=range:0:1",
+		"current_temperature=range:1:0",
+		"current_temperature=range:1",
+		"current_temperature=rate:-1",
+		"current_temperature=median:0",
+		"current_temperature=ema:1.5",
+		"current_temperature=kalman:0.1:0",
+		"current_temperature=lowpass:0.1",
+		"current_temperature=ema:x",
+		"current_temperature=ema:nan",
+		"current_temperature=kalman:NaN:1",
+		"current_temperature=range:nan:nan",
+		"current_temperature=range:-inf:inf",
+	} {
+		_, err := ParseConfig(raw)
+		assert.Error(t, err, raw)
+	}
+}
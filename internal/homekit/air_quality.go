@@ -0,0 +1,46 @@
+package homekit
+
+import (
+	"math"
+
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+)
+
+// AirQualitySensor is an accessory with air quality sensor service and VOC density
+type AirQualitySensor struct {
+	*accessory.A
+	AirQualitySensor *service.AirQualitySensor
+	VOCDensity       *characteristic.VOCDensity
+}
+
+func NewAirQualitySensor(info accessory.Info) *AirQualitySensor {
+	a := AirQualitySensor{}
+	a.A = accessory.New(info, accessory.TypeSensor)
+
+	a.AirQualitySensor = service.NewAirQualitySensor()
+	a.VOCDensity = characteristic.NewVOCDensity()
+	a.AirQualitySensor.AddC(a.VOCDensity.C)
+	a.AddS(a.AirQualitySensor.S)
+
+	return &a
+}
+
+// airQualityLevel maps IAQ index (0-500) to HomeKit air quality levels like Bosch does
+func airQualityLevel(iaq float64) int {
+	switch {
+	case math.IsNaN(iaq):
+		return characteristic.AirQualityUnknown
+	case iaq <= 50:
+		return characteristic.AirQualityExcellent
+	case iaq <= 100:
+		return characteristic.AirQualityGood
+	case iaq <= 150:
+		return characteristic.AirQualityFair
+	case iaq <= 200:
+		return characteristic.AirQualityInferior
+	default:
+		return characteristic.AirQualityPoor
+	}
+}
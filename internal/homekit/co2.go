@@ -0,0 +1,29 @@
+package homekit
+
+import (
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+)
+
+// DefaultCO2Threshold is CO2 concentration in ppm considered abnormal (a room needs ventilation)
+const DefaultCO2Threshold = 1000
+
+// CO2Sensor is an accessory with carbon dioxide sensor service
+type CO2Sensor struct {
+	*accessory.A
+	CO2Sensor *service.CarbonDioxideSensor
+	CO2Level  *characteristic.CarbonDioxideLevel
+}
+
+func NewCO2Sensor(info accessory.Info) *CO2Sensor {
+	a := CO2Sensor{}
+	a.A = accessory.New(info, accessory.TypeSensor)
+
+	a.CO2Sensor = service.NewCarbonDioxideSensor()
+	a.CO2Level = characteristic.NewCarbonDioxideLevel()
+	a.CO2Sensor.AddC(a.CO2Level.C)
+	a.AddS(a.CO2Sensor.S)
+
+	return &a
+}
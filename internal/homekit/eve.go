@@ -0,0 +1,31 @@
+package homekit
+
+import (
+	"github.com/brutella/hap/characteristic"
+)
+
+// Eve custom characteristics, see:
+// https://gist.github.com/simont77/3f4d4330fa55b83f8ca96388d9004e7d
+const (
+	TypeEveAirPressure = "E863F10F-079E-48FF-8F27-9C2605A29F52"
+)
+
+// EveAirPressure is an Eve custom characteristic for air pressure in hPa.
+// Apple Home ignores it, but Eve app (and others) shows it.
+type EveAirPressure struct {
+	*characteristic.Float
+}
+
+func NewEveAirPressure() *EveAirPressure {
+	c := characteristic.NewFloat(TypeEveAirPressure)
+	c.Format = characteristic.FormatFloat
+	c.Permissions = []string{characteristic.PermissionRead, characteristic.PermissionEvents}
+	c.Description = "Air Pressure"
+	c.SetMinValue(700)
+	c.SetMaxValue(1100)
+	c.SetStepValue(0.1)
+	c.SetValue(1013.25)
+	c.Unit = "hPa"
+
+	return &EveAirPressure{c}
+}
@@ -2,47 +2,118 @@ package homekit
 
 import (
 	"context"
-	"net/http"
+	"fmt"
+	"hash/fnv"
+	"math"
 
 	"github.com/brutella/hap"
 	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
 
 	"github.com/egregors/hk/log"
 )
 
+// Room is a set of climate accessories of a single sensor.
+// Humidifier is optional, e.g. for temperature-only probes.
+type Room struct {
+	SensorID    string
+	Thermometer *accessory.Thermometer
+	Humidifier  *accessory.Humidifier
+	// NoAirPressure skips Eve air pressure characteristic for sensors without barometer
+	NoAirPressure bool
+	// CO2 is optional, for sensors measuring carbon dioxide
+	CO2 *CO2Sensor
+	// AirQuality is optional, for gas sensors
+	AirQuality *AirQualitySensor
+}
+
 type HapSrvOpts struct {
 	DB  hap.Store
 	Pin string
 
-	Bridge      *accessory.Bridge
-	Thermometer *accessory.Thermometer
-	Humidifier  *accessory.Humidifier
-	USB2Power   *accessory.Switch
+	Bridge    *accessory.Bridge
+	Rooms     []Room
+	USB2Power *accessory.Switch
+
+	// CO2Threshold is CO2 concentration in ppm to report as abnormal, DefaultCO2Threshold if not set
+	CO2Threshold float64
 }
 
-type HapSrv struct {
-	srv         *hap.Server
+type room struct {
 	thermometer *accessory.Thermometer
+	airPressure *EveAirPressure
 	humidifier  *accessory.Humidifier
-	usb2power   *accessory.Switch
+	co2         *CO2Sensor
+	airQuality  *AirQualitySensor
+}
+
+type HapSrv struct {
+	srv          *hap.Server
+	rooms        map[string]*room
+	usb2power    *accessory.Switch
+	co2Threshold float64
 }
 
 func NewHapSrv(hapSrvOpts *HapSrvOpts) (*HapSrv, error) {
 	log.Info.Println("make HapSrv")
 
+	if len(hapSrvOpts.Rooms) == 0 {
+		return nil, fmt.Errorf("at least one room is required")
+	}
+
 	// see: https://github.com/brutella/hap/pull/53
 	hapSrvOpts.Bridge.Id = 1
-	hapSrvOpts.Thermometer.Id = 2
-	hapSrvOpts.Humidifier.Id = 3
 	hapSrvOpts.USB2Power.Id = 4
 
-	s, err := hap.NewServer(
-		hapSrvOpts.DB,
-		hapSrvOpts.Bridge.A,
-		hapSrvOpts.Thermometer.A,
-		hapSrvOpts.Humidifier.A,
-		hapSrvOpts.USB2Power.A,
-	)
+	rooms := make(map[string]*room, len(hapSrvOpts.Rooms))
+	bases := make(map[uint64]string, len(hapSrvOpts.Rooms))
+	as := make([]*accessory.A, 0, 2*len(hapSrvOpts.Rooms)+1)
+	for _, r := range hapSrvOpts.Rooms {
+		if _, ok := rooms[r.SensorID]; ok {
+			return nil, fmt.Errorf("duplicate room for sensor %q", r.SensorID)
+		}
+
+		ids := roomAccessoryIDs(r.SensorID)
+		if other, ok := bases[ids.thermometer]; ok {
+			return nil, fmt.Errorf("accessory IDs of sensors %q and %q collide, rename one of them", other, r.SensorID)
+		}
+		bases[ids.thermometer] = r.SensorID
+
+		// IDs are reserved for all accessories, so adding a humidifier later keeps the pairing
+		r.Thermometer.Id = ids.thermometer
+		as = append(as, r.Thermometer.A)
+		if r.Humidifier != nil {
+			r.Humidifier.Id = ids.humidifier
+			as = append(as, r.Humidifier.A)
+		}
+
+		var airPressure *EveAirPressure
+		if !r.NoAirPressure {
+			// air pressure has no native HomeKit service, so attach Eve characteristic to the thermometer
+			airPressure = NewEveAirPressure()
+			r.Thermometer.TempSensor.AddC(airPressure.C)
+		}
+
+		if r.CO2 != nil {
+			r.CO2.Id = ids.co2
+			as = append(as, r.CO2.A)
+		}
+		if r.AirQuality != nil {
+			r.AirQuality.Id = ids.airQuality
+			as = append(as, r.AirQuality.A)
+		}
+
+		rooms[r.SensorID] = &room{
+			thermometer: r.Thermometer,
+			airPressure: airPressure,
+			humidifier:  r.Humidifier,
+			co2:         r.CO2,
+			airQuality:  r.AirQuality,
+		}
+	}
+	as = append(as, hapSrvOpts.USB2Power.A)
+
+	s, err := hap.NewServer(hapSrvOpts.DB, hapSrvOpts.Bridge.A, as...)
 	if err != nil {
 		return nil, err
 	}
@@ -54,33 +125,110 @@ func NewHapSrv(hapSrvOpts *HapSrvOpts) (*HapSrv, error) {
 
 	hapSrvOpts.USB2Power.Switch.On.SetValue(true)
 
+	co2Threshold := hapSrvOpts.CO2Threshold
+	if co2Threshold <= 0 {
+		co2Threshold = DefaultCO2Threshold
+	}
+
 	return &HapSrv{
-		srv:         s,
-		thermometer: hapSrvOpts.Thermometer,
-		humidifier:  hapSrvOpts.Humidifier,
-		usb2power:   hapSrvOpts.USB2Power,
+		srv:          s,
+		rooms:        rooms,
+		usb2power:    hapSrvOpts.USB2Power,
+		co2Threshold: co2Threshold,
 	}, nil
 }
 
+// accessoryIDs are accessory IDs of a room
+type accessoryIDs struct {
+	thermometer, humidifier, co2, airQuality uint64
+}
+
+// roomAccessoryIDs returns accessory IDs derived from the sensor ID, so adding, removing
+// or reordering sensors doesn't break pairing of the others. The legacy sensor with empty ID
+// keeps IDs 2 and 3 (USB2Power has 4), 1000 and 2000 it had as the first room.
+func roomAccessoryIDs(sensorID string) accessoryIDs {
+	if sensorID == "" {
+		return accessoryIDs{thermometer: 2, humidifier: 3, co2: 1000, airQuality: 2000}
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(sensorID))
+	// 4 IDs per room above the ones of the legacy sensor, they fit JSON numbers of HAP clients
+	base := 1<<16 + uint64(h.Sum32())<<2
+
+	return accessoryIDs{thermometer: base, humidifier: base + 1, co2: base + 2, airQuality: base + 3}
+}
+
 func (s *HapSrv) USB2PowerChan() chan bool {
 	log.Debg.Printf("usb2power is %v after the start", s.usb2power.Switch.On.Value())
 
 	ch := make(chan bool)
-	s.usb2power.Switch.On.OnValueUpdate(func(old, new bool, req *http.Request) {
-		log.Debg.Printf("got usb2power %v -> %v\n", old, new)
-		ch <- new
+	// only updates from HomeKit, SetUSB2Power doesn't echo back
+	s.usb2power.Switch.On.OnValueRemoteUpdate(func(on bool) {
+		log.Debg.Printf("got usb2power %v", on)
+		ch <- on
 	})
 
 	// FIXME: nobody close it
 	return ch
 }
 
-func (s *HapSrv) SetCurrentTemperature(t float64) {
-	s.thermometer.TempSensor.CurrentTemperature.SetValue(t)
+// SetUSB2Power shows the power state changed not by HomeKit, e.g. by MQTT command
+func (s *HapSrv) SetUSB2Power(on bool) {
+	s.usb2power.Switch.On.SetValue(on)
+}
+
+func (s *HapSrv) SetCurrentTemperature(sensorID string, t float64) {
+	if r, ok := s.room(sensorID); ok {
+		r.thermometer.TempSensor.CurrentTemperature.SetValue(t)
+	}
+}
+
+func (s *HapSrv) SetCurrentHumidity(sensorID string, h float64) {
+	if r, ok := s.room(sensorID); ok && r.humidifier != nil {
+		r.humidifier.Humidifier.CurrentRelativeHumidity.SetValue(h)
+	}
+}
+
+func (s *HapSrv) SetCurrentPressure(sensorID string, p float64) {
+	if r, ok := s.room(sensorID); ok && r.airPressure != nil {
+		r.airPressure.SetValue(p)
+	}
+}
+
+// SetCurrentCO2 sets CO2 level and marks it abnormal above the threshold
+func (s *HapSrv) SetCurrentCO2(sensorID string, ppm float64) {
+	if r, ok := s.room(sensorID); ok && r.co2 != nil {
+		r.co2.CO2Level.SetValue(ppm)
+		r.co2.CO2Sensor.CarbonDioxideDetected.SetValue(co2Detected(ppm, s.co2Threshold))
+	}
+}
+
+// SetAirQuality sets air quality level from IAQ index and VOC density in µg/m³, NaN values are unknown
+func (s *HapSrv) SetAirQuality(sensorID string, iaq, voc float64) {
+	if r, ok := s.room(sensorID); ok && r.airQuality != nil {
+		r.airQuality.AirQualitySensor.AirQuality.SetValue(airQualityLevel(iaq))
+		if !math.IsNaN(voc) {
+			r.airQuality.VOCDensity.SetValue(voc)
+		}
+	}
 }
 
-func (s *HapSrv) SetCurrentHumidity(h float64) {
-	s.humidifier.Humidifier.CurrentRelativeHumidity.SetValue(h)
+func co2Detected(ppm, threshold float64) int {
+	if ppm >= threshold {
+		return characteristic.CarbonDioxideDetectedCO2LevelsAbnormal
+	}
+
+	return characteristic.CarbonDioxideDetectedCO2LevelsNormal
+}
+
+func (s *HapSrv) room(sensorID string) (*room, bool) {
+	r, ok := s.rooms[sensorID]
+	if !ok {
+		log.Erro.Printf("no HomeKit room for sensor %q", sensorID)
+	}
+
+	return r, ok
 }
 
 func (s *HapSrv) ListenAndServe(ctx context.Context) error {
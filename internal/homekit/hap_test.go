@@ -0,0 +1,19 @@
+package homekit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoomAccessoryIDs(t *testing.T) {
+	assert.Equal(t, accessoryIDs{thermometer: 2, humidifier: 3, co2: 1000, airQuality: 2000}, roomAccessoryIDs(""),
+		"the legacy sensor keeps its pairing")
+
+	kitchen := roomAccessoryIDs("kitchen")
+	assert.Equal(t, kitchen, roomAccessoryIDs("kitchen"), "IDs don't depend on other rooms")
+	assert.Equal(t, kitchen.thermometer+3, kitchen.airQuality)
+	assert.Greater(t, kitchen.thermometer, uint64(2000))
+	assert.Less(t, kitchen.airQuality, uint64(1)<<53)
+	assert.NotEqual(t, kitchen, roomAccessoryIDs("bedroom"))
+}
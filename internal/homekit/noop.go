@@ -10,9 +10,17 @@ func (n NoopHap) USB2PowerChan() chan bool {
 	return nil
 }
 
-func (n NoopHap) SetCurrentTemperature(t float64) {}
+func (n NoopHap) SetCurrentTemperature(sensorID string, t float64) {}
 
-func (n NoopHap) SetCurrentHumidity(h float64) {}
+func (n NoopHap) SetCurrentHumidity(sensorID string, h float64) {}
+
+func (n NoopHap) SetCurrentPressure(sensorID string, p float64) {}
+
+func (n NoopHap) SetCurrentCO2(sensorID string, ppm float64) {}
+
+func (n NoopHap) SetAirQuality(sensorID string, iaq, voc float64) {}
+
+func (n NoopHap) SetUSB2Power(on bool) {}
 
 func (n NoopHap) ListenAndServe(ctx context.Context) error {
 	<-ctx.Done()
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package host
+
+import "errors"
+
+func diskFree(string) (uint64, error) {
+	return 0, errors.New("free disk space isn't supported on this platform")
+}
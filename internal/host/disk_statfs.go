@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package host
+
+import "syscall"
+
+// diskFree returns free bytes of the filesystem available to unprivileged users
+func diskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
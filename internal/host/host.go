@@ -0,0 +1,368 @@
+// Package host collects metrics of the machine hk runs on: Raspberry Pi SoC temperature,
+// throttling, load average, memory, free disk and Wi-Fi link quality.
+package host
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+const (
+	SoCTemperatureKey = "soc_temperature"
+	Load1Key          = "load1"
+	Load5Key          = "load5"
+	Load15Key         = "load15"
+	MemAvailableKey   = "mem_available"
+	MemUsedKey        = "mem_used"
+	DiskFreeKey       = "disk_free"
+	WiFiLinkKey       = "wifi_link"
+	WiFiSignalKey     = "wifi_signal"
+	ThrottledKey      = "throttled"
+)
+
+const (
+	// DefaultThermalZone is the SoC temperature in millidegrees on Raspberry Pi
+	DefaultThermalZone = "/sys/class/thermal/thermal_zone0/temp"
+
+	loadavgPath  = "/proc/loadavg"
+	meminfoPath  = "/proc/meminfo"
+	wirelessPath = "/proc/net/wireless"
+)
+
+// Stats are host metrics of a single collection, unavailable values are NaN
+type Stats struct {
+	T              time.Time
+	SoCTemperature float64 // °C
+	Load1          float64
+	Load5          float64
+	Load15         float64
+	MemAvailable   float64 // MB
+	MemUsed        float64 // % of total
+	DiskFree       float64 // MB
+	// WiFi is the wireless interface, empty if there is none
+	WiFi       string
+	WiFiLink   float64 // link quality as the driver reports it, 0..70 on Raspberry Pi
+	WiFiSignal float64 // dBm
+	// Throttled is unknown if vcgencmd isn't available
+	Throttled      Throttled
+	ThrottledKnown bool
+}
+
+// Gauges returns values with their metric keys, NaN values are skipped
+func (s Stats) Gauges() map[string]float64 {
+	all := map[string]float64{
+		SoCTemperatureKey: s.SoCTemperature,
+		Load1Key:          s.Load1,
+		Load5Key:          s.Load5,
+		Load15Key:         s.Load15,
+		MemAvailableKey:   s.MemAvailable,
+		MemUsedKey:        s.MemUsed,
+		DiskFreeKey:       s.DiskFree,
+		WiFiLinkKey:       s.WiFiLink,
+		WiFiSignalKey:     s.WiFiSignal,
+	}
+	if s.ThrottledKnown {
+		all[ThrottledKey] = float64(s.Throttled)
+	}
+
+	gauges := make(map[string]float64, len(all))
+	for k, val := range all {
+		if !math.IsNaN(val) {
+			gauges[k] = val
+		}
+	}
+
+	return gauges
+}
+
+// Throttled are flags of `vcgencmd get_throttled`, the low bits are current state
+// and the high ones are what happened since boot
+type Throttled uint32
+
+const (
+	UnderVoltage          Throttled = 1 << 0
+	FreqCapped            Throttled = 1 << 1
+	Throttling            Throttled = 1 << 2
+	SoftTempLimit         Throttled = 1 << 3
+	UnderVoltageOccurred  Throttled = 1 << 16
+	FreqCappedOccurred    Throttled = 1 << 17
+	ThrottlingOccurred    Throttled = 1 << 18
+	SoftTempLimitOccurred Throttled = 1 << 19
+)
+
+var throttledNames = []string{"under-voltage", "frequency capped", "throttled", "soft temperature limit"}
+
+func (t Throttled) String() string {
+	if t == 0 {
+		return "ok"
+	}
+
+	var now, occurred []string
+	for i, name := range throttledNames {
+		if t&(1<<i) != 0 {
+			now = append(now, name)
+		}
+		if t&(1<<(16+i)) != 0 {
+			occurred = append(occurred, name)
+		}
+	}
+
+	var parts []string
+	if len(now) > 0 {
+		parts = append(parts, "now: "+strings.Join(now, ", "))
+	}
+	if len(occurred) > 0 {
+		parts = append(parts, "since boot: "+strings.Join(occurred, ", "))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+type Option func(c *Collector)
+
+// WithReadFile replaces os.ReadFile, e.g. to read fixtures in tests
+func WithReadFile(fn func(path string) ([]byte, error)) Option {
+	return func(c *Collector) {
+		c.readFile = fn
+	}
+}
+
+// WithCommand replaces running external commands, e.g. vcgencmd
+func WithCommand(fn func(ctx context.Context, name string, args ...string) ([]byte, error)) Option {
+	return func(c *Collector) {
+		c.command = fn
+	}
+}
+
+// WithDiskFree replaces the free disk space check, fn returns free bytes of the path filesystem
+func WithDiskFree(fn func(path string) (uint64, error)) Option {
+	return func(c *Collector) {
+		c.diskFree = fn
+	}
+}
+
+// WithDiskPath sets the directory to check free space of, e.g. where metrics and HAP db are
+func WithDiskPath(path string) Option {
+	return func(c *Collector) {
+		c.diskPath = path
+	}
+}
+
+// WithThermalZone sets the SoC temperature file, DefaultThermalZone if not set
+func WithThermalZone(path string) Option {
+	return func(c *Collector) {
+		c.thermalZone = path
+	}
+}
+
+// Collector reads host metrics from procfs, sysfs and vcgencmd
+type Collector struct {
+	readFile    func(path string) ([]byte, error)
+	command     func(ctx context.Context, name string, args ...string) ([]byte, error)
+	diskFree    func(path string) (uint64, error)
+	diskPath    string
+	thermalZone string
+	now         func() time.Time
+}
+
+func New(opts ...Option) *Collector {
+	c := &Collector{
+		readFile:    os.ReadFile,
+		command:     runCommand,
+		diskFree:    diskFree,
+		diskPath:    ".",
+		thermalZone: DefaultThermalZone,
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// Collect reads all metrics, the ones which can't be read are NaN and logged with debug level
+func (c *Collector) Collect(ctx context.Context) Stats {
+	nan := math.NaN()
+	s := Stats{
+		T:              c.now(),
+		SoCTemperature: nan,
+		Load1:          nan,
+		Load5:          nan,
+		Load15:         nan,
+		MemAvailable:   nan,
+		MemUsed:        nan,
+		DiskFree:       nan,
+		WiFiLink:       nan,
+		WiFiSignal:     nan,
+	}
+
+	var err error
+	debug := func(what string, err error) {
+		log.Debg.Printf("can't read host %s: %s", what, err.Error())
+	}
+
+	if s.SoCTemperature, err = c.socTemperature(); err != nil {
+		debug("SoC temperature", err)
+	}
+	if s.Load1, s.Load5, s.Load15, err = c.loadavg(); err != nil {
+		debug("load average", err)
+	}
+	if s.MemAvailable, s.MemUsed, err = c.memory(); err != nil {
+		debug("memory", err)
+	}
+	if free, err := c.diskFree(c.diskPath); err != nil {
+		debug("free disk", err)
+	} else {
+		s.DiskFree = float64(free) / 1024 / 1024
+	}
+	if s.WiFi, s.WiFiLink, s.WiFiSignal, err = c.wireless(); err != nil {
+		debug("Wi-Fi", err)
+	}
+	if s.Throttled, err = c.throttled(ctx); err != nil {
+		debug("throttling", err)
+	} else {
+		s.ThrottledKnown = true
+	}
+
+	return s
+}
+
+// socTemperature reads the thermal zone in millidegrees
+func (c *Collector) socTemperature() (float64, error) {
+	data, err := c.readFile(c.thermalZone)
+	if err != nil {
+		return math.NaN(), err
+	}
+
+	milli, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil {
+		return math.NaN(), fmt.Errorf("can't parse %s: %w", c.thermalZone, err)
+	}
+
+	return milli / 1000, nil
+}
+
+// loadavg reads "0.12 0.20 0.25 1/234 5678"
+func (c *Collector) loadavg() (l1, l5, l15 float64, err error) {
+	nan := math.NaN()
+	data, err := c.readFile(loadavgPath)
+	if err != nil {
+		return nan, nan, nan, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nan, nan, nan, fmt.Errorf("invalid %s: %q", loadavgPath, data)
+	}
+
+	loads := make([]float64, 3)
+	for i := range loads {
+		if loads[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return nan, nan, nan, fmt.Errorf("can't parse %s: %w", loadavgPath, err)
+		}
+	}
+
+	return loads[0], loads[1], loads[2], nil
+}
+
+// memory reads MemTotal and MemAvailable in kB, it returns available MB and used %
+func (c *Collector) memory() (available, used float64, err error) {
+	nan := math.NaN()
+	data, err := c.readFile(meminfoPath)
+	if err != nil {
+		return nan, nan, err
+	}
+
+	kb := make(map[string]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// MemAvailable:    3123456 kB
+		name, val, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(val)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			kb[name] = v
+		}
+	}
+
+	total, avail := kb["MemTotal"], kb["MemAvailable"]
+	if total <= 0 {
+		return nan, nan, fmt.Errorf("no MemTotal in %s", meminfoPath)
+	}
+
+	return avail / 1024, (total - avail) / total * 100, nil
+}
+
+// wireless reads the first interface of /proc/net/wireless:
+//
+//	Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
+//	 face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22
+//	 wlan0: 0000   70.  -40.  -256        0      0      0      0      0        0
+func (c *Collector) wireless() (iface string, link, signal float64, err error) {
+	nan := math.NaN()
+	data, err := c.readFile(wirelessPath)
+	if err != nil {
+		return "", nan, nan, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 3 {
+		return "", nan, nan, errors.New("no wireless interfaces")
+	}
+
+	name, rest, ok := strings.Cut(lines[2], ":")
+	fields := strings.Fields(rest)
+	if !ok || len(fields) < 3 {
+		return "", nan, nan, fmt.Errorf("invalid %s line: %q", wirelessPath, lines[2])
+	}
+
+	if link, err = strconv.ParseFloat(strings.TrimSuffix(fields[1], "."), 64); err != nil {
+		return "", nan, nan, fmt.Errorf("can't parse link quality: %w", err)
+	}
+	if signal, err = strconv.ParseFloat(strings.TrimSuffix(fields[2], "."), 64); err != nil {
+		return "", nan, nan, fmt.Errorf("can't parse signal level: %w", err)
+	}
+
+	return strings.TrimSpace(name), link, signal, nil
+}
+
+// throttled runs `vcgencmd get_throttled`, it prints "throttled=0x50000"
+func (c *Collector) throttled(ctx context.Context) (Throttled, error) {
+	out, err := c.command(ctx, "vcgencmd", "get_throttled")
+	if err != nil {
+		return 0, fmt.Errorf("can't run vcgencmd: %w", err)
+	}
+
+	_, val, ok := strings.Cut(strings.TrimSpace(string(out)), "=")
+	if !ok {
+		return 0, fmt.Errorf("invalid vcgencmd output: %q", out)
+	}
+
+	flags, err := strconv.ParseUint(val, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse vcgencmd output: %w", err)
+	}
+
+	return Throttled(flags), nil
+}
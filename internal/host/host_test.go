@@ -0,0 +1,98 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var piFiles = map[string]string{
+	DefaultThermalZone: "52612\n",
+	loadavgPath:        "0.12 0.20 0.25 1/234 5678\n",
+	meminfoPath:        "MemTotal:        4000000 kB\nMemFree:          500000 kB\nMemAvailable:    3000000 kB\n",
+	wirelessPath: "Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE\n" +
+		" face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22\n" +
+		" wlan0: 0000   56.  -54.  -256        0      0      0      0      0        0\n",
+}
+
+func readFiles(files map[string]string) func(string) ([]byte, error) {
+	return func(path string) ([]byte, error) {
+		data, ok := files[path]
+		if !ok {
+			return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+		}
+
+		return []byte(data), nil
+	}
+}
+
+func TestCollect(t *testing.T) {
+	c := New(
+		WithReadFile(readFiles(piFiles)),
+		WithCommand(func(_ context.Context, name string, args ...string) ([]byte, error) {
+			assert.Equal(t, "vcgencmd", name)
+			assert.Equal(t, []string{"get_throttled"}, args)
+			return []byte("throttled=0x50005\n"), nil
+		}),
+		WithDiskFree(func(path string) (uint64, error) {
+			assert.Equal(t, "/home/pi/hk", path)
+			return 2 * 1024 * 1024 * 1024, nil
+		}),
+		WithDiskPath("/home/pi/hk"),
+	)
+
+	s := c.Collect(context.Background())
+	assert.InDelta(t, 52.612, s.SoCTemperature, 1e-9)
+	assert.Equal(t, []float64{0.12, 0.20, 0.25}, []float64{s.Load1, s.Load5, s.Load15})
+	assert.InDelta(t, 2929.6875, s.MemAvailable, 1e-9)
+	assert.InDelta(t, 25, s.MemUsed, 1e-9)
+	assert.InDelta(t, 2048, s.DiskFree, 1e-9)
+	assert.Equal(t, "wlan0", s.WiFi)
+	assert.InDelta(t, 56, s.WiFiLink, 1e-9)
+	assert.InDelta(t, -54, s.WiFiSignal, 1e-9)
+	assert.True(t, s.ThrottledKnown)
+	assert.Equal(t, UnderVoltage|Throttling|UnderVoltageOccurred|ThrottlingOccurred, s.Throttled)
+	assert.Equal(t, "now: under-voltage, throttled; since boot: under-voltage, throttled", s.Throttled.String())
+
+	gauges := s.Gauges()
+	assert.Len(t, gauges, 10)
+	assert.InDelta(t, 0x50005, gauges[ThrottledKey], 1e-9)
+}
+
+func TestCollectWithoutSources(t *testing.T) {
+	c := New(
+		WithReadFile(readFiles(map[string]string{wirelessPath: "Inter-| sta-|\n face | tus |\n"})),
+		WithCommand(func(context.Context, string, ...string) ([]byte, error) {
+			return nil, errors.New(`exec: "vcgencmd": executable file not found in $PATH`)
+		}),
+		WithDiskFree(func(string) (uint64, error) { return 0, fs.ErrPermission }),
+	)
+
+	s := c.Collect(context.Background())
+	assert.True(t, math.IsNaN(s.SoCTemperature))
+	assert.True(t, math.IsNaN(s.MemUsed))
+	assert.True(t, math.IsNaN(s.DiskFree))
+	assert.True(t, math.IsNaN(s.WiFiLink))
+	assert.Empty(t, s.WiFi)
+	assert.False(t, s.ThrottledKnown)
+	assert.Empty(t, s.Gauges())
+	assert.Equal(t, "ok", Throttled(0).String())
+}
+
+func TestSoCSensor(t *testing.T) {
+	soc := New(WithReadFile(readFiles(piFiles))).SoCSensor()
+
+	rd, err := soc.Read(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, 52.612, rd.Temperature, 1e-9)
+	assert.True(t, math.IsNaN(rd.Humidity))
+	assert.Equal(t, DefaultThermalZone, soc.Info().Addr)
+
+	_, err = New(WithReadFile(readFiles(nil))).SoCSensor().Read(context.Background())
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,38 @@
+package host
+
+import (
+	"context"
+
+	"github.com/egregors/hk/internal/sensors"
+)
+
+// SoCSensor is the SoC temperature as a temperature-only climate sensor, e.g. to show it in HomeKit
+type SoCSensor struct {
+	c *Collector
+}
+
+// SoCSensor reads the same thermal zone as the collector
+func (c *Collector) SoCSensor() *SoCSensor {
+	return &SoCSensor{c: c}
+}
+
+func (s *SoCSensor) Read(ctx context.Context) (sensors.Reading, error) {
+	if err := ctx.Err(); err != nil {
+		return sensors.Reading{}, err
+	}
+
+	t, err := s.c.socTemperature()
+	if err != nil {
+		return sensors.Reading{}, err
+	}
+
+	rd := sensors.NewReading(s.c.now())
+	rd.Temperature = t
+
+	return rd, nil
+}
+
+// Info describes the sensor for diagnostics
+func (s *SoCSensor) Info() sensors.Info {
+	return sensors.Info{Chip: "SoC thermal zone", Addr: s.c.thermalZone}
+}
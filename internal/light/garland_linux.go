@@ -1,4 +1,4 @@
-//go:build linux && arm64
+//go:build linux
 
 package light
 
@@ -13,6 +13,12 @@ import (
 	"github.com/egregors/hk/utils/cli"
 )
 
+func init() {
+	Register("uhubctl", func() (PowerCtrl, error) {
+		return NewUsbGarland()
+	})
+}
+
 type UsbGarland struct {
 	hubLocation string
 }
@@ -0,0 +1,35 @@
+package light
+
+import "github.com/egregors/hk/internal/registry"
+
+// PowerCtrl turns power of an external device on and off
+type PowerCtrl interface {
+	On() error
+	Off() error
+}
+
+// Driver makes a power control
+type Driver func() (PowerCtrl, error)
+
+var drivers = registry.New[Driver]("power")
+
+// Register makes a driver available by name. Hardware drivers register
+// themselves only on platforms where they can work.
+func Register(name string, d Driver) {
+	drivers.Register(name, d)
+}
+
+// Drivers returns names of registered drivers
+func Drivers() []string {
+	return drivers.Names()
+}
+
+// Open makes a power control with the driver
+func Open(name string) (PowerCtrl, error) {
+	d, err := drivers.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return d()
+}
@@ -0,0 +1,24 @@
+package light
+
+import "github.com/egregors/hk/log"
+
+func init() {
+	Register("noop", func() (PowerCtrl, error) {
+		return &NoopPower{}, nil
+	})
+}
+
+// NoopPower only logs calls, for platforms without USB power control
+type NoopPower struct{}
+
+func (n *NoopPower) On() error {
+	log.Info.Printf("call power ON (noop)")
+
+	return nil
+}
+
+func (n *NoopPower) Off() error {
+	log.Info.Printf("call power OFF (noop)")
+
+	return nil
+}
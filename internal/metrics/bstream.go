@@ -0,0 +1,73 @@
+package metrics
+
+import "errors"
+
+var errEndOfStream = errors.New("end of bit stream")
+
+// bstream is a stream of bits, a chunk writes and reads values bit by bit
+type bstream struct {
+	b []byte
+	// free is how many bits of the last byte aren't written yet
+	free uint8
+}
+
+func (s *bstream) writeBit(bit bool) {
+	if s.free == 0 {
+		s.b = append(s.b, 0)
+		s.free = 8
+	}
+	if bit {
+		s.b[len(s.b)-1] |= 1 << (s.free - 1)
+	}
+	s.free--
+}
+
+// writeBits writes the lowest nbits of u, the highest bit first
+func (s *bstream) writeBits(u uint64, nbits int) {
+	for nbits > 0 {
+		if s.free == 0 {
+			s.b = append(s.b, 0)
+			s.free = 8
+		}
+
+		n := min(nbits, int(s.free))
+		bits := byte(u>>uint(nbits-n)) & (1<<n - 1)
+		s.b[len(s.b)-1] |= bits << (int(s.free) - n)
+		s.free -= uint8(n)
+		nbits -= n
+	}
+}
+
+// bstreamReader reads bits written by bstream
+type bstreamReader struct {
+	b   []byte
+	pos int // in bits
+}
+
+func (r *bstreamReader) readBit() (bool, error) {
+	if r.pos >= len(r.b)*8 {
+		return false, errEndOfStream
+	}
+	bit := r.b[r.pos/8]>>(7-uint(r.pos%8))&1 == 1
+	r.pos++
+
+	return bit, nil
+}
+
+func (r *bstreamReader) readBits(nbits int) (uint64, error) {
+	if r.pos+nbits > len(r.b)*8 {
+		return 0, errEndOfStream
+	}
+
+	var u uint64
+	for nbits > 0 {
+		used := r.pos % 8
+		n := min(nbits, 8-used)
+		bits := r.b[r.pos/8] >> (8 - used - n) & (1<<n - 1)
+		u = u<<uint(n) | uint64(bits)
+		r.pos += n
+		nbits -= n
+	}
+
+	return u, nil
+}
@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// chunkSize is how many values a chunk takes before the next one is started, an hour of 30s samples
+const chunkSize = 120
+
+// chunk keeps values compressed like Facebook Gorilla does: timestamps as delta-of-delta
+// and floats as XOR with the previous one. Timestamps are kept in milliseconds.
+//
+// A regular 30s series takes a bit per timestamp and a value which doesn't change takes a bit too,
+// against 32 bytes of a Value.
+type chunk struct {
+	b bstream
+	n int
+	// last is the last timestamp, min and max are the time range with values appended out of order
+	last     int64
+	min, max int64
+	delta    int64
+	v        uint64
+	// leading and trailing zeros of the last meaningful XOR
+	leading, trailing uint8
+}
+
+// append encodes a value, values before the last one are fine, but they take more bits
+func (c *chunk) append(v Value) {
+	t, vb := v.T.UnixMilli(), math.Float64bits(v.V)
+
+	if c.n == 0 {
+		c.b.writeBits(uint64(t), 64)
+		c.b.writeBits(vb, 64)
+		c.last, c.min, c.max, c.v = t, t, t, vb
+		// no XOR window yet, leading zeros are never more than 31
+		c.leading = math.MaxUint8
+		c.n++
+
+		return
+	}
+
+	delta := t - c.last
+	c.writeDoD(delta - c.delta)
+	c.writeXOR(vb)
+	c.last, c.delta, c.v = t, delta, vb
+	c.min, c.max = min(c.min, t), max(c.max, t)
+	c.n++
+}
+
+// writeDoD encodes delta-of-delta in the smallest of buckets, 0 takes a single bit
+func (c *chunk) writeDoD(dod int64) {
+	switch {
+	case dod == 0:
+		c.b.writeBit(false)
+	case -63 <= dod && dod <= 64:
+		c.b.writeBits(0b10, 2)
+		c.b.writeBits(uint64(dod), 7)
+	case -255 <= dod && dod <= 256:
+		c.b.writeBits(0b110, 3)
+		c.b.writeBits(uint64(dod), 9)
+	case -2047 <= dod && dod <= 2048:
+		c.b.writeBits(0b1110, 4)
+		c.b.writeBits(uint64(dod), 12)
+	default:
+		c.b.writeBits(0b1111, 4)
+		c.b.writeBits(uint64(dod), 64)
+	}
+}
+
+// writeXOR encodes a float as XOR with the previous one, the same value takes a single bit.
+// Meaningful bits of XOR are written within the previous leading and trailing zeros if they fit.
+func (c *chunk) writeXOR(v uint64) {
+	xor := v ^ c.v
+	if xor == 0 {
+		c.b.writeBit(false)
+		return
+	}
+	c.b.writeBit(true)
+
+	leading, trailing := uint8(min(bits.LeadingZeros64(xor), 31)), uint8(bits.TrailingZeros64(xor))
+	if leading >= c.leading && trailing >= c.trailing {
+		c.b.writeBit(false)
+		c.b.writeBits(xor>>c.trailing, 64-int(c.leading)-int(c.trailing))
+		return
+	}
+
+	c.leading, c.trailing = leading, trailing
+	meaningful := 64 - int(leading) - int(trailing)
+	c.b.writeBit(true)
+	c.b.writeBits(uint64(leading), 5)
+	// 64 meaningful bits don't fit 6 bits, they are written as 0
+	c.b.writeBits(uint64(meaningful), 6)
+	c.b.writeBits(xor>>trailing, meaningful)
+}
+
+// values decodes all values of the chunk in the order they were appended
+func (c *chunk) values() ([]Value, error) {
+	return decodeChunk(c.b.b, c.n)
+}
+
+// size is how many bytes the chunk takes
+func (c *chunk) size() int {
+	return cap(c.b.b)
+}
+
+func decodeChunk(b []byte, n int) ([]Value, error) {
+	r := bstreamReader{b: b}
+	vals := make([]Value, 0, n)
+
+	var (
+		t, delta          int64
+		v                 uint64
+		leading, trailing uint8
+	)
+	for i := range n {
+		if i == 0 {
+			ut, err := r.readBits(64)
+			if err != nil {
+				return nil, err
+			}
+			if v, err = r.readBits(64); err != nil {
+				return nil, err
+			}
+			t = int64(ut)
+			vals = append(vals, Value{T: time.UnixMilli(t), V: math.Float64frombits(v)})
+			continue
+		}
+
+		dod, err := readDoD(&r)
+		if err != nil {
+			return nil, fmt.Errorf("can't decode timestamp %d: %w", i, err)
+		}
+		delta += dod
+		t += delta
+
+		if v, leading, trailing, err = readXOR(&r, v, leading, trailing); err != nil {
+			return nil, fmt.Errorf("can't decode value %d: %w", i, err)
+		}
+		vals = append(vals, Value{T: time.UnixMilli(t), V: math.Float64frombits(v)})
+	}
+
+	return vals, nil
+}
+
+func readDoD(r *bstreamReader) (int64, error) {
+	// the number of 1s before 0 is the bucket
+	var bucket int
+	for bucket < 4 {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		bucket++
+	}
+
+	nbits := [...]int{0, 7, 9, 12, 64}[bucket]
+	if nbits == 0 {
+		return 0, nil
+	}
+	u, err := r.readBits(nbits)
+	if err != nil {
+		return 0, err
+	}
+	// the bucket is signed, its values above the middle are negative
+	if nbits < 64 && u > 1<<(nbits-1) {
+		return int64(u) - 1<<nbits, nil
+	}
+
+	return int64(u), nil
+}
+
+func readXOR(r *bstreamReader, prev uint64, leading, trailing uint8) (uint64, uint8, uint8, error) {
+	changed, err := r.readBit()
+	if err != nil || !changed {
+		return prev, leading, trailing, err
+	}
+
+	newWindow, err := r.readBit()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if newWindow {
+		l, err := r.readBits(5)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		meaningful, err := r.readBits(6)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if meaningful == 0 {
+			meaningful = 64
+		}
+		leading, trailing = uint8(l), uint8(64-l-meaningful)
+	}
+
+	xor, err := r.readBits(64 - int(leading) - int(trailing))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return prev ^ xor<<trailing, leading, trailing, nil
+}
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// samples returns n 30s samples with jitter, values are a random walk rounded like sensors do
+func samples(n int) []Value {
+	r := rand.New(rand.NewSource(1))
+	vals := make([]Value, 0, n)
+	v := 21.0
+	for i := range n {
+		if r.Intn(4) == 0 {
+			v += float64(r.Intn(3)-1) / 10
+		}
+		jitter := time.Duration(r.Intn(200)) * time.Millisecond
+		vals = append(vals, Value{T: start.Add(time.Duration(i)*30*time.Second + jitter), V: math.Round(v*10) / 10})
+	}
+
+	return vals
+}
+
+func TestChunk(t *testing.T) {
+	tbl := []struct {
+		name string
+		vals []Value
+	}{
+		{"single", []Value{{T: at(0), V: 20}}},
+		{"regular", []Value{{T: at(0), V: 20}, {T: at(1), V: 20}, {T: at(2), V: 20}, {T: at(3), V: 20.5}}},
+		{"special floats", []Value{
+			{T: at(0), V: math.Inf(1)}, {T: at(1), V: math.Inf(-1)}, {T: at(2), V: 0},
+			{T: at(3), V: math.Copysign(0, -1)}, {T: at(4), V: math.MaxFloat64}, {T: at(5), V: math.SmallestNonzeroFloat64},
+		}},
+		{"gaps", []Value{
+			{T: at(0), V: 1}, {T: at(0).Add(time.Millisecond), V: 2}, {T: at(60 * 24 * 30), V: 3},
+			{T: at(60*24*30 + 1), V: 4}, {T: at(60*24*30 + 3), V: 5},
+		}},
+		{"out of order", []Value{{T: at(5), V: 5}, {T: at(1), V: 1}, {T: at(3), V: 3}, {T: at(2), V: 2}}},
+		{"before epoch", []Value{{T: time.UnixMilli(-5000), V: 1}, {T: time.UnixMilli(-1000), V: 2}}},
+		{"jitter", samples(chunkSize)},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &chunk{}
+			for _, v := range tt.vals {
+				c.append(v)
+			}
+
+			decoded, err := c.values()
+			require.NoError(t, err)
+			require.Len(t, decoded, len(tt.vals))
+			for i, v := range tt.vals {
+				assert.Equal(t, v.T.UnixMilli(), decoded[i].T.UnixMilli(), "timestamp %d", i)
+				assert.Equal(t, math.Float64bits(v.V), math.Float64bits(decoded[i].V), "value %d", i)
+			}
+		})
+	}
+
+	t.Run("NaN", func(t *testing.T) {
+		c := &chunk{}
+		c.append(Value{T: at(0), V: math.NaN()})
+		c.append(Value{T: at(1), V: 20})
+		decoded, err := c.values()
+		require.NoError(t, err)
+		assert.True(t, math.IsNaN(decoded[0].V))
+		assert.Equal(t, 20.0, decoded[1].V)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		c := &chunk{}
+		for _, v := range samples(10) {
+			c.append(v)
+		}
+		_, err := decodeChunk(c.b.b[:len(c.b.b)/2], c.n)
+		assert.ErrorIs(t, err, errEndOfStream)
+	})
+}
+
+func TestSeries(t *testing.T) {
+	vals := samples(3*chunkSize + 10)
+	s := newSeries(vals)
+	assert.Len(t, s.chunks, 4)
+	assert.Equal(t, len(vals), s.len())
+
+	assert.Len(t, s.query(vals[0].T, vals[len(vals)-1].T.Add(time.Second)), len(vals))
+	got := s.query(vals[100].T, vals[200].T)
+	require.Len(t, got, 100)
+	assert.Equal(t, vals[100].V, got[0].V)
+	assert.Equal(t, vals[199].V, got[99].V)
+	assert.Empty(t, s.query(at(-10), at(-1)))
+
+	// the first chunk is dropped, the second one is cut
+	assert.Equal(t, chunkSize+20, s.retain(vals[chunkSize+20].T))
+	assert.Equal(t, len(vals)-chunkSize-20, s.len())
+	assert.Equal(t, vals[chunkSize+20].V, s.query(at(0), vals[len(vals)-1].T)[0].V)
+	assert.Zero(t, s.retain(at(0)))
+
+	// the head chunk keeps taking appends, a backfilled value is sorted in by the query
+	n := len(s.chunks)
+	last := vals[len(vals)-1]
+	s.append(Value{T: last.T.Add(time.Minute), V: 1})
+	s.append(Value{T: vals[len(vals)-3].T.Add(time.Millisecond), V: 100})
+	assert.Len(t, s.chunks, n)
+	assert.Equal(t, []float64{vals[len(vals)-3].V, 100, vals[len(vals)-2].V, last.V, 1},
+		values(s.query(vals[len(vals)-3].T, at(60*24))))
+
+	// adopted chunks are sealed
+	adopted := &series{}
+	adopted.adopt(s.encode())
+	adopted.append(Value{T: vals[len(vals)-1].T.Add(2 * time.Minute), V: 2})
+	assert.Len(t, adopted.chunks, n+1)
+	assert.Equal(t, s.len()+1, adopted.len())
+}
+
+// BenchmarkMemory compares bytes per value of a day of 30s samples kept in []Value and in chunks
+func BenchmarkMemory(b *testing.B) {
+	vals := samples(24 * 60 * 2)
+
+	b.Run("values", func(b *testing.B) {
+		var kept []Value
+		for range b.N {
+			kept = nil
+			for _, v := range vals {
+				kept = append(kept, v)
+			}
+		}
+		b.ReportMetric(float64(cap(kept)*int(unsafe.Sizeof(Value{})))/float64(len(kept)), "bytes/value")
+	})
+
+	b.Run("chunks", func(b *testing.B) {
+		var s *series
+		for range b.N {
+			s = newSeries(vals)
+		}
+		s.seal()
+		b.ReportMetric(float64(s.size())/float64(s.len()), "bytes/value")
+	})
+}
+
+// BenchmarkDump compares the gob size of a day of 30s samples dumped as []Value and as chunks
+func BenchmarkDump(b *testing.B) {
+	vals := samples(24 * 60 * 2)
+
+	b.Run("values", func(b *testing.B) {
+		var buf bytes.Buffer
+		for range b.N {
+			buf.Reset()
+			require.NoError(b, gob.NewEncoder(&buf).Encode(map[string][]Value{"t": vals}))
+		}
+		b.ReportMetric(float64(buf.Len())/float64(len(vals)), "bytes/value")
+	})
+
+	b.Run("chunks", func(b *testing.B) {
+		var buf bytes.Buffer
+		for range b.N {
+			buf.Reset()
+			require.NoError(b, gob.NewEncoder(&buf).Encode(encodeValues(map[string][]Value{"t": vals})))
+		}
+		b.ReportMetric(float64(buf.Len())/float64(len(vals)), "bytes/value")
+	})
+}
+
+// BenchmarkQuery decodes an hour out of a day of samples
+func BenchmarkQuery(b *testing.B) {
+	vals := samples(24 * 60 * 2)
+	s := newSeries(vals)
+	from, to := vals[1000].T, vals[1120].T
+
+	b.ResetTimer()
+	for range b.N {
+		s.query(from, to)
+	}
+}
@@ -0,0 +1,622 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+const (
+	// DefaultSyncInterval is how often appended values are fsynced, it's what a power cut can lose at most
+	DefaultSyncInterval = time.Second
+	// DefaultSyncBatch is how many appended values are fsynced at once without waiting for the interval
+	DefaultSyncBatch = 512
+	// DefaultRollSize is the WAL size it's rolled into a segment at
+	DefaultRollSize = 4 << 20
+	// DefaultMaxSegments is how many segments there can be before they are compacted into one
+	DefaultMaxSegments = 8
+)
+
+type DiskOption func(d *Disk)
+
+func WithSyncInterval(dur time.Duration) DiskOption {
+	return func(d *Disk) {
+		d.syncInterval = dur
+	}
+}
+
+func WithSyncBatch(n int) DiskOption {
+	return func(d *Disk) {
+		d.syncBatch = n
+	}
+}
+
+func WithRollSize(size int64) DiskOption {
+	return func(d *Disk) {
+		d.rollSize = size
+	}
+}
+
+func WithMaxSegments(n int) DiskOption {
+	return func(d *Disk) {
+		d.maxSegments = n
+	}
+}
+
+// segmentInfo is a segment file made of WALs from..to, min and max are its value times
+type segmentInfo struct {
+	from, to uint64
+	min, max time.Time
+}
+
+func (s segmentInfo) name() string {
+	return fmt.Sprintf("segment-%08d-%08d.gob", s.from, s.to)
+}
+
+func walName(seq uint64) string {
+	return fmt.Sprintf("wal-%08d.log", seq)
+}
+
+// Disk is a crash-safe Storage. Values are appended to a write-ahead log, fsynced in batches
+// and rolled into segment files, which are compacted into one when there are too many.
+// On open, segments are loaded and logs which aren't rolled yet are replayed.
+// All values are kept in memory as well, so queries don't touch the disk. Both in memory and in segments
+// values are compressed into chunks, see chunk.
+type Disk struct {
+	dir          string
+	syncInterval time.Duration
+	syncBatch    int
+	rollSize     int64
+	maxSegments  int
+
+	mu   sync.RWMutex
+	data map[string]*series
+	// pending are values in WALs walFrom..walSeq, they aren't in segments yet
+	pending  map[string][]Value
+	segments []segmentInfo
+	// cutoff is the last retention, compaction drops values before it
+	cutoff   time.Time
+	walFrom  uint64
+	walSeq   uint64
+	wal      *os.File
+	buf      *bufio.Writer
+	walSize  int64
+	unsynced int
+	closed   bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// OpenDisk opens the storage in dir, it's created if it doesn't exist
+func OpenDisk(dir string, opts ...DiskOption) (*Disk, error) {
+	d := &Disk{
+		dir:          dir,
+		syncInterval: DefaultSyncInterval,
+		syncBatch:    DefaultSyncBatch,
+		rollSize:     DefaultRollSize,
+		maxSegments:  DefaultMaxSegments,
+		data:         make(map[string]*series),
+		pending:      make(map[string][]Value),
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("can't create metrics dir: %w", err)
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	d.wg.Add(1)
+	go d.flusher()
+
+	return d, nil
+}
+
+// load reads segments and replays logs which aren't rolled into them
+func (d *Disk) load() error {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return fmt.Errorf("can't read metrics dir: %w", err)
+	}
+
+	var wals []uint64
+	for _, e := range entries {
+		name := e.Name()
+		var seg segmentInfo
+		var seq uint64
+		switch {
+		case strings.HasSuffix(name, ".tmp"):
+			// a segment which wasn't finished
+			_ = os.Remove(filepath.Join(d.dir, name))
+		case scan(name, "segment-%08d-%08d.gob", &seg.from, &seg.to):
+			d.segments = append(d.segments, seg)
+		case scan(name, "wal-%08d.log", &seq):
+			wals = append(wals, seq)
+		}
+	}
+
+	d.dropCoveredSegments()
+	for i := range d.segments {
+		if err := d.loadSegment(&d.segments[i]); err != nil {
+			return err
+		}
+	}
+
+	var covered uint64
+	for _, seg := range d.segments {
+		covered = max(covered, seg.to)
+	}
+	d.walFrom, d.walSeq = covered+1, covered+1
+	slices.Sort(wals)
+	replayed := 0
+	for _, seq := range wals {
+		if seq <= covered {
+			// it was rolled, but not removed before a crash
+			_ = os.Remove(filepath.Join(d.dir, walName(seq)))
+			continue
+		}
+		if err := d.replay(seq); err != nil {
+			return err
+		}
+		if replayed == 0 {
+			d.walFrom = seq
+		}
+		d.walSeq = seq
+		replayed++
+	}
+
+	d.wal, err = os.OpenFile(filepath.Join(d.dir, walName(d.walSeq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("can't open WAL: %w", err)
+	}
+	info, err := d.wal.Stat()
+	if err != nil {
+		return fmt.Errorf("can't stat WAL: %w", err)
+	}
+	d.walSize = info.Size()
+	d.buf = bufio.NewWriter(d.wal)
+
+	log.Info.Printf("loaded %d values of %d keys from %d segments and %d logs", d.lenLocked(), len(d.data), len(d.segments), replayed)
+
+	return nil
+}
+
+func scan(name, format string, args ...any) bool {
+	n, err := fmt.Sscanf(name, format, args...)
+	return err == nil && n == len(args)
+}
+
+// dropCoveredSegments removes segments merged into a bigger one, if compaction didn't finish before a crash
+func (d *Disk) dropCoveredSegments() {
+	kept := d.segments[:0]
+	for i, seg := range d.segments {
+		covered := false
+		for j, other := range d.segments {
+			if i != j && other.from <= seg.from && seg.to <= other.to && (other.from != seg.from || other.to != seg.to) {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			_ = os.Remove(filepath.Join(d.dir, seg.name()))
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	d.segments = kept
+	sort.Slice(d.segments, func(i, j int) bool { return d.segments[i].from < d.segments[j].from })
+}
+
+func (d *Disk) loadSegment(seg *segmentInfo) error {
+	data, err := readSegment(filepath.Join(d.dir, seg.name()))
+	if err != nil {
+		return err
+	}
+
+	for key, chunks := range data {
+		d.seriesOf(key).adopt(chunks)
+	}
+	seg.min, seg.max = timeRange(data)
+
+	return nil
+}
+
+// replay reads a WAL, a torn record at the end is cut off
+func (d *Disk) replay(seq uint64) error {
+	path := filepath.Join(d.dir, walName(seq))
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can't open WAL: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	valid, err := replayRecords(f, func(key string, v Value) {
+		d.seriesOf(key).append(v)
+		d.pending[key] = append(d.pending[key], v)
+	})
+	if errors.Is(err, errTornRecord) {
+		log.Erro.Printf("WAL %s has a torn record at %d, it's cut off", walName(seq), valid)
+		if err := os.Truncate(path, valid); err != nil {
+			return fmt.Errorf("can't cut off torn record: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't replay %s: %w", walName(seq), err)
+	}
+
+	return nil
+}
+
+// Append writes the value to the WAL, it's fsynced with a batch
+func (d *Disk) Append(key string, v Value) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return errors.New("storage is closed")
+	}
+
+	rec := appendRecord(nil, key, v)
+	if _, err := d.buf.Write(rec); err != nil {
+		return fmt.Errorf("can't write WAL: %w", err)
+	}
+	d.walSize += int64(len(rec))
+	d.unsynced++
+	d.seriesOf(key).append(v)
+	d.pending[key] = append(d.pending[key], v)
+
+	if d.unsynced >= d.syncBatch {
+		if err := d.syncLocked(); err != nil {
+			return err
+		}
+	}
+	if d.walSize >= d.rollSize {
+		if err := d.rollLocked(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of values
+func (d *Disk) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.lenLocked()
+}
+
+func (d *Disk) lenLocked() int {
+	var n int
+	for _, s := range d.data {
+		n += s.len()
+	}
+
+	return n
+}
+
+// seriesOf returns the series of the key, a new one if there is none
+func (d *Disk) seriesOf(key string) *series {
+	s, ok := d.data[key]
+	if !ok {
+		s = &series{}
+		d.data[key] = s
+	}
+
+	return s
+}
+
+// Query returns values of the key measured in [from, to) ordered by time, they are decoded from chunks
+func (d *Disk) Query(key string, from, to time.Time) []Value {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	s, ok := d.data[key]
+	if !ok {
+		return nil
+	}
+
+	return s.query(from, to)
+}
+
+// Keys returns keys which have values
+func (d *Disk) Keys() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	keys := make([]string, 0, len(d.data))
+	for key := range d.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Retain drops values measured before cutoff from memory and segments entirely before it,
+// the rest of old values are dropped from segments by compaction
+func (d *Disk) Retain(cutoff time.Time) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if cutoff.After(d.cutoff) {
+		d.cutoff = cutoff
+	}
+
+	var removed int
+	for key, s := range d.data {
+		removed += s.retain(cutoff)
+		if s.len() == 0 {
+			delete(d.data, key)
+		}
+	}
+
+	kept := d.segments[:0]
+	for _, seg := range d.segments {
+		if seg.max.Before(cutoff) {
+			if err := os.Remove(filepath.Join(d.dir, seg.name())); err != nil {
+				log.Erro.Printf("can't remove expired segment: %s", err.Error())
+				kept = append(kept, seg)
+			}
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	d.segments = kept
+
+	return removed
+}
+
+// Close fsyncs the WAL and rolls it into a segment
+func (d *Disk) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	close(d.done)
+	d.wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.syncLocked(); err != nil {
+		return err
+	}
+	if err := d.rollLocked(); err != nil {
+		return err
+	}
+
+	return d.wal.Close()
+}
+
+func (d *Disk) flusher() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		if d.unsynced > 0 {
+			if err := d.syncLocked(); err != nil {
+				log.Erro.Printf("can't sync metrics: %s", err.Error())
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *Disk) syncLocked() error {
+	if err := d.buf.Flush(); err != nil {
+		return fmt.Errorf("can't flush WAL: %w", err)
+	}
+	if err := d.wal.Sync(); err != nil {
+		return fmt.Errorf("can't sync WAL: %w", err)
+	}
+	d.unsynced = 0
+
+	return nil
+}
+
+// rollLocked writes pending values into a segment and starts the next WAL.
+// WALs are removed only after the segment is on disk, a crash in between leaves them to be skipped on open.
+func (d *Disk) rollLocked() error {
+	if err := d.syncLocked(); err != nil {
+		return err
+	}
+	if len(d.pending) == 0 {
+		return nil
+	}
+
+	seg := segmentInfo{from: d.walFrom, to: d.walSeq}
+	data := encodeValues(d.pending)
+	if err := writeSegment(d.dir, seg.name(), data); err != nil {
+		return err
+	}
+	seg.min, seg.max = timeRange(data)
+	d.segments = append(d.segments, seg)
+
+	next, err := os.OpenFile(filepath.Join(d.dir, walName(d.walSeq+1)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("can't open WAL: %w", err)
+	}
+	_ = d.wal.Close()
+	for seq := d.walFrom; seq <= d.walSeq; seq++ {
+		if err := os.Remove(filepath.Join(d.dir, walName(seq))); err != nil && !os.IsNotExist(err) {
+			log.Erro.Printf("can't remove rolled WAL: %s", err.Error())
+		}
+	}
+
+	d.walSeq++
+	d.walFrom = d.walSeq
+	d.wal, d.buf, d.walSize = next, bufio.NewWriter(next), 0
+	d.pending = make(map[string][]Value)
+	log.Debg.Printf("WAL is rolled into %s", seg.name())
+
+	if len(d.segments) > d.maxSegments {
+		return d.compactLocked()
+	}
+
+	return nil
+}
+
+// compactLocked merges all segments into one without values dropped by retention
+func (d *Disk) compactLocked() error {
+	merged := make(map[string][]Value)
+	for _, seg := range d.segments {
+		data, err := readSegment(filepath.Join(d.dir, seg.name()))
+		if err != nil {
+			return err
+		}
+		for key, chunks := range data {
+			for _, ec := range chunks {
+				vals, err := decodeChunk(ec.B, ec.N)
+				if err != nil {
+					return fmt.Errorf("can't decode chunk of %s in %s: %w", key, seg.name(), err)
+				}
+				merged[key] = append(merged[key], vals...)
+			}
+		}
+	}
+
+	for key, vals := range merged {
+		merged[key] = slices.DeleteFunc(vals, func(v Value) bool { return v.T.Before(d.cutoff) })
+	}
+
+	seg := segmentInfo{from: d.segments[0].from, to: d.segments[len(d.segments)-1].to}
+	data := encodeValues(merged)
+	if err := writeSegment(d.dir, seg.name(), data); err != nil {
+		return err
+	}
+	seg.min, seg.max = timeRange(data)
+
+	for _, old := range d.segments {
+		if err := os.Remove(filepath.Join(d.dir, old.name())); err != nil {
+			log.Erro.Printf("can't remove compacted segment: %s", err.Error())
+		}
+	}
+	d.segments = []segmentInfo{seg}
+	log.Debg.Printf("segments are compacted into %s", seg.name())
+
+	return nil
+}
+
+// encodeValues encodes values into chunks ordered by time, keys without values are skipped
+func encodeValues(vals map[string][]Value) map[string][]encodedChunk {
+	data := make(map[string][]encodedChunk, len(vals))
+	for key, vs := range vals {
+		if len(vs) == 0 {
+			continue
+		}
+		sorted := slices.Clone(vs)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].T.Before(sorted[j].T) })
+		s := newSeries(sorted)
+		s.seal()
+		data[key] = s.encode()
+	}
+
+	return data
+}
+
+// writeSegment writes chunks to a temporary file and renames it, so a segment is either complete or missing
+func writeSegment(dir, name string, data map[string][]encodedChunk) error {
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("can't create segment: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	w := bufio.NewWriter(tmp)
+	if err := gob.NewEncoder(w).Encode(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("can't encode segment: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("can't write segment: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("can't sync segment: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("can't close segment: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("can't rename segment: %w", err)
+	}
+
+	return syncDir(dir)
+}
+
+func readSegment(path string) (map[string][]encodedChunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open segment: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var data map[string][]encodedChunk
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&data); err != nil {
+		return nil, fmt.Errorf("can't decode segment %s: %w", filepath.Base(path), err)
+	}
+
+	return data, nil
+}
+
+// syncDir makes a rename durable
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("can't open metrics dir: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("can't sync metrics dir: %w", err)
+	}
+
+	return nil
+}
+
+// timeRange returns the time range of chunks
+func timeRange(data map[string][]encodedChunk) (lo, hi time.Time) {
+	var loMs, hiMs int64 = math.MaxInt64, math.MinInt64
+	for _, chunks := range data {
+		for _, c := range chunks {
+			loMs, hiMs = min(loMs, c.Min), max(hiMs, c.Max)
+		}
+	}
+	if loMs > hiMs {
+		return time.Time{}, time.Time{}
+	}
+
+	return time.UnixMilli(loMs), time.UnixMilli(hiMs)
+}
@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func at(minutes int) time.Time {
+	return start.Add(time.Duration(minutes) * time.Minute)
+}
+
+func files(t *testing.T, dir, pattern string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, pattern))
+	require.NoError(t, err)
+
+	return names
+}
+
+func TestDiskReplay(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDisk(dir, WithSyncBatch(2), WithSyncInterval(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, d.Append("t", Value{T: at(0), V: 20}))
+	require.NoError(t, d.Append("t", Value{T: at(2), V: 22}))
+	// a backfilled value goes in the middle
+	require.NoError(t, d.Append("t", Value{T: at(1), V: 21}))
+	require.NoError(t, d.Append("h", Value{T: at(0), V: 40}))
+
+	assert.Equal(t, []float64{20, 21, 22}, values(d.Query("t", at(0), at(3))))
+	assert.Equal(t, []float64{21}, values(d.Query("t", at(1), at(2))), "to isn't included")
+	assert.Empty(t, d.Query("p", at(0), at(3)))
+
+	// a power cut: nothing is closed, the last record is torn
+	wal := files(t, dir, "wal-*.log")
+	require.Len(t, wal, 1)
+	f, err := os.OpenFile(wal[0], os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.Write(appendRecord(nil, "t", Value{T: at(3), V: 23})[:10])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	replayed, err := OpenDisk(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{20, 21, 22}, values(replayed.Query("t", at(0), at(10))))
+	assert.Equal(t, []float64{40}, values(replayed.Query("h", at(0), at(10))))
+
+	// the torn record is cut off, appends go after the valid ones
+	require.NoError(t, replayed.Append("t", Value{T: at(4), V: 24}))
+	require.NoError(t, replayed.Close())
+	assert.Equal(t, []string{filepath.Join(dir, "segment-00000001-00000001.gob")}, files(t, dir, "segment-*"),
+		"the WAL is rolled on close")
+
+	reopened, err := OpenDisk(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{20, 21, 22, 24}, values(reopened.Query("t", at(0), at(10))))
+	require.NoError(t, reopened.Close())
+	require.Error(t, reopened.Append("t", Value{T: at(5), V: 25}), "closed")
+}
+
+func TestDiskRollAndCompact(t *testing.T) {
+	dir := t.TempDir()
+	// every 3 values roll into a segment, a second segment is compacted with the first one
+	d, err := OpenDisk(dir, WithSyncBatch(1), WithRollSize(3*int64(len(appendRecord(nil, "t", Value{T: at(0)})))), WithMaxSegments(1))
+	require.NoError(t, err)
+
+	for i := range 8 {
+		require.NoError(t, d.Append("t", Value{T: at(i), V: float64(i)}))
+	}
+	assert.Equal(t, []string{filepath.Join(dir, "segment-00000001-00000002.gob")}, files(t, dir, "segment-*"))
+	assert.Len(t, d.Query("t", at(0), at(10)), 8)
+
+	// retention drops old values from memory and expired segments, compaction drops the rest of old values
+	assert.Equal(t, 7, d.Retain(at(7)))
+	for i := 8; i < 14; i++ {
+		require.NoError(t, d.Append("t", Value{T: at(i), V: float64(i)}))
+	}
+	require.NoError(t, d.Close())
+	assert.Equal(t, []string{filepath.Join(dir, "segment-00000003-00000005.gob")}, files(t, dir, "segment-*"))
+
+	data, err := readSegment(filepath.Join(dir, "segment-00000003-00000005.gob"))
+	require.NoError(t, err)
+	require.Len(t, data["t"], 1)
+	assert.Equal(t, 7, data["t"][0].N)
+
+	reopened, err := OpenDisk(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{7, 8, 9, 10, 11, 12, 13}, values(reopened.Query("t", at(0), at(20))))
+	require.NoError(t, reopened.Close())
+}
+
+func TestDiskCrashDuringRoll(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDisk(dir)
+	require.NoError(t, err)
+	require.NoError(t, d.Append("t", Value{T: at(0), V: 20}))
+	require.NoError(t, d.Close())
+
+	// the segment is written, but the WAL isn't removed, nor the old segment after compaction
+	require.NoError(t, os.WriteFile(filepath.Join(dir, walName(1)), appendRecord(nil, "t", Value{T: at(0), V: 20}), 0o600))
+	require.NoError(t, writeSegment(dir, "segment-00000000-00000001.gob", encodeValues(map[string][]Value{"t": {{T: at(0), V: 20}}})))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "segment-00000003-00000003.gob.123.tmp"), []byte("half"), 0o600))
+
+	reopened, err := OpenDisk(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{20}, values(reopened.Query("t", at(0), at(10))), "values aren't doubled")
+	assert.Equal(t, []string{filepath.Join(dir, "segment-00000000-00000001.gob")}, files(t, dir, "segment-*"))
+	require.NoError(t, reopened.Close())
+}
+
+func TestImportDump(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hk-dump.gob")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, gob.NewEncoder(f).Encode(map[string][]Value{
+		"t": {{T: at(1), V: 21}, {T: at(0), V: 20}},
+		"h": {{T: at(0), V: 40}},
+	}))
+	require.NoError(t, f.Close())
+
+	d, err := OpenDisk(filepath.Join(dir, "metrics"))
+	require.NoError(t, err)
+	n, err := ImportDump(d, path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, d.Len())
+	assert.Equal(t, []float64{20, 21}, values(d.Query("t", at(0), at(10))))
+	require.NoError(t, d.Close())
+
+	_, err = ImportDump(d, filepath.Join(dir, "missing.gob"))
+	assert.Error(t, err)
+}
+
+func TestGauges(t *testing.T) {
+	d, err := OpenDisk(t.TempDir())
+	require.NoError(t, err)
+
+	g := NewGauges(d, 0)
+	g.now = func() time.Time { return at(150) }
+	for i := range 150 {
+		g.GaugeAt("t", float64(i%2), at(i))
+	}
+	g.Gauge("t", 10)
+
+	// 12:30 to 14:30, the value of now isn't included
+	avg := g.Avg("t", 2*time.Hour)
+	require.Len(t, avg, 3)
+	// values are decoded in the local time zone
+	assert.True(t, start.Equal(avg[0].T))
+	assert.True(t, start.Add(2*time.Hour).Equal(avg[2].T))
+	assert.Len(t, d.Query("t", at(0), at(151)), 151)
+	require.NoError(t, g.Close())
+}
+
+func values(vals []Value) []float64 {
+	vs := make([]float64, 0, len(vals))
+	for _, v := range vals {
+		vs = append(vs, v.V)
+	}
+
+	return vs
+}
@@ -14,6 +14,8 @@ import (
 
 const (
 	cleanerWorkerSleep = 30 * time.Second
+	// DumpPath is where InMem dumps values
+	DumpPath = "hk-dump.gob"
 )
 
 type DumpFn func() error
@@ -102,70 +104,96 @@ func New(opts ...Option) (m *InMem, commitDump DumpFn) {
 }
 
 func (m *InMem) Gauge(key string, val float64) {
-	log.Debg.Printf("send: gauge %s: %v", key, val)
+	m.GaugeAt(key, val, time.Now())
+}
+
+// GaugeAt records a value measured at t, e.g. a backfilled reading of a remote sensor
+func (m *InMem) GaugeAt(key string, val float64, t time.Time) {
+	log.Debg.Printf("send: gauge %s: %v at %v", key, val, t)
 	go func() {
 		m.gaugeTLch <- valueChanMsg{
 			key: key,
-			m:   Value{T: time.Now(), V: val},
+			m:   Value{T: t, V: val},
 		}
 	}()
 }
 
 func (m *InMem) Avg(key string, dur time.Duration) []Value {
+	now := time.Now()
+	return hourlyAvg(m.Query(key, now.Add(-dur), now))
+}
+
+// Append records a value at once, unlike GaugeAt it doesn't go through the collector
+func (m *InMem) Append(key string, v Value) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.GaugeTimeLine[key] = append(m.GaugeTimeLine[key], v)
+
+	return nil
+}
+
+// Query returns values of the key measured in [from, to) ordered by time
+func (m *InMem) Query(key string, from, to time.Time) []Value {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	data, ok := m.GaugeTimeLine[key]
-	if !ok {
-		return nil
-	}
-
-	// get data for duration
-	start, end := time.Now().Add(-dur), time.Now()
-	var durData []Value
-	for _, val := range data {
-		t := val.T
-		if t.After(start) && t.Before(end) {
-			durData = append(durData, val)
+	var vals []Value
+	for _, v := range m.GaugeTimeLine[key] {
+		if !v.T.Before(from) && v.T.Before(to) {
+			vals = append(vals, v)
 		}
 	}
-
-	// calc hourly avg
-	sort.Slice(durData, func(i, j int) bool {
-		return durData[i].T.Before(durData[j].T)
+	sort.SliceStable(vals, func(i, j int) bool {
+		return vals[i].T.Before(vals[j].T)
 	})
 
-	hAvg := make(map[time.Time][]float64)
-	for _, v := range durData {
-		t := v.T.Truncate(time.Hour)
-		hAvg[t] = append(hAvg[t], v.V)
-	}
+	return vals
+}
 
-	avg := make([]Value, 0, len(hAvg))
-	for k, v := range hAvg {
-		v = normalize(v)
-		sum := 0.0
+// Retain drops values measured before cutoff
+func (m *InMem) Retain(cutoff time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed int
+	for k, v := range m.GaugeTimeLine {
+		var newV []Value
 		for _, vv := range v {
-			sum += vv
+			if !vv.T.Before(cutoff) {
+				newV = append(newV, vv)
+			}
 		}
-		avg = append(avg, Value{T: k, V: sum / (float64(len(v)))})
+		removed += len(v) - len(newV)
+		m.GaugeTimeLine[k] = newV
 	}
 
-	// sort by time from newest to oldest
-	sort.Slice(avg, func(i, j int) bool {
-		return avg[i].T.Before(avg[j].T)
-	})
+	return removed
+}
 
-	return avg
+// Keys returns keys which have values
+func (m *InMem) Keys() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.GaugeTimeLine))
+	for k, v := range m.GaugeTimeLine {
+		if len(v) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
 }
 
-// normalize removes 2 the biggest and 2 smallest value to reduce outliers amount
-func normalize(xs []float64) []float64 {
-	if len(xs) < 5 {
-		return xs
+// Close makes a dump if backup is on
+func (m *InMem) Close() error {
+	if m.backup {
+		return m.Dump()
 	}
-	sort.Float64s(xs)
-	return xs[2 : len(xs)-3]
+
+	return nil
 }
 
 func (m *InMem) autosaver() {
@@ -202,34 +230,18 @@ func (m *InMem) cleaner() {
 
 	for {
 		<-time.After(cleanerWorkerSleep)
-		m.mu.Lock()
-		{
-			log.Debg.Printf("cleanup. retention period: %v\n", m.retentionDuration)
-			log.Debg.Println("current size:")
-			for k, v := range m.GaugeTimeLine {
-				log.Debg.Printf("-- %s: %d", k, len(v))
-			}
+		log.Debg.Printf("cleanup. retention period: %v\n", m.retentionDuration)
 
-			cutoff := time.Now().Add(-m.retentionDuration)
-			var totalVs, totalNewVs int
-			for k, v := range m.GaugeTimeLine {
-				var newV []Value
-				for _, vv := range v {
-					if vv.T.After(cutoff) {
-						newV = append(newV, vv)
-					}
-				}
-				totalVs += len(v)
-				totalNewVs += len(newV)
-				m.GaugeTimeLine[k] = newV
-			}
+		m.mu.RLock()
+		log.Debg.Println("current size:")
+		for k, v := range m.GaugeTimeLine {
+			log.Debg.Printf("-- %s: %d", k, len(v))
+		}
+		m.mu.RUnlock()
 
-			diff := totalVs - totalNewVs
-			if diff != 0 {
-				log.Debg.Printf("cleaner removed %d gauges by retention policy\n", diff)
-			}
+		if diff := m.Retain(time.Now().Add(-m.retentionDuration)); diff != 0 {
+			log.Debg.Printf("cleaner removed %d gauges by retention policy\n", diff)
 		}
-		m.mu.Unlock()
 	}
 }
 
@@ -244,7 +256,7 @@ func (m *InMem) Dump() error {
 	if err != nil {
 		return fmt.Errorf("can't encode items: %w", err)
 	}
-	err = os.WriteFile("hk-dump.gob", buf.Bytes(), 0o600)
+	err = os.WriteFile(DumpPath, buf.Bytes(), 0o600)
 	if err != nil {
 		return fmt.Errorf("can't save dump: %w", err)
 	}
@@ -253,21 +265,48 @@ func (m *InMem) Dump() error {
 }
 
 func (m *InMem) Restore() error {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	timeline, err := readDump(DumpPath)
+	if err != nil {
+		return err
+	}
+	m.GaugeTimeLine = timeline
 
-	f, err := os.ReadFile("hk-dump.gob")
+	return nil
+}
+
+func readDump(path string) (map[string][]Value, error) {
+	f, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("can't read dump: %w", err)
+		return nil, fmt.Errorf("can't read dump: %w", err)
 	}
 
-	buf := bytes.NewBuffer(f)
-	decoder := gob.NewDecoder(buf)
+	var timeline map[string][]Value
+	if err := gob.NewDecoder(bytes.NewBuffer(f)).Decode(&timeline); err != nil {
+		return nil, fmt.Errorf("can't decode items: %w", err)
+	}
 
-	err = decoder.Decode(&m.GaugeTimeLine)
+	return timeline, nil
+}
+
+// ImportDump appends values of an InMem dump to the storage, e.g. to move the history to Disk
+func ImportDump(s Storage, path string) (int, error) {
+	timeline, err := readDump(path)
 	if err != nil {
-		return fmt.Errorf("can't decode items: %w", err)
+		return 0, err
 	}
 
-	return nil
+	var n int
+	for key, vals := range timeline {
+		for _, v := range vals {
+			if err := s.Append(key, v); err != nil {
+				return n, fmt.Errorf("can't import %s: %w", key, err)
+			}
+			n++
+		}
+	}
+
+	return n, nil
 }
@@ -0,0 +1,317 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+// rollups are kept in a tier storage as a key per aggregate
+const (
+	minSuffix   = "/min"
+	maxSuffix   = "/max"
+	avgSuffix   = "/avg"
+	countSuffix = "/count"
+)
+
+// rollupDelay is how long a bucket waits for late values before it's rolled up. Values later than that,
+// e.g. readings of remote sensors backfilled after an outage, make their buckets rolled up again.
+const rollupDelay = 10 * time.Minute
+
+// Rollup aggregates values of a bucket starting at T
+type Rollup struct {
+	T             time.Time
+	Min, Max, Avg float64
+	Count         int
+}
+
+// tier keeps rollups of a step for the retention, forever if it's 0
+type tier struct {
+	step      time.Duration
+	retention time.Duration
+	storage   Storage
+	// rolled is where rollups of a key end, newer values aren't rolled up yet
+	rolled map[string]time.Time
+	// stale are starts of rolled up buckets in milliseconds which got late values, by key.
+	// They live until the next clean, so it's 30s at most a restart can lose.
+	stale map[string]map[int64]struct{}
+}
+
+// GaugesOption configures Gauges
+type GaugesOption func(g *Gauges)
+
+// WithTier adds a tier of rollups by step kept in the storage. Tiers are rolled up one from another,
+// so they go from the finest, a step has to be a multiple of the previous one.
+func WithTier(step, retention time.Duration, storage Storage) GaugesOption {
+	return func(g *Gauges) {
+		if len(g.tiers) > 0 {
+			if prev := g.tiers[len(g.tiers)-1].step; step <= prev || step%prev != 0 {
+				log.Erro.Printf("tier of %v isn't a multiple of %v, it's skipped", step, prev)
+				return
+			}
+		}
+		g.tiers = append(g.tiers, &tier{
+			step:      step,
+			retention: retention,
+			storage:   storage,
+			rolled:    make(map[string]time.Time),
+			stale:     make(map[string]map[int64]struct{}),
+		})
+	}
+}
+
+// append writes aggregates of a rollup one by one, the count goes last: a rollup without it
+// was cut by a crash or an error, it's rolled up again and the new aggregates win
+func (t *tier) append(key string, r Rollup) error {
+	for _, agg := range []struct {
+		suffix string
+		v      float64
+	}{{minSuffix, r.Min}, {maxSuffix, r.Max}, {avgSuffix, r.Avg}, {countSuffix, float64(r.Count)}} {
+		if err := t.storage.Append(key+agg.suffix, Value{T: r.T, V: agg.v}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// query returns rollups with all aggregates, aggregates are matched by time and the last appended one wins
+func (t *tier) query(key string, from, to time.Time) []Rollup {
+	if !from.Before(to) {
+		return nil
+	}
+
+	latest := func(suffix string) map[int64]float64 {
+		vals := t.storage.Query(key+suffix, from, to)
+		byTime := make(map[int64]float64, len(vals))
+		for _, v := range vals {
+			byTime[v.T.UnixMilli()] = v.V
+		}
+
+		return byTime
+	}
+	mins, maxs, avgs := latest(minSuffix), latest(maxSuffix), latest(avgSuffix)
+
+	var rs []Rollup
+	for _, c := range t.storage.Query(key+countSuffix, from, to) {
+		ms := c.T.UnixMilli()
+		if len(rs) > 0 && rs[len(rs)-1].T.UnixMilli() == ms {
+			// rolled up again, the previous rollup is replaced
+			rs = rs[:len(rs)-1]
+		}
+
+		minV, okMin := mins[ms]
+		maxV, okMax := maxs[ms]
+		avgV, okAvg := avgs[ms]
+		if !okMin || !okMax || !okAvg {
+			log.Erro.Printf("rollup of %s by %v at %v misses aggregates", key, t.step, c.T)
+			continue
+		}
+		rs = append(rs, Rollup{T: c.T, Min: minV, Max: maxV, Avg: avgV, Count: int(c.V)})
+	}
+
+	return rs
+}
+
+// Rollups returns rollups of the key in [from, to) from the finest tier which still keeps from,
+// a raw value is a rollup of itself
+func (g *Gauges) Rollups(key string, from, to time.Time) []Rollup {
+	return g.rollups(g.level(from), key, from, to)
+}
+
+// level returns the finest level which keeps values since from, the coarsest one if none does.
+// Level 0 is raw values, tiers go next.
+func (g *Gauges) level(from time.Time) int {
+	now := g.now()
+	for level := range len(g.tiers) + 1 {
+		if g.keeps(level, from, now) {
+			return level
+		}
+	}
+
+	return len(g.tiers)
+}
+
+// keeps reports whether the level still keeps values since from
+func (g *Gauges) keeps(level int, from, now time.Time) bool {
+	retention := g.retention
+	if level > 0 {
+		retention = g.tiers[level-1].retention
+	}
+
+	return retention == 0 || !from.Before(now.Add(-retention))
+}
+
+// rollups returns rollups of the key in [from, to) of the level. A tier lags behind its source,
+// so the rest of the range is rolled up from the source on the fly.
+func (g *Gauges) rollups(level int, key string, from, to time.Time) []Rollup {
+	if level == 0 {
+		vals := g.storage.Query(key, from, to)
+		rs := make([]Rollup, 0, len(vals))
+		for _, v := range vals {
+			if !math.IsNaN(v.V) {
+				rs = append(rs, Rollup{T: v.T, Min: v.V, Max: v.V, Avg: v.V, Count: 1})
+			}
+		}
+
+		return rs
+	}
+
+	t := g.tiers[level-1]
+	rolled := g.rolled(t, key)
+	end := to
+	if rolled.Before(end) {
+		end = rolled
+	}
+	rs := t.query(key, from, end)
+	if rolled.Before(to) {
+		if from.After(rolled) {
+			rolled = from
+		}
+		rs = append(rs, rollUp(g.rollups(level-1, key, rolled, to), t.step)...)
+	}
+
+	return rs
+}
+
+// rolled returns where rollups of the key end, it's taken from the tier storage once
+func (g *Gauges) rolled(t *tier, key string) time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if rolled, ok := t.rolled[key]; ok {
+		return rolled
+	}
+
+	var from, rolled time.Time
+	if t.retention > 0 {
+		from = g.now().Add(-t.retention - t.step)
+	}
+	if counts := t.storage.Query(key+countSuffix, from, g.now()); len(counts) > 0 {
+		rolled = counts[len(counts)-1].T.Add(t.step)
+	}
+	t.rolled[key] = rolled
+
+	return rolled
+}
+
+// markStale makes the bucket of t rolled up again, if the tier of the level has rolled it up already
+func (g *Gauges) markStale(level int, key string, t time.Time) {
+	tr := g.tiers[level-1]
+	if !t.Before(g.rolled(tr, key)) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if tr.stale[key] == nil {
+		tr.stale[key] = make(map[int64]struct{})
+	}
+	tr.stale[key][t.Truncate(tr.step).UnixMilli()] = struct{}{}
+}
+
+// rollUpStale rolls stale buckets of the level up again, the new rollups replace the old ones.
+// A bucket which the source has dropped a part of already is left as it is.
+func (g *Gauges) rollUpStale(level int, now time.Time) int {
+	t := g.tiers[level-1]
+	g.mu.Lock()
+	stale := t.stale
+	t.stale = make(map[string]map[int64]struct{})
+	g.mu.Unlock()
+
+	var n int
+	for key, buckets := range stale {
+		for ms := range buckets {
+			from := time.UnixMilli(ms)
+			if !g.keeps(level-1, from, now) {
+				continue
+			}
+
+			for _, r := range rollUp(g.rollups(level-1, key, from, from.Add(t.step)), t.step) {
+				if err := t.append(key, r); err != nil {
+					log.Erro.Printf("can't roll %s up by %v again: %s", key, t.step, err.Error())
+					g.markStale(level, key, from)
+					continue
+				}
+				n++
+				if level < len(g.tiers) {
+					g.markStale(level+1, key, r.T)
+				}
+			}
+		}
+	}
+
+	return n
+}
+
+// rollUpTier rolls closed buckets of the level up from the previous one and returns how many,
+// stale buckets are rolled up again first
+func (g *Gauges) rollUpTier(level int, now time.Time) int {
+	t := g.tiers[level-1]
+	to := now.Add(-rollupDelay).Truncate(t.step)
+
+	n := g.rollUpStale(level, now)
+	for _, key := range g.sourceKeys(level - 1) {
+		from := g.rolled(t, key)
+		if !from.Before(to) {
+			continue
+		}
+
+		rolled := to
+		for _, r := range rollUp(g.rollups(level-1, key, from, to), t.step) {
+			if err := t.append(key, r); err != nil {
+				log.Erro.Printf("can't roll %s up by %v: %s", key, t.step, err.Error())
+				rolled = r.T
+				break
+			}
+			n++
+		}
+
+		g.mu.Lock()
+		t.rolled[key] = rolled
+		g.mu.Unlock()
+	}
+
+	return n
+}
+
+// sourceKeys returns keys of the level
+func (g *Gauges) sourceKeys(level int) []string {
+	if level == 0 {
+		return g.storage.Keys()
+	}
+
+	var keys []string
+	for _, key := range g.tiers[level-1].storage.Keys() {
+		if k, ok := strings.CutSuffix(key, countSuffix); ok {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// rollUp aggregates rollups ordered by time into buckets of step, the average is weighted by count
+func rollUp(rs []Rollup, step time.Duration) []Rollup {
+	var (
+		buckets []Rollup
+		sum     float64
+	)
+	for _, r := range rs {
+		start := r.T.Truncate(step)
+		if len(buckets) == 0 || !buckets[len(buckets)-1].T.Equal(start) {
+			buckets = append(buckets, Rollup{T: start, Min: r.Min, Max: r.Max})
+			sum = 0
+		}
+
+		b := &buckets[len(buckets)-1]
+		b.Min, b.Max = math.Min(b.Min, r.Min), math.Max(b.Max, r.Max)
+		b.Count += r.Count
+		sum += r.Avg * float64(r.Count)
+		b.Avg = sum / float64(b.Count)
+	}
+
+	return buckets
+}
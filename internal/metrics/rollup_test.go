@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollUp(t *testing.T) {
+	rs := rollUp([]Rollup{
+		{T: at(0), Min: 1, Max: 3, Avg: 2, Count: 2},
+		{T: at(4), Min: 0, Max: 5, Avg: 5, Count: 1},
+		{T: at(5), Min: 7, Max: 7, Avg: 7, Count: 1},
+	}, 5*time.Minute)
+
+	assert.Equal(t, []Rollup{
+		{T: at(0), Min: 0, Max: 5, Avg: 3, Count: 3},
+		{T: at(5), Min: 7, Max: 7, Avg: 7, Count: 1},
+	}, rs)
+	assert.Empty(t, rollUp(nil, time.Hour))
+}
+
+func TestGaugesTiers(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *Gauges {
+		raw, err := OpenDisk(filepath.Join(dir, "raw"))
+		require.NoError(t, err)
+		fine, err := OpenDisk(filepath.Join(dir, "5m"))
+		require.NoError(t, err)
+		coarse, err := OpenDisk(filepath.Join(dir, "1h"))
+		require.NoError(t, err)
+
+		return NewGauges(raw, 2*time.Hour,
+			WithTier(5*time.Minute, 24*time.Hour, fine),
+			WithTier(time.Hour, 0, coarse),
+			WithTier(90*time.Minute, 0, coarse),
+		)
+	}
+
+	g := open()
+	require.Len(t, g.tiers, 2, "90m isn't a multiple of 1h")
+	for i := range 6 * 60 {
+		g.GaugeAt("t", float64(i), at(i))
+	}
+
+	// 5m buckets are rolled up to 10 minutes ago, hourly ones to the last hour, raw values older than 2h are dropped
+	g.now = func() time.Time { return at(6 * 60) }
+	g.clean(at(6 * 60))
+	assert.Len(t, g.tiers[0].query("t", at(0), at(6*60)), 70)
+	assert.Len(t, g.tiers[1].query("t", at(0), at(6*60)), 5)
+	assert.Len(t, g.storage.Query("t", at(0), at(6*60)), 120)
+
+	// 5m rollups are the finest which keep the range, the last 10 minutes are rolled up from raw values
+	rs := g.Rollups("t", at(0), at(6*60))
+	require.Len(t, rs, 72)
+	assert.True(t, at(0).Equal(rs[0].T))
+	assert.Equal(t, Rollup{T: rs[0].T, Min: 0, Max: 4, Avg: 2, Count: 5}, rs[0])
+	assert.True(t, at(355).Equal(rs[71].T))
+	assert.Equal(t, 5, rs[71].Count)
+	assert.Equal(t, 357.0, rs[71].Avg)
+	assert.Len(t, g.Rollups("t", at(5*60), at(6*60)), 60, "raw values")
+
+	// 2 days later only hourly rollups are left
+	g.now = func() time.Time { return at(2 * 24 * 60) }
+	g.clean(at(2 * 24 * 60))
+	assert.Empty(t, g.storage.Query("t", at(0), at(6*60)))
+	assert.Empty(t, g.tiers[0].query("t", at(0), at(6*60)))
+
+	rs = g.Rollups("t", at(0), at(6*60))
+	require.Len(t, rs, 6)
+	for h, r := range rs {
+		assert.True(t, at(h*60).Equal(r.T))
+		assert.Equal(t, float64(h*60), r.Min)
+		assert.Equal(t, float64(h*60+59), r.Max)
+		assert.InDelta(t, float64(h*60)+29.5, r.Avg, 1e-9)
+		assert.Equal(t, 60, r.Count)
+	}
+
+	avg := g.Avg("t", 3*24*time.Hour)
+	require.Len(t, avg, 6)
+	assert.InDelta(t, 29.5, avg[0].V, 1e-9)
+	require.NoError(t, g.Close())
+
+	// where tiers are rolled up to is taken from their storages after a restart
+	g = open()
+	g.now = func() time.Time { return at(2 * 24 * 60) }
+	assert.True(t, at(6*60).Equal(g.rolled(g.tiers[1], "t")))
+	assert.Equal(t, []string{"t"}, g.sourceKeys(2))
+	require.NoError(t, g.Close())
+}
+
+func TestGaugesPartialRollup(t *testing.T) {
+	dir := t.TempDir()
+	open := func() (*Gauges, Storage) {
+		raw, err := OpenDisk(filepath.Join(dir, "raw"))
+		require.NoError(t, err)
+		fine, err := OpenDisk(filepath.Join(dir, "5m"))
+		require.NoError(t, err)
+
+		return NewGauges(raw, 0, WithTier(5*time.Minute, 0, fine)), fine
+	}
+
+	g, fine := open()
+	for i := range 60 {
+		g.GaugeAt("t", float64(i), at(i))
+	}
+	g.now = func() time.Time { return at(60) }
+	g.clean(at(60))
+	require.Len(t, g.tiers[0].query("t", at(0), at(60)), 10)
+
+	// a power cut while the bucket at 50 is rolled up: its count isn't written
+	require.NoError(t, fine.Append("t"+minSuffix, Value{T: at(50), V: -100}))
+	require.NoError(t, fine.Append("t"+maxSuffix, Value{T: at(50), V: 100}))
+	require.NoError(t, g.Close())
+
+	g, _ = open()
+	g.now = func() time.Time { return at(70) }
+	assert.Len(t, g.tiers[0].query("t", at(0), at(60)), 10, "the partial rollup is skipped")
+	assert.True(t, at(50).Equal(g.rolled(g.tiers[0], "t")))
+
+	g.clean(at(70))
+	rs := g.tiers[0].query("t", at(0), at(60))
+	require.Len(t, rs, 12)
+	assert.Equal(t, Rollup{T: rs[10].T, Min: 50, Max: 54, Avg: 52, Count: 5}, rs[10])
+	assert.Equal(t, Rollup{T: rs[11].T, Min: 55, Max: 59, Avg: 57, Count: 5}, rs[11])
+	require.NoError(t, g.Close())
+}
+
+func TestGaugesLateValues(t *testing.T) {
+	raw, err := OpenDisk(filepath.Join(t.TempDir(), "raw"))
+	require.NoError(t, err)
+	fine, err := OpenDisk(filepath.Join(t.TempDir(), "5m"))
+	require.NoError(t, err)
+	coarse, err := OpenDisk(filepath.Join(t.TempDir(), "1h"))
+	require.NoError(t, err)
+	g := NewGauges(raw, 3*time.Hour, WithTier(5*time.Minute, 0, fine), WithTier(time.Hour, 0, coarse))
+
+	for i := range 120 {
+		g.GaugeAt("t", 1, at(i))
+	}
+	g.now = func() time.Time { return at(120) }
+	g.clean(at(120))
+
+	// a node backfills a reading after an outage, both tiers roll its buckets up again
+	g.GaugeAt("t", 100, at(3))
+	g.clean(at(121))
+	rs := g.tiers[0].query("t", at(0), at(5))
+	require.Len(t, rs, 1)
+	assert.Equal(t, Rollup{T: rs[0].T, Min: 1, Max: 100, Avg: 105.0 / 6, Count: 6}, rs[0])
+	hourly := g.tiers[1].query("t", at(0), at(120))
+	require.Len(t, hourly, 1)
+	assert.Equal(t, 100.0, hourly[0].Max)
+	assert.Equal(t, 61, hourly[0].Count)
+
+	// once raw values of the bucket are dropped, a late value would replace the bucket with a part of it
+	g.clean(at(5 * 60))
+	g.GaugeAt("t", -100, at(0))
+	g.clean(at(5*60 + 1))
+	rs = g.tiers[0].query("t", at(0), at(5))
+	require.Len(t, rs, 1)
+	assert.Equal(t, 1.0, rs[0].Min)
+	assert.Equal(t, 6, rs[0].Count)
+	require.NoError(t, g.Close())
+}
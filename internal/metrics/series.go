@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+// encodedChunk is a chunk in a segment file, Min and Max are its time range in milliseconds
+type encodedChunk struct {
+	N        int
+	Min, Max int64
+	B        []byte
+}
+
+// series keeps values of a key in compressed chunks, the last one takes appends
+type series struct {
+	chunks []*chunk
+	// sealed is how many first chunks don't take appends, e.g. the ones loaded from segments
+	sealed int
+}
+
+func newSeries(vals []Value) *series {
+	s := &series{}
+	for _, v := range vals {
+		s.append(v)
+	}
+
+	return s
+}
+
+func (s *series) append(v Value) {
+	if len(s.chunks) == s.sealed || s.chunks[len(s.chunks)-1].n >= chunkSize {
+		s.seal()
+		s.chunks = append(s.chunks, &chunk{})
+	}
+	s.chunks[len(s.chunks)-1].append(v)
+}
+
+// seal trims the spare capacity of the last chunk, it doesn't take appends anymore
+func (s *series) seal() {
+	if len(s.chunks) > s.sealed {
+		last := s.chunks[len(s.chunks)-1]
+		last.b.b = slices.Clip(last.b.b)
+	}
+	s.sealed = len(s.chunks)
+}
+
+// adopt appends encoded chunks as they are, they are sealed
+func (s *series) adopt(chunks []encodedChunk) {
+	s.seal()
+	for _, ec := range chunks {
+		s.chunks = append(s.chunks, &chunk{b: bstream{b: ec.B}, n: ec.N, min: ec.Min, max: ec.Max})
+	}
+	s.sealed = len(s.chunks)
+}
+
+// encode returns chunks as they are kept in segments
+func (s *series) encode() []encodedChunk {
+	chunks := make([]encodedChunk, 0, len(s.chunks))
+	for _, c := range s.chunks {
+		chunks = append(chunks, encodedChunk{N: c.n, Min: c.min, Max: c.max, B: c.b.b})
+	}
+
+	return chunks
+}
+
+func (s *series) len() int {
+	var n int
+	for _, c := range s.chunks {
+		n += c.n
+	}
+
+	return n
+}
+
+// size is how many bytes chunks take
+func (s *series) size() int {
+	var size int
+	for _, c := range s.chunks {
+		size += c.size()
+	}
+
+	return size
+}
+
+// query decodes chunks within [from, to) and returns values within it ordered by time,
+// the range is in milliseconds like timestamps in chunks
+func (s *series) query(from, to time.Time) []Value {
+	fromMs, toMs := from.UnixMilli(), to.UnixMilli()
+
+	var vals []Value
+	for _, c := range s.chunks {
+		if c.max < fromMs || c.min >= toMs {
+			continue
+		}
+
+		decoded, err := c.values()
+		if err != nil {
+			log.Erro.Printf("can't decode chunk: %s", err.Error())
+			continue
+		}
+		for _, v := range decoded {
+			if t := v.T.UnixMilli(); t >= fromMs && t < toMs {
+				vals = append(vals, v)
+			}
+		}
+	}
+
+	// chunks of backfilled values overlap
+	if !slices.IsSortedFunc(vals, func(a, b Value) int { return a.T.Compare(b.T) }) {
+		sort.SliceStable(vals, func(i, j int) bool { return vals[i].T.Before(vals[j].T) })
+	}
+
+	return vals
+}
+
+// retain drops values before cutoff, chunks partly before it are encoded again in place,
+// so values measured at the same time stay in the order they were appended
+func (s *series) retain(cutoff time.Time) int {
+	cutoffMs := cutoff.UnixMilli()
+
+	var (
+		removed int
+		kept    []*chunk
+		// the last chunk keeps taking appends if it's kept as is
+		head bool
+	)
+	for i, c := range s.chunks {
+		switch {
+		case c.max < cutoffMs:
+			removed += c.n
+		case c.min >= cutoffMs:
+			kept = append(kept, c)
+			head = i >= s.sealed
+		default:
+			decoded, err := c.values()
+			if err != nil {
+				log.Erro.Printf("can't decode chunk: %s", err.Error())
+				removed += c.n
+				continue
+			}
+			rest := slices.DeleteFunc(decoded, func(v Value) bool { return v.T.UnixMilli() < cutoffMs })
+			removed += c.n - len(rest)
+			if len(rest) > 0 {
+				partial := newSeries(rest)
+				partial.seal()
+				kept = append(kept, partial.chunks...)
+			}
+			head = false
+		}
+	}
+	if removed == 0 {
+		return 0
+	}
+
+	s.chunks, s.sealed = kept, len(kept)
+	if head {
+		s.sealed--
+	}
+
+	return removed
+}
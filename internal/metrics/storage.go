@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+// Storage keeps gauge values by key. InMem keeps them in memory and dumps them from time to time,
+// Disk appends them to a write-ahead log, so they survive a power cut.
+type Storage interface {
+	Append(key string, v Value) error
+	// Query returns values of the key measured in [from, to) ordered by time,
+	// values measured at the same time are in the order they were appended
+	Query(key string, from, to time.Time) []Value
+	// Retain drops values measured before cutoff and returns how many were dropped
+	Retain(cutoff time.Time) int
+	// Keys returns keys which have values
+	Keys() []string
+	// Close saves what isn't saved yet
+	Close() error
+}
+
+// Gauges records gauges to a Storage and aggregates them, it drops values older than the retention.
+// Before values are dropped, tiers roll them up into min/max/avg/count of coarser and coarser buckets.
+type Gauges struct {
+	storage   Storage
+	retention time.Duration
+	tiers     []*tier
+	now       func() time.Time
+	done      chan struct{}
+
+	// mu guards where tiers are rolled up to
+	mu sync.Mutex
+}
+
+// NewGauges records to the storage, no retention if it's 0
+func NewGauges(storage Storage, retention time.Duration, opts ...GaugesOption) *Gauges {
+	g := &Gauges{storage: storage, retention: retention, now: time.Now, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	if retention == 0 {
+		log.Info.Println("retention isn't setted up")
+	}
+	if retention > 0 || len(g.tiers) > 0 {
+		go g.cleaner()
+	}
+
+	return g
+}
+
+func (g *Gauges) Gauge(key string, val float64) {
+	g.GaugeAt(key, val, g.now())
+}
+
+// GaugeAt records a value measured at t, e.g. a backfilled reading of a remote sensor.
+// If its bucket is rolled up already, it's rolled up again on the next clean.
+func (g *Gauges) GaugeAt(key string, val float64, t time.Time) {
+	log.Debg.Printf("gauge %s: %v at %v", key, val, t)
+	if err := g.storage.Append(key, Value{T: t, V: val}); err != nil {
+		log.Erro.Printf("can't record %s: %s", key, err.Error())
+		return
+	}
+	if len(g.tiers) > 0 {
+		g.markStale(1, key, t)
+	}
+}
+
+// Avg returns hourly averages of the key for the last dur, rollups are used if raw values are dropped already
+func (g *Gauges) Avg(key string, dur time.Duration) []Value {
+	now := g.now()
+	from := now.Add(-dur)
+	level := g.level(from)
+	if level == 0 {
+		return hourlyAvg(g.storage.Query(key, from, now))
+	}
+
+	rs := rollUp(g.rollups(level, key, from, now), time.Hour)
+	avg := make([]Value, 0, len(rs))
+	for _, r := range rs {
+		avg = append(avg, Value{T: r.T, V: r.Avg})
+	}
+
+	return avg
+}
+
+// Close stops the cleaner and closes storages
+func (g *Gauges) Close() error {
+	close(g.done)
+
+	errs := []error{g.storage.Close()}
+	for _, t := range g.tiers {
+		errs = append(errs, t.storage.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (g *Gauges) cleaner() {
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-time.After(cleanerWorkerSleep):
+		}
+
+		g.clean(g.now())
+	}
+}
+
+// clean rolls values up into tiers and drops the ones older than retentions
+func (g *Gauges) clean(now time.Time) {
+	for level := 1; level <= len(g.tiers); level++ {
+		if n := g.rollUpTier(level, now); n != 0 {
+			log.Debg.Printf("cleaner rolled up %d buckets of %v", n, g.tiers[level-1].step)
+		}
+	}
+
+	if g.retention > 0 {
+		if n := g.storage.Retain(now.Add(-g.retention)); n != 0 {
+			log.Debg.Printf("cleaner removed %d gauges by retention policy", n)
+		}
+	}
+	for _, t := range g.tiers {
+		if t.retention == 0 {
+			continue
+		}
+		if n := t.storage.Retain(now.Add(-t.retention)); n != 0 {
+			log.Debg.Printf("cleaner removed %d rollups of %v by retention policy", n, t.step)
+		}
+	}
+}
+
+// hourlyAvg averages values by hour without outliers, averages are ordered by time
+func hourlyAvg(vals []Value) []Value {
+	hAvg := make(map[time.Time][]float64)
+	for _, v := range vals {
+		t := v.T.Truncate(time.Hour)
+		hAvg[t] = append(hAvg[t], v.V)
+	}
+
+	avg := make([]Value, 0, len(hAvg))
+	for k, v := range hAvg {
+		v = normalize(v)
+		sum := 0.0
+		for _, vv := range v {
+			sum += vv
+		}
+		avg = append(avg, Value{T: k, V: sum / (float64(len(v)))})
+	}
+
+	sort.Slice(avg, func(i, j int) bool {
+		return avg[i].T.Before(avg[j].T)
+	})
+
+	return avg
+}
+
+// normalize removes 2 the biggest and 2 smallest value to reduce outliers amount
+func normalize(xs []float64) []float64 {
+	if len(xs) < 5 {
+		return xs
+	}
+	sort.Float64s(xs)
+	return xs[2 : len(xs)-3]
+}
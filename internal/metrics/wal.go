@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+)
+
+// walHeaderSize is CRC-32 and length of a record payload
+const walHeaderSize = 8
+
+// maxRecordSize guards replay from a garbage length in a torn record
+const maxRecordSize = 1 << 16
+
+var errTornRecord = errors.New("torn record")
+
+// appendRecord encodes a WAL record: CRC-32 of the payload, payload length and payload,
+// the payload is key length, key, unix nanoseconds and float bits
+func appendRecord(buf []byte, key string, v Value) []byte {
+	payload := binary.AppendUvarint(nil, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = binary.AppendVarint(payload, v.T.UnixNano())
+	payload = binary.LittleEndian.AppendUint64(payload, math.Float64bits(v.V))
+
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+
+	return append(buf, payload...)
+}
+
+// replayRecords calls fn for every record and returns the size of the valid part of the log.
+// A power cut can leave the last record torn, replay stops there with errTornRecord.
+func replayRecords(r io.Reader, fn func(key string, v Value)) (int64, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, walHeaderSize)
+	var valid int64
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return valid, nil
+			}
+			return valid, errTornRecord
+		}
+
+		sum, size := binary.LittleEndian.Uint32(header), binary.LittleEndian.Uint32(header[4:])
+		if size > maxRecordSize {
+			return valid, errTornRecord
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(br, payload); err != nil || crc32.ChecksumIEEE(payload) != sum {
+			return valid, errTornRecord
+		}
+
+		key, v, err := decodePayload(payload)
+		if err != nil {
+			return valid, err
+		}
+		fn(key, v)
+		valid += walHeaderSize + int64(size)
+	}
+}
+
+func decodePayload(payload []byte) (string, Value, error) {
+	keyLen, n := binary.Uvarint(payload)
+	if n <= 0 || uint64(len(payload)-n) < keyLen {
+		return "", Value{}, fmt.Errorf("invalid key length in record")
+	}
+	payload = payload[n:]
+	key := string(payload[:keyLen])
+	payload = payload[keyLen:]
+
+	nanos, n := binary.Varint(payload)
+	if n <= 0 || len(payload)-n != 8 {
+		return "", Value{}, fmt.Errorf("invalid value of %s in record", key)
+	}
+
+	return key, Value{
+		T: time.Unix(0, nanos),
+		V: math.Float64frombits(binary.LittleEndian.Uint64(payload[n:])),
+	}, nil
+}
@@ -0,0 +1,239 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/egregors/hk/internal/derived"
+	"github.com/egregors/hk/log"
+)
+
+// DefaultDiscoveryPrefix is the discovery prefix Home Assistant listens to by default
+const DefaultDiscoveryPrefix = "homeassistant"
+
+// Device describes hk itself in Home Assistant, USB power switch belongs to it
+type Device struct {
+	Name         string
+	Manufacturer string
+	Model        string
+	// Revision is hk revision
+	Revision string
+}
+
+// DiscoverySensor describes a sensor and quantities it measures, temperature is always measured
+type DiscoverySensor struct {
+	ID   string
+	Room string
+
+	Manufacturer string
+	Model        string
+
+	Humidity   bool
+	Pressure   bool
+	CO2        bool
+	AirQuality bool
+}
+
+// Discovery is the set of Home Assistant entities to announce
+type Discovery struct {
+	// Prefix is DefaultDiscoveryPrefix if not set
+	Prefix string
+	// NodeID groups hk entities in discovery topics, client ID if not set
+	NodeID  string
+	Device  Device
+	Sensors []DiscoverySensor
+	// USB2Power is the name of USB power switch, no switch if empty
+	USB2Power string
+}
+
+// quantity is Home Assistant sensor description of a reading
+type quantity struct {
+	name        string
+	deviceClass string
+	unit        string
+}
+
+// quantities by metric keys, the ones hk publishes readings with
+var quantities = map[string]quantity{
+	"current_temperature":  {name: "Temperature", deviceClass: "temperature", unit: "°C"},
+	"current_humidity":     {name: "Humidity", deviceClass: "humidity", unit: "%"},
+	"current_pressure":     {name: "Pressure", deviceClass: "atmospheric_pressure", unit: "hPa"},
+	"current_co2":          {name: "CO2", deviceClass: "carbon_dioxide", unit: "ppm"},
+	"gas_resistance":       {name: "Gas resistance", unit: "Ω"},
+	"iaq":                  {name: "IAQ", deviceClass: "aqi"},
+	"voc_density":          {name: "VOC", deviceClass: "volatile_organic_compounds", unit: "µg/m³"},
+	derived.DewPointKey:    {name: "Dew point", deviceClass: "temperature", unit: "°C"},
+	derived.AbsHumidityKey: {name: "Absolute humidity", unit: "g/m³"},
+	derived.HumidexKey:     {name: "Humidex"},
+	derived.HeatIndexKey:   {name: "Heat index", deviceClass: "temperature", unit: "°C"},
+	derived.MixingRatioKey: {name: "Mixing ratio", unit: "g/kg"},
+}
+
+// quantities returns metric keys of readings and derived values the sensor has
+func (s DiscoverySensor) quantities() []string {
+	keys := []string{"current_temperature"}
+	if s.Humidity {
+		keys = append(keys, "current_humidity", derived.DewPointKey, derived.AbsHumidityKey, derived.HumidexKey, derived.HeatIndexKey)
+	}
+	if s.Pressure {
+		keys = append(keys, "current_pressure")
+	}
+	if s.Humidity && s.Pressure {
+		keys = append(keys, derived.MixingRatioKey)
+	}
+	if s.CO2 {
+		keys = append(keys, "current_co2")
+	}
+	if s.AirQuality {
+		keys = append(keys, "gas_resistance", "iaq", "voc_density")
+	}
+
+	return keys
+}
+
+var notObjectIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// objectID makes a part of discovery topic and unique ID from any string
+func objectID(parts ...string) string {
+	return notObjectIDChars.ReplaceAllString(strings.Join(parts, "_"), "_")
+}
+
+// haDevice is the device block of discovery config
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+	ViaDevice    string   `json:"via_device,omitempty"`
+}
+
+type haAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// haConfig is discovery config of sensor, binary_sensor and switch
+type haConfig struct {
+	Name                string           `json:"name"`
+	UniqueID            string           `json:"unique_id"`
+	StateTopic          string           `json:"state_topic"`
+	CommandTopic        string           `json:"command_topic,omitempty"`
+	DeviceClass         string           `json:"device_class,omitempty"`
+	StateClass          string           `json:"state_class,omitempty"`
+	Unit                string           `json:"unit_of_measurement,omitempty"`
+	EntityCategory      string           `json:"entity_category,omitempty"`
+	PayloadOn           string           `json:"payload_on,omitempty"`
+	PayloadOff          string           `json:"payload_off,omitempty"`
+	Availability        []haAvailability `json:"availability"`
+	AvailabilityMode    string           `json:"availability_mode,omitempty"`
+	PayloadAvailable    string           `json:"payload_available"`
+	PayloadNotAvailable string           `json:"payload_not_available"`
+	Device              haDevice         `json:"device"`
+}
+
+// makeConfigs returns discovery payloads by config topics
+func (c *Client) makeConfigs() map[string]string {
+	d := c.discovery
+	configs := make(map[string]string)
+	add := func(component, object string, cfg haConfig) {
+		cfg.UniqueID = objectID(d.NodeID, object)
+		cfg.PayloadAvailable, cfg.PayloadNotAvailable = Online, Offline
+		payload, err := json.Marshal(cfg)
+		if err != nil {
+			log.Erro.Printf("can't make discovery config of %s: %s", cfg.UniqueID, err.Error())
+			return
+		}
+		configs[d.Prefix+"/"+component+"/"+d.NodeID+"/"+objectID(object)+"/config"] = string(payload)
+	}
+
+	hk := haDevice{
+		Identifiers:  []string{d.NodeID},
+		Name:         d.Device.Name,
+		Manufacturer: d.Device.Manufacturer,
+		Model:        d.Device.Model,
+		SWVersion:    d.Device.Revision,
+	}
+
+	for _, s := range d.Sensors {
+		id := s.ID
+		if id == "" {
+			id = DefaultSensorID
+		}
+
+		device := haDevice{
+			Identifiers:  []string{objectID(d.NodeID, id)},
+			Name:         s.Room,
+			Manufacturer: s.Manufacturer,
+			Model:        s.Model,
+			ViaDevice:    d.NodeID,
+		}
+		// readings are unavailable if hk or the sensor is offline
+		availability := []haAvailability{{Topic: c.AvailabilityTopic()}, {Topic: c.SensorTopic(s.ID, "status")}}
+
+		for _, key := range s.quantities() {
+			q := quantities[key]
+			add("sensor", id+"_"+key, haConfig{
+				Name:             q.name,
+				StateTopic:       c.SensorTopic(s.ID, key),
+				DeviceClass:      q.deviceClass,
+				StateClass:       "measurement",
+				Unit:             q.unit,
+				Availability:     availability,
+				AvailabilityMode: "all",
+				Device:           device,
+			})
+		}
+
+		add("binary_sensor", id+"_status", haConfig{
+			Name:           "Connectivity",
+			StateTopic:     c.SensorTopic(s.ID, "status"),
+			DeviceClass:    "connectivity",
+			EntityCategory: "diagnostic",
+			PayloadOn:      Online,
+			PayloadOff:     Offline,
+			Availability:   []haAvailability{{Topic: c.AvailabilityTopic()}},
+			Device:         device,
+		})
+	}
+
+	if d.USB2Power != "" {
+		add("switch", "usb2power", haConfig{
+			Name:         d.USB2Power,
+			StateTopic:   c.USB2PowerStateTopic(),
+			CommandTopic: c.USB2PowerCommandTopic(),
+			PayloadOn:    PowerOn,
+			PayloadOff:   PowerOff,
+			Availability: []haAvailability{{Topic: c.AvailabilityTopic()}},
+			Device:       hk,
+		})
+	}
+
+	return configs
+}
+
+// announce publishes discovery configs and subscribes to the retained ones to remove the stale
+func (c *Client) announce(cli paho.Client) {
+	for topic, payload := range c.configs {
+		c.publish(topic, payload)
+	}
+
+	filter := c.discovery.Prefix + "/+/" + c.discovery.NodeID + "/+/config"
+	logError(cli.Subscribe(filter, qos, c.handleConfig), "subscribe to "+filter)
+}
+
+// handleConfig removes entities which aren't in the configuration anymore, e.g. of a removed sensor.
+// Home Assistant deletes an entity when its config is replaced with an empty one.
+func (c *Client) handleConfig(_ paho.Client, msg paho.Message) {
+	if len(msg.Payload()) == 0 {
+		return
+	}
+	if _, ok := c.configs[msg.Topic()]; ok {
+		return
+	}
+
+	log.Info.Printf("remove stale Home Assistant entity %s", msg.Topic())
+	c.publish(msg.Topic(), "")
+}
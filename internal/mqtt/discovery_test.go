@@ -0,0 +1,105 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoverySensor_Quantities(t *testing.T) {
+	assert.Equal(t, []string{"current_temperature"}, DiscoverySensor{}.quantities())
+	assert.Equal(t,
+		[]string{"current_temperature", "current_pressure", "current_co2"},
+		DiscoverySensor{Pressure: true, CO2: true}.quantities(),
+	)
+	assert.Equal(t,
+		[]string{
+			"current_temperature", "current_humidity", "dew_point", "absolute_humidity", "humidex", "heat_index",
+			"current_pressure", "mixing_ratio", "gas_resistance", "iaq", "voc_density",
+		},
+		DiscoverySensor{Humidity: true, Pressure: true, AirQuality: true}.quantities(),
+	)
+
+	for _, key := range (DiscoverySensor{Humidity: true, Pressure: true, CO2: true, AirQuality: true}).quantities() {
+		assert.Contains(t, quantities, key)
+	}
+}
+
+func TestClient_Discovery(t *testing.T) {
+	broker, url := newBroker(t)
+
+	// entities of a removed sensor and of another hk instance
+	stale := "homeassistant/sensor/hk/garage_current_temperature/config"
+	other := "homeassistant/sensor/hk-attic/attic_current_temperature/config"
+	require.NoError(t, broker.Publish(stale, []byte(`{"name":"Temperature"}`), true, 1))
+	require.NoError(t, broker.Publish(other, []byte(`{"name":"Temperature"}`), true, 1))
+
+	c, err := New(url, WithDiscovery(Discovery{
+		Device: Device{Name: "Raspberry Pi5", Manufacturer: "Raspberry Pi", Model: "Model 5", Revision: "v1.2.3"},
+		Sensors: []DiscoverySensor{
+			{ID: "kitchen", Room: "Kitchen", Manufacturer: "bosch", Model: "BME280", Humidity: true},
+			{Room: "Home", Model: "DS18B20"},
+		},
+		USB2Power: "RPi5 usb2.0 power",
+	}))
+	require.NoError(t, err)
+	defer c.Close()
+
+	got := subscribe(t, broker, "homeassistant/#")
+	config := func(topic string) map[string]any {
+		var cfg map[string]any
+		require.Eventually(t, func() bool { return got.get(topic) != "" }, time.Second, 10*time.Millisecond, topic)
+		require.NoError(t, json.Unmarshal([]byte(got.get(topic)), &cfg))
+
+		return cfg
+	}
+
+	temperature := config("homeassistant/sensor/hk/kitchen_current_temperature/config")
+	assert.Equal(t, "Temperature", temperature["name"])
+	assert.Equal(t, "hk_kitchen_current_temperature", temperature["unique_id"])
+	assert.Equal(t, "hk/sensors/kitchen/current_temperature", temperature["state_topic"])
+	assert.Equal(t, "temperature", temperature["device_class"])
+	assert.Equal(t, "°C", temperature["unit_of_measurement"])
+	assert.Equal(t, "all", temperature["availability_mode"])
+	assert.Equal(t, []any{
+		map[string]any{"topic": "hk/status"},
+		map[string]any{"topic": "hk/sensors/kitchen/status"},
+	}, temperature["availability"])
+	assert.Equal(t, map[string]any{
+		"identifiers":  []any{"hk_kitchen"},
+		"name":         "Kitchen",
+		"manufacturer": "bosch",
+		"model":        "BME280",
+		"via_device":   "hk",
+	}, temperature["device"])
+
+	dewPoint := config("homeassistant/sensor/hk/kitchen_dew_point/config")
+	assert.Equal(t, "hk/sensors/kitchen/dew_point", dewPoint["state_topic"])
+
+	connectivity := config("homeassistant/binary_sensor/hk/default_status/config")
+	assert.Equal(t, "hk/sensors/default/status", connectivity["state_topic"])
+	assert.Equal(t, "connectivity", connectivity["device_class"])
+	assert.Equal(t, Online, connectivity["payload_on"])
+
+	power := config("homeassistant/switch/hk/usb2power/config")
+	assert.Equal(t, "hk/usb2power/set", power["command_topic"])
+	assert.Equal(t, "hk/usb2power/state", power["state_topic"])
+	assert.Equal(t, map[string]any{
+		"identifiers":  []any{"hk"},
+		"name":         "Raspberry Pi5",
+		"manufacturer": "Raspberry Pi",
+		"model":        "Model 5",
+		"sw_version":   "v1.2.3",
+	}, power["device"])
+
+	// the stale entity is removed, the other instance is left alone
+	assert.Eventually(t, func() bool { return len(broker.Topics.Messages(stale)) == 0 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, broker.Topics.Messages(other), 1)
+	// the sensor without humidity has no humidity and derived values
+	assert.Empty(t, got.get("homeassistant/sensor/hk/default_current_humidity/config"))
+	assert.Empty(t, got.get("homeassistant/sensor/hk/default_dew_point/config"))
+	assert.Len(t, c.configs, 6+1+1+1+1)
+}
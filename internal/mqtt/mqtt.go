@@ -0,0 +1,227 @@
+package mqtt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/egregors/hk/log"
+)
+
+const (
+	// DefaultPrefix is the root of all hk topics
+	DefaultPrefix = "hk"
+	// DefaultClientID is used if no client ID is set, it must be unique per broker
+	DefaultClientID = "hk"
+	// DefaultSensorID names the single sensor setup, which has an empty sensor ID, in topics
+	DefaultSensorID = "default"
+
+	// Online and Offline are payloads of the availability and sensor status topics
+	Online  = "online"
+	Offline = "offline"
+	// PowerOn and PowerOff are payloads of the USB power command and state topics
+	PowerOn  = "ON"
+	PowerOff = "OFF"
+
+	qos            = 1
+	connectTimeout = 10 * time.Second
+	// disconnectQuiesce is time in ms to finish in-flight messages on close
+	disconnectQuiesce = 250
+)
+
+type Option func(c *Client)
+
+// WithPrefix sets the root of all topics, DefaultPrefix if not set
+func WithPrefix(prefix string) Option {
+	return func(c *Client) {
+		c.prefix = strings.Trim(prefix, "/")
+	}
+}
+
+func WithClientID(id string) Option {
+	return func(c *Client) {
+		c.clientID = id
+	}
+}
+
+// WithDiscovery announces entities to Home Assistant on every connect
+func WithDiscovery(d Discovery) Option {
+	return func(c *Client) {
+		c.discovery = &d
+	}
+}
+
+func WithCredentials(username, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// Client publishes readings and sensor statuses as retained messages and takes USB power commands.
+//
+// Topics, with the default prefix:
+//
+//	hk/status                      online / offline, the last will of the client
+//	hk/sensors/<id>/<quantity>     the last reading, e.g. hk/sensors/kitchen/current_temperature
+//	hk/sensors/<id>/status         online / offline
+//	hk/usb2power/set               ON / OFF commands
+//	hk/usb2power/state             ON / OFF, the last known state
+type Client struct {
+	cli      paho.Client
+	prefix   string
+	clientID string
+	username string
+	password string
+
+	usb2power chan bool
+
+	discovery *Discovery
+	// configs are discovery payloads by config topics
+	configs map[string]string
+}
+
+// New connects to the broker, e.g. "tcp://localhost:1883".
+// The client reconnects by itself if the connection is lost later.
+func New(brokerURL string, opts ...Option) (*Client, error) {
+	c := &Client{
+		prefix:    DefaultPrefix,
+		clientID:  DefaultClientID,
+		usb2power: make(chan bool),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.discovery != nil {
+		if c.discovery.Prefix == "" {
+			c.discovery.Prefix = DefaultDiscoveryPrefix
+		}
+		if c.discovery.NodeID == "" {
+			c.discovery.NodeID = objectID(c.clientID)
+		}
+		c.configs = c.makeConfigs()
+	}
+
+	o := paho.NewClientOptions().
+		AddBroker(brokerURL).
+		SetClientID(c.clientID).
+		SetUsername(c.username).
+		SetPassword(c.password).
+		SetAutoReconnect(true).
+		SetWill(c.AvailabilityTopic(), Offline, qos, true).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Erro.Printf("lost connection to MQTT broker: %s", err.Error())
+		})
+
+	c.cli = paho.NewClient(o)
+
+	token := c.cli.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		return nil, fmt.Errorf("can't connect to MQTT broker %s: timeout", brokerURL)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("can't connect to MQTT broker %s: %w", brokerURL, err)
+	}
+
+	return c, nil
+}
+
+// onConnect announces the client and (re)subscribes to commands, the broker may have lost the session
+func (c *Client) onConnect(cli paho.Client) {
+	log.Info.Println("connected to MQTT broker")
+
+	c.publish(c.AvailabilityTopic(), Online)
+	logError(cli.Subscribe(c.USB2PowerCommandTopic(), qos, c.handleUSB2PowerCommand), "subscribe to "+c.USB2PowerCommandTopic())
+	if c.discovery != nil {
+		c.announce(cli)
+	}
+}
+
+func (c *Client) handleUSB2PowerCommand(_ paho.Client, msg paho.Message) {
+	payload := strings.TrimSpace(string(msg.Payload()))
+	log.Debg.Printf("got %q from %s", payload, msg.Topic())
+
+	switch strings.ToUpper(payload) {
+	case PowerOn:
+		c.usb2power <- true
+	case PowerOff:
+		c.usb2power <- false
+	default:
+		log.Erro.Printf("unknown USB power command %q, expected %s or %s", payload, PowerOn, PowerOff)
+	}
+}
+
+// AvailabilityTopic has the status of hk itself
+func (c *Client) AvailabilityTopic() string {
+	return c.prefix + "/status"
+}
+
+// SensorTopic returns the topic of a sensor quantity or "status"
+func (c *Client) SensorTopic(sensorID, quantity string) string {
+	if sensorID == "" {
+		sensorID = DefaultSensorID
+	}
+
+	return c.prefix + "/sensors/" + sensorID + "/" + quantity
+}
+
+func (c *Client) USB2PowerCommandTopic() string {
+	return c.prefix + "/usb2power/set"
+}
+
+func (c *Client) USB2PowerStateTopic() string {
+	return c.prefix + "/usb2power/state"
+}
+
+// PublishReading publishes the last reading of a sensor quantity
+func (c *Client) PublishReading(sensorID, quantity string, val float64) {
+	c.publish(c.SensorTopic(sensorID, quantity), strconv.FormatFloat(val, 'f', 2, 64))
+}
+
+// PublishStatus publishes online / offline status of a sensor
+func (c *Client) PublishStatus(sensorID, status string) {
+	c.publish(c.SensorTopic(sensorID, "status"), status)
+}
+
+// PublishUSB2Power publishes the state of USB power
+func (c *Client) PublishUSB2Power(on bool) {
+	state := PowerOff
+	if on {
+		state = PowerOn
+	}
+
+	c.publish(c.USB2PowerStateTopic(), state)
+}
+
+// USB2PowerChan gets USB power commands, true to turn the power on
+func (c *Client) USB2PowerChan() chan bool {
+	return c.usb2power
+}
+
+// Close marks hk offline and disconnects
+func (c *Client) Close() {
+	token := c.cli.Publish(c.AvailabilityTopic(), qos, true, Offline)
+	token.WaitTimeout(connectTimeout)
+	c.cli.Disconnect(disconnectQuiesce)
+}
+
+// publish sends a retained message without waiting for the broker, errors are only logged
+func (c *Client) publish(topic, payload string) {
+	logError(c.cli.Publish(topic, qos, true, payload), "publish to "+topic)
+}
+
+// logError logs the token error when it's done, what is the action for the log message
+func logError(token paho.Token, what string) {
+	go func() {
+		<-token.Done()
+		if err := token.Error(); err != nil {
+			log.Erro.Printf("can't %s: %s", what, err.Error())
+		}
+	}()
+}
@@ -0,0 +1,113 @@
+package mqtt
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBroker runs an in-process broker, the inline client of it can publish and subscribe
+func newBroker(t *testing.T) (broker *mochi.Server, url string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	broker = mochi.New(&mochi.Options{InlineClient: true})
+	require.NoError(t, broker.AddHook(new(auth.AllowHook), nil))
+	require.NoError(t, broker.AddListener(listeners.NewNet("test", l)))
+	require.NoError(t, broker.Serve())
+	t.Cleanup(func() { _ = broker.Close() })
+
+	return broker, "tcp://" + l.Addr().String()
+}
+
+// retained collects the last payloads by topic
+type retained struct {
+	mu       sync.Mutex
+	payloads map[string]string
+}
+
+func subscribe(t *testing.T, broker *mochi.Server, filter string) *retained {
+	t.Helper()
+
+	r := &retained{payloads: make(map[string]string)}
+	err := broker.Subscribe(filter, 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.payloads[pk.TopicName] = string(pk.Payload)
+	})
+	require.NoError(t, err)
+
+	return r
+}
+
+func (r *retained) get(topic string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.payloads[topic]
+}
+
+func TestClient_Publish(t *testing.T) {
+	broker, url := newBroker(t)
+
+	c, err := New(url, WithPrefix("home/hk/"), WithClientID("hk-test"))
+	require.NoError(t, err)
+
+	c.PublishReading("kitchen", "current_temperature", 21.456)
+	c.PublishReading("", "current_humidity", 45)
+	c.PublishStatus("kitchen", Online)
+	c.PublishUSB2Power(false)
+
+	// retained messages are delivered to late subscribers
+	got := subscribe(t, broker, "home/hk/#")
+	want := map[string]string{
+		"home/hk/status": Online,
+		"home/hk/sensors/kitchen/current_temperature": "21.46",
+		"home/hk/sensors/default/current_humidity":    "45.00",
+		"home/hk/sensors/kitchen/status":              Online,
+		"home/hk/usb2power/state":                     PowerOff,
+	}
+	for topic, payload := range want {
+		assert.Eventually(t, func() bool { return got.get(topic) == payload }, time.Second, 10*time.Millisecond, topic)
+	}
+
+	c.Close()
+	assert.Eventually(t, func() bool { return got.get("home/hk/status") == Offline }, time.Second, 10*time.Millisecond)
+}
+
+func TestClient_USB2PowerCommands(t *testing.T) {
+	broker, url := newBroker(t)
+
+	c, err := New(url)
+	require.NoError(t, err)
+	defer c.Close()
+
+	// wait for the subscription
+	require.Eventually(t, func() bool {
+		return len(broker.Topics.Subscribers(c.USB2PowerCommandTopic()).Subscriptions) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// unknown commands are ignored
+	for _, payload := range []string{"ON", "bogus", "off", " on\n"} {
+		require.NoError(t, broker.Publish("hk/usb2power/set", []byte(payload), false, 1))
+	}
+
+	for _, want := range []bool{true, false, true} {
+		select {
+		case on := <-c.USB2PowerChan():
+			assert.Equal(t, want, on)
+		case <-time.After(time.Second):
+			t.Fatal("no USB power command")
+		}
+	}
+}
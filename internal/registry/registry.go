@@ -0,0 +1,58 @@
+// Package registry keeps drivers by name, e.g. sensor and power drivers
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry keeps drivers of a kind, e.g. "sensor", by name
+type Registry[T any] struct {
+	kind string
+
+	mu      sync.RWMutex
+	drivers map[string]T
+}
+
+func New[T any](kind string) *Registry[T] {
+	return &Registry[T]{kind: kind, drivers: make(map[string]T)}
+}
+
+// Register makes a driver available by name, it panics if the name is taken
+func (r *Registry[T]) Register(name string, d T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.drivers[name]; ok {
+		panic(fmt.Sprintf("%s driver %q is already registered", r.kind, name))
+	}
+	r.drivers[name] = d
+}
+
+// Names returns names of registered drivers
+func (r *Registry[T]) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.drivers))
+	for name := range r.drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Get returns the driver by name
+func (r *Registry[T]) Get(name string) (T, error) {
+	r.mu.RLock()
+	d, ok := r.drivers[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return d, fmt.Errorf("unknown %s driver %q, available: %v", r.kind, name, r.Names())
+	}
+
+	return d, nil
+}
@@ -0,0 +1,23 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := New[int]("test")
+	r.Register("b", 2)
+	r.Register("a", 1)
+	assert.Equal(t, []string{"a", "b"}, r.Names())
+
+	d, err := r.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, 2, d)
+
+	_, err = r.Get("c")
+	assert.EqualError(t, err, `unknown test driver "c", available: [a b]`)
+	assert.PanicsWithValue(t, `test driver "a" is already registered`, func() { r.Register("a", 3) })
+}
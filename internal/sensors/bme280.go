@@ -0,0 +1,165 @@
+package sensors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+// device is a single opened Bosch chip
+type device interface {
+	chip() string
+	// chipID returns the chip ID register value
+	chipID() byte
+	// checkCalibration validates calibration coefficients read on open
+	checkCalibration() error
+	config() Settings
+	// measure returns t in °C, p in hPa and h in %RH of a single measurement, NaN if not supported
+	measure() (t, p, h float64, err error)
+	close() error
+}
+
+// BME280 is a sensor for temperature, humidity and pressure using BMx sensor.
+// Actual chip (BME280, BMP280, BMP180 or BMP388) is detected on creation.
+type BME280 struct {
+	// mu guards dev, a read which timed out keeps running and can reopen it while the chip is described
+	mu   sync.RWMutex
+	dev  device
+	open func(addr uint8) (device, error)
+	bus  int
+	addr uint8
+
+	rec   *recovery
+	reads *reader
+}
+
+// newBME280 probes every configured address with open and uses the first found device
+func newBME280(o Opts, open func(addr uint8) (device, error)) (*BME280, error) {
+	var errs []error
+	for _, addr := range o.addrs() {
+		dev, err := open(addr)
+		if err != nil {
+			log.Debg.Printf("no BMx sensor at bus %d addr 0x%x: %s", o.Bus, addr, err.Error())
+			errs = append(errs, fmt.Errorf("addr 0x%x: %w", addr, err))
+
+			continue
+		}
+
+		log.Info.Printf("found %s sensor at bus %d addr 0x%x, %s", dev.chip(), o.Bus, addr, dev.config())
+
+		return &BME280{dev: dev, open: open, bus: o.Bus, addr: addr, rec: newRecovery(), reads: newReader()}, nil
+	}
+
+	// use util: 'i2cdetect -y <bus>' to find out what is actually connected
+	return nil, fmt.Errorf("can't find BMx sensor on i2c bus %d: %w", o.Bus, errors.Join(errs...))
+}
+
+// reopen closes the bus connection and makes a new one, calibration coefficients are read again
+func (b *BME280) reopen() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log.Info.Printf("reopen %s sensor at bus %d addr 0x%x", b.dev.chip(), b.bus, b.addr)
+
+	if err := b.dev.close(); err != nil {
+		log.Erro.Printf("can't close i2c connection: %s", err.Error())
+	}
+
+	dev, err := b.open(b.addr)
+	if err != nil {
+		return err
+	}
+	b.dev = dev
+
+	return nil
+}
+
+// read calls fn and, after repeated I/O failures, tries to reopen the sensor and calls fn again
+func (b *BME280) read(fn func() error) error {
+	err := fn()
+	if err == nil {
+		b.rec.success()
+		return nil
+	}
+
+	if !b.rec.failure(err) {
+		return err
+	}
+
+	rerr := b.reopen()
+	b.rec.attempted(rerr)
+	if rerr != nil {
+		log.Erro.Printf("sensor recovery: %s", b.rec.Status())
+		return fmt.Errorf("%w (recovery failed: %s)", err, rerr.Error())
+	}
+	log.Info.Printf("sensor recovery: %s", b.rec.Status())
+
+	err = fn()
+	if err == nil {
+		b.rec.success()
+		log.Info.Printf("sensor recovery: %s", b.rec.Status())
+	}
+
+	return err
+}
+
+// RecoveryStatus returns the state of the last bus recovery, empty if there was none
+func (b *BME280) RecoveryStatus() string {
+	return b.rec.Status()
+}
+
+// Chip returns the name of detected chip
+func (b *BME280) Chip() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.dev.chip()
+}
+
+// Settings describes the chip and its measurement settings
+func (b *BME280) Settings() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.dev.chip() + " " + b.dev.config().String()
+}
+
+// Info describes the chip, its connection and settings
+func (b *BME280) Info() Info {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return Info{
+		Chip:        b.dev.chip(),
+		ID:          fmt.Sprintf("0x%x", b.dev.chipID()),
+		Addr:        i2cAddr(b.bus, b.addr),
+		Calibration: b.dev.checkCalibration(),
+		Settings:    b.dev.config().String(),
+	}
+}
+
+// Read runs a single forced mode measurement of all quantities the chip has
+func (b *BME280) Read(ctx context.Context) (Reading, error) {
+	return b.reads.read(ctx, func() (Reading, error) {
+		var t, p, h float64
+		err := b.read(func() (err error) {
+			b.mu.RLock()
+			defer b.mu.RUnlock()
+
+			t, p, h, err = b.dev.measure()
+			return err
+		})
+		if err != nil {
+			return Reading{}, err
+		}
+
+		rd := NewReading(time.Now())
+		rd.Temperature, rd.Pressure, rd.Humidity = t, p, h
+
+		return rd, nil
+	})
+}
@@ -0,0 +1,258 @@
+package sensors
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"sync"
+)
+
+func init() {
+	Register("bme280-emu", func(spec Spec) (Climate, error) {
+		emu := NewEmulatedBME280(DefaultDiurnal)
+		o := makeOpts(spec.Opts()...).withEmulator()
+		return newBME280(o, func(uint8) (device, error) {
+			return newBMx280(emu.connect(), o.Settings)
+		})
+	})
+}
+
+// withEmulator drops real bus addresses probing, emulated chip answers on any address
+func (o Opts) withEmulator() Opts {
+	if o.Addr == AddrAuto {
+		o.Addr = ProbeAddrs[0]
+	}
+
+	return o
+}
+
+// emuCalib is the sample calibration from the BME280 datasheet and a typical humidity one
+var emuCalib = bmx280Calib{
+	T1: 27504, T2: 26435, T3: -1000,
+	P1: 36477, P2: -10685, P3: 3024, P4: 2855, P5: 140, P6: -7, P7: 15500, P8: -14600, P9: 6000,
+	H1: 75, H2: 370, H3: 0, H4: 309, H5: 50, H6: 30,
+}
+
+// EmulatedBME280 is a register level BME280 emulator, it implements Bus,
+// so the real driver with its compensation math can run without hardware.
+// Physical values come from a Simulated sensor, its faults become bus errors.
+type EmulatedBME280 struct {
+	sim *Simulated
+
+	mu     sync.Mutex
+	regs   [256]byte
+	hum    byte // ctrl_hum latched by ctrl_meas write
+	busy   int
+	imBusy int
+	closed bool
+	fail   error
+
+	// BusyPolls is how many status reads return "measuring" after a measurement is started
+	BusyPolls int
+}
+
+// NewEmulatedBME280 makes an emulated chip in sleep mode with datasheet calibration
+func NewEmulatedBME280(source Source, opts ...SimOption) *EmulatedBME280 {
+	e := &EmulatedBME280{sim: NewSimulated(source, opts...), BusyPolls: 1}
+	e.regs[bmx280RegID] = bmx280ChipBME280
+	e.writeCalibration(emuCalib)
+	e.reset()
+
+	return e
+}
+
+// Fail makes every following bus operation return err, nil clears the failure
+func (e *EmulatedBME280) Fail(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.fail = err
+}
+
+// connect opens the emulated device again after Close
+func (e *EmulatedBME280) connect() *EmulatedBME280 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = false
+
+	return e
+}
+
+func (e *EmulatedBME280) writeCalibration(c bmx280Calib) {
+	le := binary.LittleEndian
+	for i, v := range []uint16{
+		c.T1, uint16(c.T2), uint16(c.T3),
+		c.P1, uint16(c.P2), uint16(c.P3), uint16(c.P4), uint16(c.P5),
+		uint16(c.P6), uint16(c.P7), uint16(c.P8), uint16(c.P9),
+	} {
+		le.PutUint16(e.regs[bmx280RegCalib00+2*i:], v)
+	}
+
+	e.regs[bmx280RegH1] = c.H1
+	le.PutUint16(e.regs[bmx280RegCalib26:], uint16(c.H2))
+	e.regs[bmx280RegCalib26+2] = c.H3
+	e.regs[bmx280RegCalib26+3] = byte(c.H4 >> 4)
+	e.regs[bmx280RegCalib26+4] = byte(c.H4&0x0F) | byte(c.H5&0x0F)<<4
+	e.regs[bmx280RegCalib26+5] = byte(c.H5 >> 4)
+	e.regs[bmx280RegCalib26+6] = byte(c.H6)
+}
+
+// reset sets control registers and data to power-on values
+func (e *EmulatedBME280) reset() {
+	e.regs[bmx280RegCtrlHum] = 0
+	e.regs[bmx280RegCtrlMeas] = 0
+	e.regs[bmx280RegConfig] = 0
+	e.hum = 0
+	e.busy = 0
+	e.imBusy = 1
+	copy(e.regs[bmx280RegData:], []byte{0x80, 0, 0, 0x80, 0, 0, 0x80, 0})
+}
+
+func (e *EmulatedBME280) check() error {
+	if e.closed {
+		return &fs.PathError{Op: "read", Path: "emulated-bme280", Err: fs.ErrClosed}
+	}
+
+	return e.fail
+}
+
+func (e *EmulatedBME280) ReadReg(reg byte, buf []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.check(); err != nil {
+		return err
+	}
+
+	switch {
+	case reg == bmx280RegStatus:
+		var status byte
+		if e.busy > 0 {
+			e.busy--
+			status |= bmx280StatusMeasure
+		}
+		if e.imBusy > 0 {
+			e.imBusy--
+			status |= bmx280StatusIMUpdate
+		}
+		e.regs[bmx280RegStatus] = status
+	case reg >= bmx280RegData && e.regs[bmx280RegCtrlMeas]&0b11 == 0b11:
+		// normal mode measures all the time
+		if err := e.measure(); err != nil {
+			return err
+		}
+	}
+
+	for i := range buf {
+		buf[i] = e.regs[(int(reg)+i)&0xFF]
+	}
+
+	return nil
+}
+
+func (e *EmulatedBME280) WriteReg(reg, val byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.check(); err != nil {
+		return err
+	}
+
+	switch reg {
+	case bmx280RegReset:
+		if val == bmx280ResetCmd {
+			e.reset()
+		}
+	case bmx280RegCtrlHum:
+		e.regs[reg] = val & 0b111
+	case bmx280RegConfig:
+		e.regs[reg] = val
+	case bmx280RegCtrlMeas:
+		e.regs[reg] = val
+		e.hum = e.regs[bmx280RegCtrlHum]
+		if val&0b11 == bmx280ModeForced || val&0b11 == 0b10 {
+			if err := e.measure(); err != nil {
+				return err
+			}
+			e.busy = e.BusyPolls
+			// back to sleep mode after forced measurement
+			e.regs[reg] &^= 0b11
+		}
+	}
+	// other registers are read-only, writes are ignored like the real chip does
+
+	return nil
+}
+
+func (e *EmulatedBME280) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+
+	return nil
+}
+
+// measure takes physical values from the simulated sensor and puts raw ADC values
+// to data registers according to configured oversampling
+func (e *EmulatedBME280) measure() error {
+	rd, err := e.sim.read()
+	if err != nil {
+		return err
+	}
+
+	osrsT := Oversampling(e.regs[bmx280RegCtrlMeas] >> 5)
+	osrsP := Oversampling(e.regs[bmx280RegCtrlMeas] >> 2 & 0b111)
+	osrsH := Oversampling(e.hum)
+
+	c := &emuCalib
+	adcT := emuInvert(1<<20, func(adc int32) float64 {
+		_, t := c.compensateT(adc)
+		return float64(t) / 100
+	}, rd.Temperature)
+	tFine, _ := c.compensateT(adcT)
+	adcP := emuInvert(1<<20, func(adc int32) float64 {
+		// pressure decreases with ADC value
+		return -float64(c.compensateP(adc, tFine)) / 256 / 100
+	}, -rd.Pressure)
+	adcH := emuInvert(1<<16, func(adc int32) float64 {
+		return float64(c.compensateH(adc, tFine)) / 1024
+	}, rd.Humidity)
+
+	putADC20(e.regs[bmx280RegData:], emuResolution(adcP, osrsP, 20))
+	putADC20(e.regs[bmx280RegData+3:], emuResolution(adcT, osrsT, 20))
+	adcH = emuResolution(adcH, osrsH, 16)
+	e.regs[bmx280RegData+6], e.regs[bmx280RegData+7] = byte(adcH>>8), byte(adcH)
+
+	return nil
+}
+
+// emuResolution drops low bits not measured with given oversampling,
+// skipped measurement gives the reset value
+func emuResolution(adc int32, osrs Oversampling, bits int) int32 {
+	if osrs == OversamplingSkip {
+		return 1 << (bits - 1)
+	}
+	drop := int(Oversampling16x - min(osrs, Oversampling16x))
+
+	return adc >> drop << drop
+}
+
+func putADC20(b []byte, adc int32) {
+	b[0], b[1], b[2] = byte(adc>>12), byte(adc>>4), byte(adc<<4)
+}
+
+// emuInvert finds ADC value in [0, n) giving v with increasing fn
+func emuInvert(n int32, fn func(adc int32) float64, v float64) int32 {
+	lo, hi := int32(0), n-1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if fn(mid) < v {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo
+}
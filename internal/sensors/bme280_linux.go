@@ -0,0 +1,167 @@
+//go:build linux
+
+package sensors
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/d2r2/go-bsbmp"
+	"github.com/d2r2/go-i2c"
+
+	"github.com/egregors/hk/log"
+)
+
+func init() {
+	Register("bme280", func(spec Spec) (Climate, error) {
+		return NewBME280(spec.Opts()...)
+	})
+}
+
+// legacyChips are Bosch sensors read with bsbmp, in detection order.
+// BME280 and BMP280 are handled by the own bmx280 driver.
+var legacyChips = []bsbmp.SensorType{bsbmp.BMP180, bsbmp.BMP388}
+
+func NewBME280(opts ...Option) (*BME280, error) {
+	log.Info.Println("make BME280 sensor")
+
+	o := makeOpts(opts...)
+
+	return newBME280(o, func(addr uint8) (device, error) {
+		return openBMx(o.Bus, addr, o.Settings)
+	})
+}
+
+func openBMx(bus int, addr uint8, settings Settings) (device, error) {
+	dev, err := openI2CDev(bus, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := newBMx280(dev, settings)
+	if err == nil {
+		return d, nil
+	}
+	if !errors.Is(err, errUnknownChip) {
+		_ = dev.Close()
+		return nil, err
+	}
+
+	legacy, lerr := detectLegacyChip(dev.conn)
+	if lerr != nil {
+		_ = dev.Close()
+		return nil, errors.Join(err, lerr)
+	}
+	if err := legacy.configure(settings); err != nil {
+		_ = dev.Close()
+		return nil, err
+	}
+
+	return legacy, nil
+}
+
+// detectLegacyChip tries every chip type supported by bsbmp, it reads sensor ID
+// and checks its signature, so only the right type will succeed
+func detectLegacyChip(conn *i2c.I2C) (*bsbmpDevice, error) {
+	var errs []error
+	for _, chip := range legacyChips {
+		sensor, err := bsbmp.NewBMP(chip, conn)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", chip, err))
+			continue
+		}
+
+		if err := sensor.IsValidCoefficients(); err != nil {
+			return nil, fmt.Errorf("invalid calibration coefficients: %w", err)
+		}
+
+		id, err := sensor.ReadSensorID()
+		if err != nil {
+			return nil, fmt.Errorf("can't read chip id: %w", err)
+		}
+
+		return &bsbmpDevice{conn: conn, sensor: sensor, typ: chip, id: id}, nil
+	}
+
+	return nil, fmt.Errorf("unknown chip: %w", errors.Join(errs...))
+}
+
+// bsbmpDevice is a BMP180 or BMP388 read with bsbmp
+type bsbmpDevice struct {
+	conn   *i2c.I2C
+	sensor *bsbmp.BMP
+	typ    bsbmp.SensorType
+	id     byte
+
+	settings Settings
+}
+
+func (d *bsbmpDevice) chip() string {
+	return d.typ.String()
+}
+
+func (d *bsbmpDevice) chipID() byte {
+	return d.id
+}
+
+func (d *bsbmpDevice) checkCalibration() error {
+	if err := d.sensor.IsValidCoefficients(); err != nil {
+		return fmt.Errorf("invalid calibration coefficients: %w", err)
+	}
+
+	return nil
+}
+
+// configure checks settings, bsbmp runs forced measurements with oversampling only
+func (d *bsbmpDevice) configure(s Settings) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	if s.Mode != ModeForced || s.Filter != FilterOff {
+		return fmt.Errorf("%s supports forced mode without filter only", d.chip())
+	}
+	if s.OsrsP == OversamplingSkip {
+		return fmt.Errorf("%s can't skip pressure", d.chip())
+	}
+	d.settings = s
+
+	return nil
+}
+
+func (d *bsbmpDevice) config() Settings {
+	return d.settings
+}
+
+// accuracy is bsbmp accuracy mode of the oversampling: ×1 is ACCURACY_ULTRA_LOW ... ×16 is ACCURACY_ULTRA_HIGH
+func accuracy(o Oversampling) bsbmp.AccuracyMode {
+	return bsbmp.AccuracyMode(o - Oversampling1x)
+}
+
+// measure reads all quantities, bsbmp runs a separate conversion for each of them
+func (d *bsbmpDevice) measure() (t, p, h float64, err error) {
+	tc, err := d.sensor.ReadTemperatureC(accuracy(d.settings.OsrsT))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("can't read temperature: %w", err)
+	}
+
+	pa, err := d.sensor.ReadPressurePa(accuracy(d.settings.OsrsP))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("can't read pressure: %w", err)
+	}
+
+	h = math.NaN()
+	supported, rh, err := d.sensor.ReadHumidityRH(accuracy(max(d.settings.OsrsH, Oversampling1x)))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("can't read humidity: %w", err)
+	}
+	if supported {
+		h = float64(rh)
+	}
+
+	return float64(tc), float64(pa) / 100, h, nil
+}
+
+func (d *bsbmpDevice) close() error {
+	return d.conn.Close()
+}
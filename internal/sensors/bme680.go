@@ -0,0 +1,442 @@
+package sensors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+// BME680 registers, see Bosch BST-BME680-DS001 datasheet
+const (
+	bme680RegResHeatVal   = 0x00
+	bme680RegResHeatRange = 0x02
+	bme680RegRangeSwErr   = 0x04
+	bme680RegField0       = 0x1D
+	bme680RegResHeat0     = 0x5A
+	bme680RegGasWait0     = 0x64
+	bme680RegCtrlGas0     = 0x70
+	bme680RegCtrlGas1     = 0x71
+	bme680RegCtrlHum      = 0x72
+	bme680RegCtrlMeas     = 0x74
+	bme680RegConfig       = 0x75
+	bme680RegCoeff1       = 0x89
+	bme680RegID           = 0xD0
+	bme680RegReset        = 0xE0
+	bme680RegCoeff2       = 0xE1
+
+	bme680ChipID      = 0x61
+	bme680Coeff1Len   = 25
+	bme680Coeff2Len   = 16
+	bme680Field0Len   = 15
+	bme680RunGas      = 0x10
+	bme680NewData     = 0x80
+	bme680GasValid    = 0x20
+	bme680HeatStab    = 0x10
+	bme680MaxHeatTemp = 400
+	bme680MaxGasWait  = 4032 * time.Millisecond
+)
+
+// bme680GasLookup are gas resistance ranges constants from the datasheet (table 16)
+var (
+	bme680GasLookup1 = [16]int64{
+		2147483647, 2147483647, 2147483647, 2147483647, 2147483647, 2126008810, 2147483647, 2130303777,
+		2147483647, 2147483647, 2143188679, 2136746228, 2147483647, 2126008810, 2147483647, 2147483647,
+	}
+	bme680GasLookup2 = [16]int64{
+		4096000000, 2048000000, 1024000000, 512000000, 255744255, 127110228, 64000000, 32258064,
+		16016016, 8000000, 4000000, 2000000, 1000000, 500000, 250000, 125000,
+	}
+)
+
+// HeaterProfile is the gas sensor hot plate target temperature and heating duration
+type HeaterProfile struct {
+	// Temp is target temperature in °C, 200-400 is recommended
+	Temp int
+	// Duration is heating time before the gas measurement, up to 4032ms
+	Duration time.Duration
+}
+
+// DefaultHeaterProfile is the one recommended by Bosch for indoor air quality
+var DefaultHeaterProfile = HeaterProfile{Temp: 320, Duration: 150 * time.Millisecond}
+
+// ParseHeaterProfile parses "temp:duration", e.g. "320:150ms"
+func ParseHeaterProfile(s string) (HeaterProfile, error) {
+	rawTemp, rawDur, ok := strings.Cut(s, ":")
+	if !ok {
+		return HeaterProfile{}, fmt.Errorf("invalid heater profile %q, want temp:duration", s)
+	}
+
+	temp, err := strconv.Atoi(rawTemp)
+	if err != nil {
+		return HeaterProfile{}, fmt.Errorf("invalid heater temperature: %w", err)
+	}
+
+	dur, err := time.ParseDuration(rawDur)
+	if err != nil {
+		return HeaterProfile{}, fmt.Errorf("invalid heater duration: %w", err)
+	}
+
+	h := HeaterProfile{Temp: temp, Duration: dur}
+
+	return h, h.validate()
+}
+
+func (h HeaterProfile) validate() error {
+	if h.Temp <= 0 || h.Temp > bme680MaxHeatTemp {
+		return fmt.Errorf("heater temperature %d °C is out of range (0, %d]", h.Temp, bme680MaxHeatTemp)
+	}
+	if h.Duration <= 0 || h.Duration > bme680MaxGasWait {
+		return fmt.Errorf("heater duration %s is out of range (0, %s]", h.Duration, bme680MaxGasWait)
+	}
+
+	return nil
+}
+
+// bme680Calib are trimming parameters stored in the chip NVM
+type bme680Calib struct {
+	T1     uint16
+	T2     int16
+	T3     int8
+	P1     uint16
+	P2     int16
+	P3     int8
+	P4, P5 int16
+	P6, P7 int8
+	P8, P9 int16
+	P10    uint8
+	H1, H2 uint16
+	H3, H4 int8
+	H5     int8
+	H6     uint8
+	H7     int8
+	GH1    int8
+	GH2    int16
+	GH3    int8
+
+	ResHeatRange uint8
+	ResHeatVal   int8
+	RangeSwErr   int8
+}
+
+// bme680 is a driver of Bosch BME680 gas, temperature, humidity and pressure sensor
+type bme680 struct {
+	bus    Bus
+	calib  bme680Calib
+	heater HeaterProfile
+
+	osrsT, osrsP, osrsH Oversampling
+
+	sleep func(time.Duration)
+}
+
+// bme680Reading is a single compensated measurement, gas resistance is in Ω, 0 if it's not valid
+type bme680Reading struct {
+	t, p, h, gas float64
+}
+
+// newBME680Dev checks the chip ID, resets the chip, reads calibration and sets the heater profile
+func newBME680Dev(bus Bus, heater HeaterProfile, sleep func(time.Duration)) (*bme680, error) {
+	if err := heater.validate(); err != nil {
+		return nil, err
+	}
+
+	d := &bme680{
+		bus:    bus,
+		heater: heater,
+		osrsT:  Oversampling8x,
+		osrsP:  Oversampling4x,
+		osrsH:  Oversampling2x,
+		sleep:  sleep,
+	}
+
+	id := make([]byte, 1)
+	if err := bus.ReadReg(bme680RegID, id); err != nil {
+		return nil, fmt.Errorf("can't read chip id: %w", err)
+	}
+	if id[0] != bme680ChipID {
+		return nil, fmt.Errorf("%w: id 0x%x", errUnknownChip, id[0])
+	}
+
+	if err := bus.WriteReg(bme680RegReset, bmx280ResetCmd); err != nil {
+		return nil, fmt.Errorf("can't reset: %w", err)
+	}
+	d.sleep(10 * time.Millisecond)
+
+	if err := d.readCalibration(); err != nil {
+		return nil, err
+	}
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// validate checks calibration isn't empty, e.g. when it was read from a dead bus
+func (d *bme680) validate() error {
+	if d.calib.T1 == 0 || d.calib.P1 == 0 {
+		return errors.New("invalid calibration: empty T1 or P1")
+	}
+
+	return nil
+}
+
+func (d *bme680) readCalibration() error {
+	coeff := make([]byte, bme680Coeff1Len+bme680Coeff2Len)
+	if err := d.bus.ReadReg(bme680RegCoeff1, coeff[:bme680Coeff1Len]); err != nil {
+		return fmt.Errorf("can't read calibration: %w", err)
+	}
+	if err := d.bus.ReadReg(bme680RegCoeff2, coeff[bme680Coeff1Len:]); err != nil {
+		return fmt.Errorf("can't read calibration: %w", err)
+	}
+
+	heat := make([]byte, 5)
+	if err := d.bus.ReadReg(bme680RegResHeatVal, heat); err != nil {
+		return fmt.Errorf("can't read heater calibration: %w", err)
+	}
+
+	u16 := func(msb, lsb int) uint16 { return uint16(coeff[msb])<<8 | uint16(coeff[lsb]) }
+	s16 := func(msb, lsb int) int16 { return int16(u16(msb, lsb)) }
+
+	d.calib = bme680Calib{
+		T1: u16(34, 33), T2: s16(2, 1), T3: int8(coeff[3]),
+		P1: u16(6, 5), P2: s16(8, 7), P3: int8(coeff[9]), P4: s16(12, 11), P5: s16(14, 13),
+		P6: int8(coeff[16]), P7: int8(coeff[15]), P8: s16(20, 19), P9: s16(22, 21), P10: coeff[23],
+		// H1 and H2 are 12 bit values sharing 0xE2
+		H1: uint16(coeff[27])<<4 | uint16(coeff[26]&0x0F),
+		H2: uint16(coeff[25])<<4 | uint16(coeff[26]>>4),
+		H3: int8(coeff[28]), H4: int8(coeff[29]), H5: int8(coeff[30]), H6: coeff[31], H7: int8(coeff[32]),
+		GH1: int8(coeff[37]), GH2: s16(36, 35), GH3: int8(coeff[38]),
+
+		ResHeatVal:   int8(heat[bme680RegResHeatVal]),
+		ResHeatRange: heat[bme680RegResHeatRange] & 0x30 >> 4,
+		RangeSwErr:   int8(heat[bme680RegRangeSwErr]&0xF0) / 16,
+	}
+
+	return nil
+}
+
+// measureTime is TPH measurement duration plus heating time
+func (d *bme680) measureTime() time.Duration {
+	cycles := d.osrsT.samples() + d.osrsP.samples() + d.osrsH.samples()
+	us := cycles*1963 + 477*4 + 477*5 + 500
+
+	return time.Duration(us)*time.Microsecond + time.Millisecond + d.heater.Duration
+}
+
+// measure runs a single forced mode measurement with gas heater profile 0
+func (d *bme680) measure(ambient float64) (bme680Reading, error) {
+	for _, w := range [][2]byte{
+		{bme680RegResHeat0, d.calib.heaterResistance(d.heater.Temp, ambient)},
+		{bme680RegGasWait0, gasWait(d.heater.Duration)},
+		{bme680RegCtrlGas1, bme680RunGas},
+		{bme680RegCtrlHum, byte(d.osrsH)},
+		{bme680RegCtrlMeas, byte(d.osrsT)<<5 | byte(d.osrsP)<<2 | bmx280ModeForced},
+	} {
+		if err := d.bus.WriteReg(w[0], w[1]); err != nil {
+			return bme680Reading{}, fmt.Errorf("can't write 0x%x: %w", w[0], err)
+		}
+	}
+
+	d.sleep(d.measureTime())
+
+	buf := make([]byte, bme680Field0Len)
+	for i := 0; ; i++ {
+		if err := d.bus.ReadReg(bme680RegField0, buf); err != nil {
+			return bme680Reading{}, fmt.Errorf("can't read data: %w", err)
+		}
+		if buf[0]&bme680NewData != 0 {
+			break
+		}
+		if i == 10 {
+			return bme680Reading{}, errors.New("measurement timeout")
+		}
+		d.sleep(10 * time.Millisecond)
+	}
+
+	adcP := int32(buf[2])<<12 | int32(buf[3])<<4 | int32(buf[4])>>4
+	adcT := int32(buf[5])<<12 | int32(buf[6])<<4 | int32(buf[7])>>4
+	adcH := int32(buf[8])<<8 | int32(buf[9])
+	adcG := uint16(buf[13])<<2 | uint16(buf[14])>>6
+	gasRange := buf[14] & 0x0F
+
+	tFine, t := d.calib.compensateT(adcT)
+	rd := bme680Reading{
+		t: float64(t) / 100,
+		p: float64(d.calib.compensateP(adcP, tFine)) / 100,
+		h: float64(d.calib.compensateH(adcH, tFine)) / 1000,
+	}
+
+	// the gas measurement is invalid if the heater didn't reach the target temperature in time
+	if buf[14]&bme680GasValid != 0 && buf[14]&bme680HeatStab != 0 {
+		rd.gas = float64(d.calib.compensateGas(adcG, gasRange))
+	}
+
+	return rd, nil
+}
+
+// compensateT returns t_fine and temperature in 0.01 °C
+func (c *bme680Calib) compensateT(adcT int32) (tFine, t int32) {
+	var1 := adcT>>3 - int32(c.T1)<<1
+	var2 := (var1 * int32(c.T2)) >> 11
+	var3 := ((var1 >> 1) * (var1 >> 1)) >> 12
+	var3 = (var3 * (int32(c.T3) << 4)) >> 14
+	tFine = var2 + var3
+
+	return tFine, (tFine*5 + 128) >> 8
+}
+
+// compensateP returns pressure in Pa
+func (c *bme680Calib) compensateP(adcP, tFine int32) int32 {
+	var1 := tFine>>1 - 64000
+	var2 := ((((var1 >> 2) * (var1 >> 2)) >> 11) * int32(c.P6)) >> 2
+	var2 += (var1 * int32(c.P5)) << 1
+	var2 = var2>>2 + int32(c.P4)<<16
+	var1 = ((((var1>>2)*(var1>>2))>>13)*(int32(c.P3)<<5))>>3 + (int32(c.P2)*var1)>>1
+	var1 >>= 18
+	var1 = ((32768 + var1) * int32(c.P1)) >> 15
+	if var1 == 0 {
+		// avoid division by zero
+		return 0
+	}
+
+	p := int64(1048576-adcP-var2>>12) * 3125
+	if p >= 1<<30 {
+		p = (p / int64(var1)) << 1
+	} else {
+		p = (p << 1) / int64(var1)
+	}
+
+	comp := int32(p)
+	var1 = (int32(c.P9) * (((comp >> 3) * (comp >> 3)) >> 13)) >> 12
+	var2 = ((comp >> 2) * int32(c.P8)) >> 13
+	var3 := ((comp >> 8) * (comp >> 8) * (comp >> 8) * int32(c.P10)) >> 17
+
+	return comp + (var1+var2+var3+int32(c.P7)<<7)>>4
+}
+
+// compensateH returns humidity in 0.001 %RH
+func (c *bme680Calib) compensateH(adcH, tFine int32) int32 {
+	temp := (tFine*5 + 128) >> 8
+	var1 := adcH - int32(c.H1)*16 - ((temp*int32(c.H3))/100)>>1
+	var2 := (int32(c.H2) * ((temp*int32(c.H4))/100 + ((temp*((temp*int32(c.H5))/100))>>6)/100 + 1<<14)) >> 10
+	var3 := var1 * var2
+	var4 := (int32(c.H6)<<7 + (temp*int32(c.H7))/100) >> 4
+	var5 := ((var3 >> 14) * (var3 >> 14)) >> 10
+	var6 := (var4 * var5) >> 1
+	h := (((var3 + var6) >> 10) * 1000) >> 12
+
+	return max(0, min(h, 100000))
+}
+
+// compensateGas returns gas resistance in Ω
+func (c *bme680Calib) compensateGas(adcG uint16, gasRange uint8) uint32 {
+	var1 := ((1340 + 5*int64(c.RangeSwErr)) * bme680GasLookup1[gasRange]) >> 16
+	var2 := int64(adcG)<<15 - 16777216 + var1
+	var3 := (bme680GasLookup2[gasRange] * var1) >> 9
+
+	return uint32((var3 + var2>>1) / var2)
+}
+
+// heaterResistance returns res_heat_x register value for the target temperature
+func (c *bme680Calib) heaterResistance(target int, ambient float64) byte {
+	target = min(target, bme680MaxHeatTemp)
+
+	var1 := ((int32(ambient) * int32(c.GH3)) / 1000) * 256
+	var2 := (int32(c.GH1) + 784) * (((((int32(c.GH2) + 154009) * int32(target) * 5) / 100) + 3276800) / 10)
+	var3 := var1 + var2/2
+	var4 := var3 / (int32(c.ResHeatRange) + 4)
+	var5 := 131*int32(c.ResHeatVal) + 65536
+	res := ((var4 / var5) - 250) * 34
+
+	return byte((res + 50) / 100)
+}
+
+// gasWait encodes heating duration to gas_wait_x register: 6 bit value and 2 bit multiplier (1, 4, 16, 64)
+func gasWait(d time.Duration) byte {
+	ms := d.Milliseconds()
+	if ms >= 0xFC0 {
+		return 0xFF
+	}
+
+	var factor byte
+	for ms > 0x3F {
+		ms /= 4
+		factor++
+	}
+
+	return byte(ms) + factor*64
+}
+
+func (d *bme680) close() error {
+	return d.bus.Close()
+}
+
+// BME680 is a Bosch sensor for temperature, humidity, pressure and gas resistance.
+// Every reading runs a full measurement, gas resistance feeds the IAQ estimate.
+type BME680 struct {
+	dev   *bme680
+	iaq   *iaqTracker
+	reads *reader
+	// addr is where the chip is connected, for diagnostics
+	addr string
+
+	mu   sync.Mutex
+	last bme680Reading
+}
+
+func newBME680(dev *bme680) *BME680 {
+	return &BME680{dev: dev, iaq: newIAQTracker(), reads: newReader(), last: bme680Reading{t: 25}}
+}
+
+// Read runs a single forced mode measurement with gas heating. Gas resistance,
+// IAQ and VOC are NaN if the heater isn't stable, IAQ and VOC are NaN during the burn-in.
+func (b *BME680) Read(ctx context.Context) (Reading, error) {
+	return b.reads.read(ctx, b.measure)
+}
+
+func (b *BME680) measure() (Reading, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the heater resistance depends on the ambient temperature, use the last one
+	m, err := b.dev.measure(b.last.t)
+	if err != nil {
+		return Reading{}, err
+	}
+	b.last = m
+
+	rd := NewReading(time.Now())
+	rd.Temperature, rd.Humidity, rd.Pressure = m.t, m.h, m.p
+	if m.gas == 0 {
+		log.Debg.Println("BME680 gas measurement is not valid, heater is not stable")
+		return rd, nil
+	}
+
+	b.iaq.update(m.gas)
+	rd.AirQuality = b.iaq.airQuality(m.gas, m.h)
+
+	return rd, nil
+}
+
+// Info describes the chip, its connection and heater profile
+func (b *BME680) Info() Info {
+	return Info{
+		Chip:        "BME680",
+		ID:          fmt.Sprintf("0x%x", bme680ChipID),
+		Addr:        b.addr,
+		Calibration: b.dev.validate(),
+		Settings:    fmt.Sprintf("heater %d °C for %s", b.dev.heater.Temp, b.dev.heater.Duration),
+	}
+}
+
+// Close closes the bus connection
+func (b *BME680) Close() error {
+	return b.dev.close()
+}
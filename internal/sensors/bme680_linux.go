@@ -0,0 +1,51 @@
+//go:build linux
+
+package sensors
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+func init() {
+	Register("bme680", func(spec Spec) (Climate, error) {
+		return NewBME680(spec.Opts()...)
+	})
+}
+
+// NewBME680 probes configured addresses for BME680 and sets its heater profile
+func NewBME680(opts ...Option) (*BME680, error) {
+	log.Info.Println("make BME680 sensor")
+
+	o := makeOpts(opts...)
+
+	var errs []error
+	for _, addr := range o.addrs() {
+		conn, err := openI2CDev(o.Bus, addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("addr 0x%x: %w", addr, err))
+			continue
+		}
+
+		dev, err := newBME680Dev(conn, o.Heater, time.Sleep)
+		if err != nil {
+			_ = conn.Close()
+			errs = append(errs, fmt.Errorf("addr 0x%x: %w", addr, err))
+
+			continue
+		}
+
+		log.Info.Printf("found BME680 sensor at bus %d addr 0x%x, heater %d °C for %s",
+			o.Bus, addr, o.Heater.Temp, o.Heater.Duration)
+
+		b := newBME680(dev)
+		b.addr = i2cAddr(o.Bus, addr)
+
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("can't find BME680 sensor on i2c bus %d: %w", o.Bus, errors.Join(errs...))
+}
@@ -0,0 +1,185 @@
+package sensors
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBME680Calib is calibration of a real BME680 board
+var testBME680Calib = bme680Calib{
+	T1: 26028, T2: 26126, T3: 3,
+	P1: 35831, P2: -10480, P3: 88, P4: 8070, P5: -70, P6: 30, P7: 54, P8: -2102, P9: -3022, P10: 30,
+	H1: 761, H2: 1044, H3: 0, H4: 45, H5: 20, H6: 120, H7: -100,
+	GH1: -30, GH2: -12506, GH3: 18,
+	ResHeatRange: 1, ResHeatVal: 48, RangeSwErr: 0,
+}
+
+// fakeBME680 is a register map with calibration and raw ADC values,
+// a forced measurement sets new data flag
+type fakeBME680 struct {
+	regs   [256]byte
+	writes map[byte]byte
+}
+
+func newFakeBME680(c bme680Calib, adcT, adcP, adcH int32, adcG uint16, gasRange byte) *fakeBME680 {
+	f := &fakeBME680{writes: make(map[byte]byte)}
+	f.regs[bme680RegID] = bme680ChipID
+
+	coeff := make([]byte, bme680Coeff1Len+bme680Coeff2Len)
+	put16 := func(msb, lsb int, v uint16) { coeff[msb], coeff[lsb] = byte(v>>8), byte(v) }
+	put16(34, 33, c.T1)
+	put16(2, 1, uint16(c.T2))
+	coeff[3] = byte(c.T3)
+	put16(6, 5, c.P1)
+	put16(8, 7, uint16(c.P2))
+	coeff[9] = byte(c.P3)
+	put16(12, 11, uint16(c.P4))
+	put16(14, 13, uint16(c.P5))
+	coeff[16], coeff[15] = byte(c.P6), byte(c.P7)
+	put16(20, 19, uint16(c.P8))
+	put16(22, 21, uint16(c.P9))
+	coeff[23] = c.P10
+	coeff[27], coeff[25] = byte(c.H1>>4), byte(c.H2>>4)
+	coeff[26] = byte(c.H1&0x0F) | byte(c.H2&0x0F)<<4
+	coeff[28], coeff[29], coeff[30], coeff[31], coeff[32] = byte(c.H3), byte(c.H4), byte(c.H5), c.H6, byte(c.H7)
+	coeff[37], coeff[38] = byte(c.GH1), byte(c.GH3)
+	put16(36, 35, uint16(c.GH2))
+	copy(f.regs[bme680RegCoeff1:], coeff[:bme680Coeff1Len])
+	copy(f.regs[bme680RegCoeff2:], coeff[bme680Coeff1Len:])
+
+	f.regs[bme680RegResHeatVal] = byte(c.ResHeatVal)
+	f.regs[bme680RegResHeatRange] = c.ResHeatRange << 4
+	f.regs[bme680RegRangeSwErr] = byte(c.RangeSwErr) << 4
+
+	data := f.regs[bme680RegField0:]
+	data[2], data[3], data[4] = byte(adcP>>12), byte(adcP>>4), byte(adcP<<4)
+	data[5], data[6], data[7] = byte(adcT>>12), byte(adcT>>4), byte(adcT<<4)
+	data[8], data[9] = byte(adcH>>8), byte(adcH)
+	data[13], data[14] = byte(adcG>>2), byte(adcG<<6)|bme680GasValid|bme680HeatStab|gasRange
+
+	return f
+}
+
+func (f *fakeBME680) ReadReg(reg byte, buf []byte) error {
+	copy(buf, f.regs[reg:])
+	return nil
+}
+
+func (f *fakeBME680) WriteReg(reg, val byte) error {
+	f.writes[reg] = val
+	if reg == bme680RegCtrlMeas && val&0b11 == bmx280ModeForced {
+		f.regs[bme680RegField0] |= bme680NewData
+	}
+
+	return nil
+}
+
+func (f *fakeBME680) Close() error { return nil }
+
+func TestBME680Compensation(t *testing.T) {
+	c := testBME680Calib
+	adcT, adcP, adcH := int32(500000), int32(400000), int32(20000)
+
+	// floating point formulas from the datasheet
+	fT := func() (tFine, temp float64) {
+		x := float64(adcT)/16384 - float64(c.T1)/1024
+		y := float64(adcT)/131072 - float64(c.T1)/8192
+		tFine = x*float64(c.T2) + y*y*float64(c.T3)*16
+
+		return tFine, tFine / 5120
+	}
+	tFineF, tempF := fT()
+
+	var1 := tFineF/2 - 64000
+	var2 := var1 * var1 * float64(c.P6) / 131072
+	var2 += var1 * float64(c.P5) * 2
+	var2 = var2/4 + float64(c.P4)*65536
+	var1 = (float64(c.P3)*var1*var1/16384 + float64(c.P2)*var1) / 524288
+	var1 = (1 + var1/32768) * float64(c.P1)
+	press := (1048576 - float64(adcP) - var2/4096) * 6250 / var1
+	press += (float64(c.P9)*press*press/2147483648 + press*float64(c.P8)/32768 +
+		(press/256)*(press/256)*(press/256)*float64(c.P10)/131072 + float64(c.P7)*128) / 16
+
+	tc := tFineF / 5120
+	h1 := float64(adcH) - (float64(c.H1)*16 + float64(c.H3)/2*tc)
+	h2 := h1 * (float64(c.H2) / 262144 * (1 + float64(c.H4)/16384*tc + float64(c.H5)/1048576*tc*tc))
+	hum := h2 + (float64(c.H6)/16384+float64(c.H7)/2097152*tc)*h2*h2
+
+	tFine, temp := c.compensateT(adcT)
+	assert.InDelta(t, tempF, float64(temp)/100, 0.01)
+	assert.InDelta(t, press, float64(c.compensateP(adcP, tFine)), 5)
+	assert.InDelta(t, hum, float64(c.compensateH(adcH, tFine))/1000, 0.1)
+
+	// gas with range 0 (no range correction): 1 / (125e-9 * ((adc - 512) / 1340 + 1))
+	assert.InDelta(t, 8e6, float64(c.compensateGas(512, 0)), 1e3)
+	assert.InDelta(t, 1/(125e-9*(-256.0/1340+1)), float64(c.compensateGas(256, 0)), 1e3)
+	assert.Greater(t, c.compensateGas(256, 5), c.compensateGas(768, 5), "resistance is lower with higher ADC")
+}
+
+func TestGasWait(t *testing.T) {
+	assert.Equal(t, byte(0x3F), gasWait(63*time.Millisecond))
+	assert.Equal(t, byte(0x40|0x25), gasWait(150*time.Millisecond))
+	assert.Equal(t, byte(0xFF), gasWait(bme680MaxGasWait))
+}
+
+func TestParseHeaterProfile(t *testing.T) {
+	h, err := ParseHeaterProfile("320:150ms")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultHeaterProfile, h)
+
+	for _, s := range []string{"320", "hot:150ms", "320:long", "500:150ms", "320:5s"} {
+		_, err := ParseHeaterProfile(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestBME680(t *testing.T) {
+	bus := newFakeBME680(testBME680Calib, 500000, 400000, 20000, 512, 4)
+	dev, err := newBME680Dev(bus, DefaultHeaterProfile, func(time.Duration) {})
+	require.NoError(t, err)
+	assert.Equal(t, testBME680Calib, dev.calib)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newBME680(dev)
+	b.iaq.now = func() time.Time { return now }
+
+	rd, err := b.Read(context.Background())
+	require.NoError(t, err)
+	tFine, want := testBME680Calib.compensateT(500000)
+	assert.InDelta(t, float64(want)/100, rd.Temperature, 1e-9)
+	assert.False(t, math.IsNaN(rd.Humidity))
+	assert.InDelta(t, float64(testBME680Calib.compensateP(400000, tFine))/100, rd.Pressure, 1e-9)
+	assert.True(t, math.IsNaN(rd.CO2))
+
+	// heater profile 0 is set and used
+	assert.Equal(t, testBME680Calib.heaterResistance(320, 25), bus.writes[bme680RegResHeat0])
+	assert.Equal(t, gasWait(150*time.Millisecond), bus.writes[bme680RegGasWait0])
+	assert.Equal(t, byte(bme680RunGas), bus.writes[bme680RegCtrlGas1])
+
+	// IAQ is unknown during the burn-in
+	assert.InDelta(t, float64(testBME680Calib.compensateGas(512, 4)), rd.AirQuality.GasResistance, 1e-9)
+	assert.True(t, math.IsNaN(rd.AirQuality.IAQ))
+
+	now = now.Add(iaqBurnIn)
+	rd, err = b.Read(context.Background())
+	require.NoError(t, err)
+	assert.Less(t, rd.AirQuality.IAQ, 100.0)
+	assert.InDelta(t, 0, rd.AirQuality.VOC, 1e-9)
+
+	// not stable heater makes gas reading invalid, the rest of the reading is fine
+	bus.regs[bme680RegField0+14] &^= bme680HeatStab
+	rd, err = b.Read(context.Background())
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(rd.AirQuality.GasResistance))
+	assert.True(t, math.IsNaN(rd.AirQuality.IAQ))
+	assert.InDelta(t, float64(want)/100, rd.Temperature, 1e-9)
+
+	bus.regs[bme680RegID] = 0x60
+	_, err = newBME680Dev(bus, DefaultHeaterProfile, func(time.Duration) {})
+	assert.ErrorIs(t, err, errUnknownChip)
+}
@@ -0,0 +1,429 @@
+package sensors
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// BME280/BMP280 registers, see Bosch BST-BME280-DS002 datasheet
+const (
+	bmx280RegCalib00  = 0x88
+	bmx280RegH1       = 0xA1
+	bmx280RegID       = 0xD0
+	bmx280RegReset    = 0xE0
+	bmx280RegCalib26  = 0xE1
+	bmx280RegCtrlHum  = 0xF2
+	bmx280RegStatus   = 0xF3
+	bmx280RegCtrlMeas = 0xF4
+	bmx280RegConfig   = 0xF5
+	bmx280RegData     = 0xF7
+
+	bmx280ResetCmd       = 0xB6
+	bmx280StatusMeasure  = 0x08
+	bmx280StatusIMUpdate = 0x01
+	bmx280ModeForced     = byte(ModeForced)
+
+	bmx280ChipBME280        = 0x60
+	bmx280ChipBMP280        = 0x58
+	bmx280ChipBMP280Sample1 = 0x56
+	bmx280ChipBMP280Sample2 = 0x57
+
+	// skipped measurements read as these values
+	bmx280Skipped20 = 0x80000
+	bmx280Skipped16 = 0x8000
+)
+
+// errUnknownChip means there is some device, but not a BME280/BMP280
+var errUnknownChip = errors.New("unknown chip")
+
+// bmx280Standby are normal mode standby times by t_sb register values, the last two differ by chip
+var (
+	bmx280Standby = []time.Duration{
+		500 * time.Microsecond, 62500 * time.Microsecond, 125 * time.Millisecond,
+		250 * time.Millisecond, 500 * time.Millisecond, time.Second,
+	}
+	bme280Standby = append(bmx280Standby[:6:6], 10*time.Millisecond, 20*time.Millisecond)
+	bmp280Standby = append(bmx280Standby[:6:6], 2*time.Second, 4*time.Second)
+)
+
+// Oversampling of a measurement, register values
+type Oversampling byte
+
+const (
+	OversamplingSkip Oversampling = iota
+	Oversampling1x
+	Oversampling2x
+	Oversampling4x
+	Oversampling8x
+	Oversampling16x
+)
+
+// samples returns the number of samples, 0 for skipped measurement
+func (o Oversampling) samples() int {
+	if o == OversamplingSkip {
+		return 0
+	}
+
+	return 1 << (min(o, Oversampling16x) - 1)
+}
+
+func (o Oversampling) String() string {
+	if o == OversamplingSkip {
+		return "skip"
+	}
+
+	return "×" + strconv.Itoa(o.samples())
+}
+
+// bmx280Calib are trimming parameters stored in the chip NVM
+type bmx280Calib struct {
+	T1         uint16
+	T2, T3     int16
+	P1         uint16
+	P2, P3, P4 int16
+	P5, P6, P7 int16
+	P8, P9     int16
+	H1         uint8
+	H2         int16
+	H3         uint8
+	H4, H5     int16
+	H6         int8
+}
+
+// bmx280 is a driver of Bosch BME280 and BMP280 (no humidity) sensors
+type bmx280 struct {
+	bus      Bus
+	id       byte
+	calib    bmx280Calib
+	settings Settings
+
+	sleep func(time.Duration)
+}
+
+// newBMx280 checks the chip ID, resets the chip, reads calibration and applies settings
+func newBMx280(bus Bus, settings Settings) (*bmx280, error) {
+	d := &bmx280{
+		bus:      bus,
+		settings: settings,
+		sleep:    time.Sleep,
+	}
+
+	id := make([]byte, 1)
+	if err := bus.ReadReg(bmx280RegID, id); err != nil {
+		return nil, fmt.Errorf("can't read chip id: %w", err)
+	}
+	d.id = id[0]
+
+	switch d.id {
+	case bmx280ChipBME280, bmx280ChipBMP280, bmx280ChipBMP280Sample1, bmx280ChipBMP280Sample2:
+	default:
+		return nil, fmt.Errorf("%w: id 0x%x", errUnknownChip, d.id)
+	}
+
+	if err := d.reset(); err != nil {
+		return nil, err
+	}
+
+	if err := d.readCalibration(); err != nil {
+		return nil, err
+	}
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := d.configure(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *bmx280) hasHumidity() bool {
+	return d.id == bmx280ChipBME280
+}
+
+func (d *bmx280) chipID() byte {
+	return d.id
+}
+
+func (d *bmx280) checkCalibration() error {
+	return d.validate()
+}
+
+func (d *bmx280) config() Settings {
+	return d.settings
+}
+
+func (d *bmx280) chip() string {
+	switch d.id {
+	case bmx280ChipBME280:
+		return "BME280"
+	case bmx280ChipBMP280:
+		return "BMP280"
+	default:
+		return "BMP280 (sample)"
+	}
+}
+
+// reset makes soft reset and waits till calibration is copied from NVM
+func (d *bmx280) reset() error {
+	if err := d.bus.WriteReg(bmx280RegReset, bmx280ResetCmd); err != nil {
+		return fmt.Errorf("can't reset: %w", err)
+	}
+
+	status := make([]byte, 1)
+	for range 10 {
+		d.sleep(2 * time.Millisecond)
+		if err := d.bus.ReadReg(bmx280RegStatus, status); err != nil {
+			return fmt.Errorf("can't read status: %w", err)
+		}
+		if status[0]&bmx280StatusIMUpdate == 0 {
+			return nil
+		}
+	}
+
+	return errors.New("reset timeout")
+}
+
+func (d *bmx280) readCalibration() error {
+	buf := make([]byte, 24)
+	if err := d.bus.ReadReg(bmx280RegCalib00, buf); err != nil {
+		return fmt.Errorf("can't read calibration: %w", err)
+	}
+
+	u16 := func(i int) uint16 { return binary.LittleEndian.Uint16(buf[i:]) }
+	s16 := func(i int) int16 { return int16(u16(i)) }
+
+	c := bmx280Calib{
+		T1: u16(0), T2: s16(2), T3: s16(4),
+		P1: u16(6), P2: s16(8), P3: s16(10), P4: s16(12), P5: s16(14),
+		P6: s16(16), P7: s16(18), P8: s16(20), P9: s16(22),
+	}
+
+	if d.hasHumidity() {
+		h1 := make([]byte, 1)
+		if err := d.bus.ReadReg(bmx280RegH1, h1); err != nil {
+			return fmt.Errorf("can't read calibration: %w", err)
+		}
+
+		h := make([]byte, 7)
+		if err := d.bus.ReadReg(bmx280RegCalib26, h); err != nil {
+			return fmt.Errorf("can't read calibration: %w", err)
+		}
+
+		c.H1 = h1[0]
+		c.H2 = int16(binary.LittleEndian.Uint16(h[0:]))
+		c.H3 = h[2]
+		// H4 and H5 are signed 12 bit values sharing 0xE5
+		c.H4 = int16(int8(h[3]))<<4 | int16(h[4]&0x0F)
+		c.H5 = int16(int8(h[5]))<<4 | int16(h[4]>>4)
+		c.H6 = int8(h[6])
+	}
+
+	d.calib = c
+
+	return nil
+}
+
+// validate checks calibration isn't empty, e.g. when it was read from a dead bus
+func (d *bmx280) validate() error {
+	if d.calib.T1 == 0 || d.calib.P1 == 0 {
+		return errors.New("invalid calibration: empty T1 or P1")
+	}
+
+	return nil
+}
+
+// standby returns t_sb register value of the chip for the standby time
+func (d *bmx280) standby(t time.Duration) (byte, error) {
+	times := bmp280Standby
+	if d.hasHumidity() {
+		times = bme280Standby
+	}
+
+	i := slices.Index(times, t)
+	if i < 0 {
+		return 0, fmt.Errorf("%s doesn't support standby %s, want one of %v", d.chip(), t, times)
+	}
+
+	return byte(i), nil
+}
+
+// configure checks settings against the chip and writes them, normal mode starts measuring right away
+func (d *bmx280) configure() error {
+	s := d.settings
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	var tsb byte
+	if s.Mode == ModeNormal {
+		var err error
+		if tsb, err = d.standby(s.Standby); err != nil {
+			return err
+		}
+	}
+
+	// config is written in sleep mode only, the chip is in sleep mode after reset
+	if err := d.bus.WriteReg(bmx280RegConfig, tsb<<5|byte(s.Filter)<<2); err != nil {
+		return fmt.Errorf("can't write config: %w", err)
+	}
+	if s.Mode != ModeNormal {
+		return nil
+	}
+
+	if err := d.start(); err != nil {
+		return err
+	}
+	// the first measurement has to be done before the first read
+	d.sleep(d.measureTime())
+
+	return d.waitMeasured()
+}
+
+// start writes oversampling and power mode, in forced mode it starts a single measurement
+func (d *bmx280) start() error {
+	s := d.settings
+	if d.hasHumidity() {
+		// ctrl_hum is applied only after ctrl_meas write
+		if err := d.bus.WriteReg(bmx280RegCtrlHum, byte(s.OsrsH)); err != nil {
+			return fmt.Errorf("can't write ctrl_hum: %w", err)
+		}
+	}
+	meas := byte(s.OsrsT)<<5 | byte(s.OsrsP)<<2 | byte(s.Mode)
+	if err := d.bus.WriteReg(bmx280RegCtrlMeas, meas); err != nil {
+		return fmt.Errorf("can't write ctrl_meas: %w", err)
+	}
+
+	return nil
+}
+
+// measureTime is the max measurement time from the datasheet (appendix B)
+func (d *bmx280) measureTime() time.Duration {
+	us := 1250
+	if n := d.settings.OsrsT.samples(); n > 0 {
+		us += 2300 * n
+	}
+	if n := d.settings.OsrsP.samples(); n > 0 {
+		us += 2300*n + 575
+	}
+	if n := d.settings.OsrsH.samples(); n > 0 && d.hasHumidity() {
+		us += 2300*n + 575
+	}
+
+	return time.Duration(us) * time.Microsecond
+}
+
+// measure returns compensated values of a single forced mode measurement, or the latest one
+// in normal mode: t in °C, p in hPa, h in %RH (NaN for BMP280 or skipped measurements)
+func (d *bmx280) measure() (t, p, h float64, err error) {
+	if d.settings.Mode != ModeNormal {
+		if err := d.start(); err != nil {
+			return 0, 0, 0, err
+		}
+
+		d.sleep(d.measureTime())
+		if err := d.waitMeasured(); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	n := 6
+	if d.hasHumidity() {
+		n = 8
+	}
+	buf := make([]byte, n)
+	if err := d.bus.ReadReg(bmx280RegData, buf); err != nil {
+		return 0, 0, 0, fmt.Errorf("can't read data: %w", err)
+	}
+
+	adcP := int32(buf[0])<<12 | int32(buf[1])<<4 | int32(buf[2])>>4
+	adcT := int32(buf[3])<<12 | int32(buf[4])<<4 | int32(buf[5])>>4
+	if adcT == bmx280Skipped20 {
+		return 0, 0, 0, errors.New("temperature measurement is skipped")
+	}
+
+	tFine, t100 := d.calib.compensateT(adcT)
+	t = float64(t100) / 100
+
+	p = math.NaN()
+	if adcP != bmx280Skipped20 {
+		p = float64(d.calib.compensateP(adcP, tFine)) / 256 / 100
+	}
+
+	h = math.NaN()
+	if d.hasHumidity() {
+		adcH := int32(buf[6])<<8 | int32(buf[7])
+		if adcH != bmx280Skipped16 {
+			h = float64(d.calib.compensateH(adcH, tFine)) / 1024
+		}
+	}
+
+	return t, p, h, nil
+}
+
+func (d *bmx280) waitMeasured() error {
+	status := make([]byte, 1)
+	for range 10 {
+		if err := d.bus.ReadReg(bmx280RegStatus, status); err != nil {
+			return fmt.Errorf("can't read status: %w", err)
+		}
+		if status[0]&bmx280StatusMeasure == 0 {
+			return nil
+		}
+		d.sleep(time.Millisecond)
+	}
+
+	return errors.New("measurement timeout")
+}
+
+func (d *bmx280) close() error {
+	return d.bus.Close()
+}
+
+// compensateT returns t_fine and temperature in 0.01 °C (datasheet 4.2.3)
+func (c *bmx280Calib) compensateT(adcT int32) (tFine, t int32) {
+	var1 := ((adcT>>3 - int32(c.T1)<<1) * int32(c.T2)) >> 11
+	var2 := (((adcT>>4 - int32(c.T1)) * (adcT>>4 - int32(c.T1))) >> 12 * int32(c.T3)) >> 14
+	tFine = var1 + var2
+
+	return tFine, (tFine*5 + 128) >> 8
+}
+
+// compensateP returns pressure in Pa as Q24.8 (datasheet 4.2.3)
+func (c *bmx280Calib) compensateP(adcP, tFine int32) uint32 {
+	var1 := int64(tFine) - 128000
+	var2 := var1 * var1 * int64(c.P6)
+	var2 += (var1 * int64(c.P5)) << 17
+	var2 += int64(c.P4) << 35
+	var1 = (var1*var1*int64(c.P3))>>8 + (var1*int64(c.P2))<<12
+	var1 = ((int64(1)<<47 + var1) * int64(c.P1)) >> 33
+	if var1 == 0 {
+		// avoid division by zero
+		return 0
+	}
+
+	p := int64(1048576 - adcP)
+	p = ((p<<31 - var2) * 3125) / var1
+	var1 = (int64(c.P9) * (p >> 13) * (p >> 13)) >> 25
+	var2 = (int64(c.P8) * p) >> 19
+
+	return uint32((p+var1+var2)>>8 + int64(c.P7)<<4)
+}
+
+// compensateH returns humidity in %RH as Q22.10 (datasheet 4.2.3)
+func (c *bmx280Calib) compensateH(adcH, tFine int32) uint32 {
+	v := tFine - 76800
+	x := (adcH<<14 - int32(c.H4)<<20 - int32(c.H5)*v + 16384) >> 15
+	y := ((((v*int32(c.H6))>>10)*(((v*int32(c.H3))>>11)+32768))>>10 + 2097152) * int32(c.H2)
+	v = x * ((y + 8192) >> 14)
+	v -= ((((v >> 15) * (v >> 15)) >> 7) * int32(c.H1)) >> 4
+	v = max(0, min(v, 419430400))
+
+	return uint32(v >> 12)
+}
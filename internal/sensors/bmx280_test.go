@@ -0,0 +1,244 @@
+package sensors
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"math"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBMx280Compensation(t *testing.T) {
+	// the example from BME280 datasheet
+	tFine, temp := emuCalib.compensateT(519888)
+	assert.Equal(t, int32(128422), tFine)
+	assert.Equal(t, int32(2508), temp)
+
+	p := emuCalib.compensateP(415148, tFine)
+	assert.InDelta(t, 100653.27, float64(p)/256, 0.5)
+
+	h := emuCalib.compensateH(emuInvert(1<<16, func(adc int32) float64 {
+		return float64(emuCalib.compensateH(adc, tFine)) / 1024
+	}, 45), tFine)
+	assert.InDelta(t, 45.0, float64(h)/1024, 0.01)
+}
+
+func newTestBMx280(t *testing.T, emu *EmulatedBME280) *bmx280 {
+	t.Helper()
+
+	d, err := newBMx280(emu, DefaultSettings)
+	require.NoError(t, err)
+	d.sleep = func(time.Duration) {}
+
+	return d
+}
+
+func TestEmulatedBME280(t *testing.T) {
+	emu := NewEmulatedBME280(Diurnal{MeanT: 21.5, MeanH: 43.2, MeanP: 1013.25})
+	d := newTestBMx280(t, emu)
+	assert.Equal(t, "BME280", d.chip())
+	assert.Equal(t, emuCalib, d.calib)
+
+	temp, p, h, err := d.measure()
+	require.NoError(t, err)
+	assert.InDelta(t, 21.5, temp, 0.01)
+	assert.InDelta(t, 1013.25, p, 0.01)
+	assert.InDelta(t, 43.2, h, 0.01)
+
+	// forced mode goes back to sleep
+	assert.Zero(t, emu.regs[bmx280RegCtrlMeas]&0b11)
+	assert.Equal(t, byte(Oversampling16x), emu.regs[bmx280RegCtrlHum])
+}
+
+func TestEmulatedBME280Oversampling(t *testing.T) {
+	emu := NewEmulatedBME280(Diurnal{MeanT: 21.5, MeanH: 43.2, MeanP: 1013.25})
+	d := newTestBMx280(t, emu)
+	d.settings.OsrsT, d.settings.OsrsP, d.settings.OsrsH = Oversampling1x, Oversampling2x, OversamplingSkip
+
+	temp, p, _, err := d.measure()
+	require.NoError(t, err)
+	assert.Equal(t, byte(Oversampling1x<<5|Oversampling2x<<2), emu.regs[bmx280RegCtrlMeas])
+	// 16 bit temperature resolution
+	assert.Zero(t, emu.regs[bmx280RegData+5])
+	assert.InDelta(t, 21.5, temp, 0.1)
+	assert.InDelta(t, 1013.25, p, 0.1)
+
+	_, _, h, err := d.measure()
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(h), "skipped humidity")
+
+	d.settings.OsrsP = OversamplingSkip
+	_, p, _, err = d.measure()
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(p), "skipped pressure")
+
+	assert.Equal(t, 1250*time.Microsecond+2300*time.Microsecond, d.measureTime())
+}
+
+func TestEmulatedBMP280(t *testing.T) {
+	emu := NewEmulatedBME280(Diurnal{MeanT: 18, MeanH: 50, MeanP: 990})
+	emu.regs[bmx280RegID] = bmx280ChipBMP280
+	d := newTestBMx280(t, emu)
+	assert.Equal(t, "BMP280", d.chip())
+
+	_, p, h, err := d.measure()
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(h), "BMP280 has no humidity")
+	assert.InDelta(t, 990, p, 0.01)
+}
+
+func TestBMx280Settings(t *testing.T) {
+	emu := NewEmulatedBME280(Diurnal{MeanT: 21.5, MeanH: 43.2, MeanP: 1013.25})
+	settings := Settings{
+		OsrsT: Oversampling2x, OsrsP: Oversampling4x, OsrsH: Oversampling1x,
+		Filter: Filter4, Mode: ModeNormal, Standby: 20 * time.Millisecond,
+	}
+	d, err := newBMx280(emu, settings)
+	require.NoError(t, err)
+	d.sleep = func(time.Duration) {}
+
+	assert.Equal(t, byte(0b111<<5|byte(Filter4)<<2), emu.regs[bmx280RegConfig])
+	assert.Equal(t, byte(Oversampling2x<<5|Oversampling4x<<2|0b11), emu.regs[bmx280RegCtrlMeas], "normal mode")
+	assert.Equal(t, byte(Oversampling1x), emu.regs[bmx280RegCtrlHum])
+
+	// normal mode reads the latest measurement without starting a new one
+	emu.BusyPolls = 100
+	temp, p, h, err := d.measure()
+	require.NoError(t, err)
+	assert.InDelta(t, 21.5, temp, 0.1)
+	assert.InDelta(t, 1013.25, p, 0.1)
+	assert.InDelta(t, 43.2, h, 0.5)
+	assert.Equal(t, "normal, standby 20ms, oversampling T ×2 P ×4 H ×1, filter ×4", d.config().String())
+
+	// standby times differ by chip
+	_, err = newBMx280(NewEmulatedBME280(DefaultDiurnal), Settings{
+		OsrsT: Oversampling1x, Mode: ModeNormal, Standby: 2 * time.Second,
+	})
+	assert.ErrorContains(t, err, "BME280 doesn't support standby 2s")
+
+	bmp := NewEmulatedBME280(DefaultDiurnal)
+	bmp.regs[bmx280RegID] = bmx280ChipBMP280
+	_, err = newBMx280(bmp, Settings{OsrsT: Oversampling1x, Mode: ModeNormal, Standby: 2 * time.Second})
+	require.NoError(t, err)
+	assert.Equal(t, byte(0b110<<5), bmp.regs[bmx280RegConfig])
+
+	_, err = newBMx280(NewEmulatedBME280(DefaultDiurnal), Settings{Mode: ModeForced})
+	assert.ErrorContains(t, err, "temperature can't be skipped")
+}
+
+func TestBMx280Errors(t *testing.T) {
+	emu := NewEmulatedBME280(DefaultDiurnal)
+	emu.regs[bmx280RegID] = 0x61
+	_, err := newBMx280(emu, DefaultSettings)
+	assert.ErrorIs(t, err, errUnknownChip)
+
+	emu = NewEmulatedBME280(DefaultDiurnal)
+	emu.regs[bmx280RegCalib00], emu.regs[bmx280RegCalib00+1] = 0, 0
+	_, err = newBMx280(emu, DefaultSettings)
+	assert.ErrorContains(t, err, "invalid calibration")
+
+	emu = NewEmulatedBME280(DefaultDiurnal)
+	ioErr := &fs.PathError{Op: "read", Path: "/dev/i2c-1", Err: syscall.EIO}
+	emu.Fail(ioErr)
+	_, err = newBMx280(emu, DefaultSettings)
+	assert.ErrorIs(t, err, syscall.EIO)
+
+	emu.Fail(nil)
+	d := newTestBMx280(t, emu)
+	emu.BusyPolls = 100
+	_, _, _, err = d.measure()
+	assert.ErrorContains(t, err, "measurement timeout")
+
+	emu.BusyPolls = 0
+	_, _, _, err = d.measure()
+	assert.NoError(t, err)
+
+	// simulated sensor faults are bus errors
+	emu = NewEmulatedBME280(DefaultDiurnal, WithFaults(Fault{Kind: FaultIOError, To: time.Hour}))
+	d = newTestBMx280(t, emu)
+	_, _, _, err = d.measure()
+	assert.ErrorIs(t, err, ErrSimulatedIO)
+}
+
+func TestBME280Recovery(t *testing.T) {
+	emu := NewEmulatedBME280(Diurnal{MeanT: 20, MeanH: 50, MeanP: 1000})
+	opens := 0
+	b, err := newBME280(makeOpts(), func(addr uint8) (device, error) {
+		if addr != ProbeAddrs[1] {
+			return nil, errors.New("no such device")
+		}
+		opens++
+
+		d, err := newBMx280(emu.connect(), DefaultSettings)
+		if err != nil {
+			return nil, err
+		}
+		d.sleep = func(time.Duration) {}
+
+		return d, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ProbeAddrs[1], b.addr)
+	assert.Equal(t, "BME280", b.Chip())
+
+	emu.Fail(&fs.PathError{Op: "write", Path: "/dev/i2c-1", Err: syscall.EIO})
+	for range recoveryThreshold - 1 {
+		_, err = b.Read(context.Background())
+		assert.ErrorIs(t, err, syscall.EIO)
+	}
+
+	// the bus is back, but the connection is broken, so it is reopened on the threshold failure
+	emu.Fail(nil)
+	require.NoError(t, emu.Close())
+
+	// the chip is described while it's reopened, e.g. by the diagnostics page
+	done := make(chan struct{})
+	described := make(chan struct{})
+	go func() {
+		defer close(described)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = b.Info()
+				_ = b.Settings()
+			}
+		}
+	}()
+	rd, err := b.Read(context.Background())
+	close(done)
+	<-described
+	require.NoError(t, err)
+	assert.Equal(t, 2, opens)
+	assert.InDelta(t, 20, rd.Temperature, 0.01)
+	assert.Contains(t, b.RecoveryStatus(), "recovered after 1 attempt(s)")
+}
+
+func TestOpenEmulatedBME280(t *testing.T) {
+	c, err := Open(Spec{ID: "emu", Room: "Lab", Bus: DefaultBus, Driver: "bme280-emu"})
+	require.NoError(t, err)
+
+	rd, err := c.Read(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, DefaultDiurnal.MeanP, rd.Pressure, DefaultDiurnal.AmpP+DefaultDiurnal.Noise+0.01)
+	assert.InDelta(t, DefaultDiurnal.MeanH, rd.Humidity, DefaultDiurnal.AmpH+DefaultDiurnal.Noise+0.01)
+}
+
+func TestBME280Info(t *testing.T) {
+	c, err := Open(Spec{ID: "emu", Room: "Lab", Bus: DefaultBus, Driver: "bme280-emu"})
+	require.NoError(t, err)
+
+	info := NewCalibrated(c, "emu", nil).Info()
+	assert.Equal(t, Info{
+		Chip:     "BME280",
+		ID:       "0x60",
+		Addr:     "i2c-1 0x76",
+		Settings: "forced, oversampling T ×16 P ×16 H ×16, filter off",
+	}, info)
+}
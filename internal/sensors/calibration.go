@@ -0,0 +1,349 @@
+package sensors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+// Climate is a raw climate sensor, same as srv.ClimateSensor.
+// Read takes a single measurement of all quantities the sensor has, it returns when ctx is done
+// even if the bus hangs.
+type Climate interface {
+	Read(ctx context.Context) (Reading, error)
+}
+
+// CO2Calibrator sets CO2 sensor to a reference concentration and returns the applied correction in ppm
+type CO2Calibrator interface {
+	CalibrateCO2(ref float64) (float64, error)
+}
+
+// Linear is a linear correction: calibrated = raw*Gain + Offset
+type Linear struct {
+	Offset float64 `json:"offset"`
+	Gain   float64 `json:"gain"`
+}
+
+func (l Linear) apply(raw float64) float64 {
+	return raw*l.Gain + l.Offset
+}
+
+// Calibration of a single sensor
+type Calibration struct {
+	Temperature Linear `json:"temperature"`
+	Humidity    Linear `json:"humidity"`
+	// SelfHeating is applied after the linear temperature calibration, nil if it's off
+	SelfHeating *SelfHeating `json:"self_heating,omitempty"`
+}
+
+// NoCalibration keeps raw values as is
+var NoCalibration = Calibration{
+	Temperature: Linear{Offset: 0, Gain: 1},
+	Humidity:    Linear{Offset: 0, Gain: 1},
+}
+
+// CalibrationStore keeps calibrations of all sensors in a JSON file
+type CalibrationStore struct {
+	path string
+
+	mu   sync.RWMutex
+	data map[string]Calibration
+}
+
+// NewCalibrationStore loads calibrations from path, missing file means no calibrations yet
+func NewCalibrationStore(path string) (*CalibrationStore, error) {
+	s := &CalibrationStore{
+		path: path,
+		data: make(map[string]Calibration),
+	}
+
+	f, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read calibrations: %w", err)
+	}
+
+	if err := json.Unmarshal(f, &s.data); err != nil {
+		return nil, fmt.Errorf("can't decode calibrations: %w", err)
+	}
+
+	return s, nil
+}
+
+// Get returns calibration of the sensor, NoCalibration if there is none
+func (s *CalibrationStore) Get(sensorID string) Calibration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.data[sensorID]
+	if !ok {
+		return NoCalibration
+	}
+
+	return c
+}
+
+// Set updates calibration of the sensor and saves all of them to disk
+func (s *CalibrationStore) Set(sensorID string, c Calibration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the store keeps the old calibrations until new ones are saved
+	data := maps.Clone(s.data)
+	data[sensorID] = c
+
+	buf, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("can't encode calibrations: %w", err)
+	}
+	if err := writeFile(s.path, buf); err != nil {
+		return fmt.Errorf("can't save calibrations: %w", err)
+	}
+	s.data = data
+
+	return nil
+}
+
+// writeFile replaces the file by a synced temporary one, so a power cut leaves either the old or the new file
+func writeFile(path string, buf []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(buf); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Calibrated applies calibration to readings of the wrapped sensor and keeps the last raw values
+type Calibrated struct {
+	Climate
+
+	id    string
+	store *CalibrationStore
+	// soc is the Raspberry Pi SoC temperature for self-heating compensation, nil if it's off
+	soc Climate
+
+	mu         sync.RWMutex
+	rawT, rawH float64
+	// uncompensated is the last temperature before self-heating compensation, socT is the SoC one at the moment
+	uncompensated, socT float64
+}
+
+type CalibratedOption func(c *Calibrated)
+
+// WithSoC enables self-heating compensation by the SoC temperature, e.g. host.SoCSensor
+func WithSoC(soc Climate) CalibratedOption {
+	return func(c *Calibrated) {
+		c.soc = soc
+	}
+}
+
+func NewCalibrated(sensor Climate, sensorID string, store *CalibrationStore, opts ...CalibratedOption) *Calibrated {
+	c := &Calibrated{
+		Climate:       sensor,
+		id:            sensorID,
+		store:         store,
+		rawT:          math.NaN(),
+		rawH:          math.NaN(),
+		uncompensated: math.NaN(),
+		socT:          math.NaN(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Read calibrates temperature and humidity of the reading and keeps the raw ones
+func (c *Calibrated) Read(ctx context.Context) (Reading, error) {
+	rd, err := c.Climate.Read(ctx)
+	if err != nil {
+		return rd, err
+	}
+
+	c.mu.Lock()
+	c.rawT, c.rawH = rd.Temperature, rd.Humidity
+	c.mu.Unlock()
+
+	cal := c.Calibration()
+	rd.Temperature = cal.Temperature.apply(rd.Temperature)
+	if !math.IsNaN(rd.Humidity) {
+		rd.Humidity = min(100, max(0, cal.Humidity.apply(rd.Humidity)))
+	}
+
+	if c.soc != nil {
+		socT := math.NaN()
+		socRd, err := c.soc.Read(ctx)
+		if err != nil {
+			log.Erro.Printf("can't read SoC temperature, %q isn't compensated: %s", c.id, err.Error())
+		} else {
+			socT = socRd.Temperature
+		}
+
+		c.mu.Lock()
+		c.uncompensated, c.socT = rd.Temperature, socT
+		c.mu.Unlock()
+
+		rd.Temperature = cal.SelfHeating.apply(rd.Temperature, socT)
+	}
+
+	return rd, nil
+}
+
+// SelfHeatingEnabled reports whether the sensor has a SoC temperature source for self-heating compensation
+func (c *Calibrated) SelfHeatingEnabled() bool {
+	return c.soc != nil
+}
+
+// Uncompensated returns the last temperature before self-heating compensation and the SoC one,
+// NaN if there were none or compensation is off
+func (c *Calibrated) Uncompensated() (t, soc float64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.uncompensated, c.socT
+}
+
+// AddSelfHeatingReference adds a reference reading of a trusted thermometer to self-heating samples
+// and fits the model again. The model is applied as soon as samples are enough to fit it.
+func (c *Calibrated) AddSelfHeatingReference(refT float64) (SelfHeating, error) {
+	if c.soc == nil {
+		return SelfHeating{}, errNoSoC
+	}
+	if math.IsNaN(refT) || math.IsInf(refT, 0) {
+		return SelfHeating{}, errors.New("reference temperature has to be finite")
+	}
+
+	t, soc := c.Uncompensated()
+	if math.IsNaN(t) || math.IsNaN(soc) {
+		return SelfHeating{}, errors.New("no uncompensated temperature yet")
+	}
+
+	cal := c.Calibration()
+	var sh SelfHeating
+	if cal.SelfHeating != nil {
+		sh.Samples = slices.Clone(cal.SelfHeating.Samples)
+	}
+	sh.Samples = append(sh.Samples, SelfHeatingSample{T: time.Now(), Sensor: t, SoC: soc, Ref: refT})
+	sh.fit()
+	cal.SelfHeating = &sh
+
+	return sh, c.SetCalibration(cal)
+}
+
+// ResetSelfHeating drops the model and its samples to start a new calibration period
+func (c *Calibrated) ResetSelfHeating() error {
+	cal := c.Calibration()
+	cal.SelfHeating = nil
+
+	return c.SetCalibration(cal)
+}
+
+// Raw returns the last raw (not calibrated) readings, NaN if there were none
+func (c *Calibrated) Raw() (t, h float64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.rawT, c.rawH
+}
+
+func (c *Calibrated) Calibration() Calibration {
+	return c.store.Get(c.id)
+}
+
+func (c *Calibrated) SetCalibration(cal Calibration) error {
+	if cal.Temperature.Gain == 0 || cal.Humidity.Gain == 0 {
+		return errors.New("gain can't be zero")
+	}
+	for _, v := range []float64{cal.Temperature.Offset, cal.Temperature.Gain, cal.Humidity.Offset, cal.Humidity.Gain} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("offsets and gains have to be finite")
+		}
+	}
+
+	return c.store.Set(c.id, cal)
+}
+
+// CalibrateReference computes offsets (keeping gains) so the last raw readings
+// match reference ones from a trusted thermometer. NaN reference is skipped.
+func (c *Calibrated) CalibrateReference(refT, refH float64) (Calibration, error) {
+	rawT, rawH := c.Raw()
+	cal := c.Calibration()
+
+	if !math.IsNaN(refT) {
+		if math.IsNaN(rawT) {
+			return cal, errors.New("no raw temperature yet")
+		}
+		cal.Temperature.Offset = refT - rawT*cal.Temperature.Gain
+	}
+	if !math.IsNaN(refH) {
+		if math.IsNaN(rawH) {
+			return cal, errors.New("no raw humidity yet")
+		}
+		cal.Humidity.Offset = refH - rawH*cal.Humidity.Gain
+	}
+
+	return cal, c.SetCalibration(cal)
+}
+
+// CalibrateCO2 passes through to the wrapped sensor, CO2 sensors have own calibration
+func (c *Calibrated) CalibrateCO2(ref float64) (float64, error) {
+	if cc, ok := c.Climate.(CO2Calibrator); ok {
+		return cc.CalibrateCO2(ref)
+	}
+
+	return 0, ErrNotSupported
+}
+
+// RecoveryStatus passes through the status of the wrapped sensor
+func (c *Calibrated) RecoveryStatus() string {
+	if rr, ok := c.Climate.(interface{ RecoveryStatus() string }); ok {
+		return rr.RecoveryStatus()
+	}
+
+	return ""
+}
+
+// Settings passes through measurement settings of the wrapped sensor
+func (c *Calibrated) Settings() string {
+	if sr, ok := c.Climate.(interface{ Settings() string }); ok {
+		return sr.Settings()
+	}
+
+	return ""
+}
+
+// Info passes through the description of the wrapped sensor
+func (c *Calibrated) Info() Info {
+	if ir, ok := c.Climate.(interface{ Info() Info }); ok {
+		return ir.Info()
+	}
+
+	return Info{}
+}
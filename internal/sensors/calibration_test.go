@@ -0,0 +1,88 @@
+package sensors
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClimate struct {
+	t, h, p float64
+}
+
+func (f *fakeClimate) Read(context.Context) (Reading, error) {
+	rd := NewReading(time.Now())
+	rd.Temperature, rd.Humidity, rd.Pressure = f.t, f.h, f.p
+
+	return rd, nil
+}
+
+func TestCalibrated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calibration.json")
+	store, err := NewCalibrationStore(path)
+	require.NoError(t, err)
+
+	raw := &fakeClimate{t: 25, h: 40, p: 1000}
+	c := NewCalibrated(raw, "kitchen", store)
+
+	rawT, rawH := c.Raw()
+	assert.True(t, math.IsNaN(rawT))
+	assert.True(t, math.IsNaN(rawH))
+
+	_, err = c.CalibrateReference(21, math.NaN())
+	assert.Error(t, err, "no raw values yet")
+
+	// no calibration by default
+	rd, err := c.Read(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, 25, rd.Temperature, 1e-9)
+	assert.InDelta(t, 40, rd.Humidity, 1e-9)
+	assert.InDelta(t, 1000, rd.Pressure, 1e-9)
+
+	require.NoError(t, c.SetCalibration(Calibration{
+		Temperature: Linear{Offset: -1, Gain: 0.9},
+		Humidity:    Linear{Offset: 70, Gain: 1},
+	}))
+	rd, _ = c.Read(context.Background())
+	assert.InDelta(t, 21.5, rd.Temperature, 1e-9)
+	assert.InDelta(t, 100, rd.Humidity, 1e-9, "humidity is clamped")
+
+	// reference point computes offset
+	cal, err := c.CalibrateReference(20, 45)
+	require.NoError(t, err)
+	assert.InDelta(t, -2.5, cal.Temperature.Offset, 1e-9)
+	assert.InDelta(t, 5, cal.Humidity.Offset, 1e-9)
+	rd, _ = c.Read(context.Background())
+	assert.InDelta(t, 20, rd.Temperature, 1e-9)
+
+	// calibrations are persisted
+	reloaded, err := NewCalibrationStore(path)
+	require.NoError(t, err)
+	assert.Equal(t, cal, reloaded.Get("kitchen"))
+	assert.Equal(t, NoCalibration, reloaded.Get("bedroom"))
+
+	assert.Error(t, c.SetCalibration(Calibration{}))
+	assert.Error(t, c.SetCalibration(Calibration{
+		Temperature: Linear{Offset: math.NaN(), Gain: 1},
+		Humidity:    Linear{Offset: 0, Gain: math.Inf(1)},
+	}))
+	assert.Equal(t, cal, store.Get("kitchen"))
+
+	// a calibration which can't be saved isn't kept either
+	assert.Error(t, store.Set("kitchen", Calibration{Temperature: Linear{Offset: math.NaN(), Gain: 1}}))
+	assert.Equal(t, cal, store.Get("kitchen"))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left")
+
+	// no humidity stays unsupported
+	rd, err = NewCalibrated(&fakeClimate{t: 25, h: math.NaN()}, "kitchen", store).Read(context.Background())
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(rd.Humidity))
+}
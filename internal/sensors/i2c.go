@@ -0,0 +1,19 @@
+package sensors
+
+// Bus is a connection to a single device on I2C bus.
+// It's implemented by i2c-dev on linux and by EmulatedBME280 on any platform.
+type Bus interface {
+	// ReadReg reads len(buf) bytes starting from reg (registers auto-increment)
+	ReadReg(reg byte, buf []byte) error
+	// WriteReg writes a single register
+	WriteReg(reg, val byte) error
+	Close() error
+}
+
+// Conn is a raw connection to a single device on I2C bus, it's used by
+// command based chips (e.g. Sensirion) which have no register map
+type Conn interface {
+	Write(buf []byte) error
+	Read(buf []byte) error
+	Close() error
+}
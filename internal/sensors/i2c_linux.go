@@ -0,0 +1,49 @@
+//go:build linux
+
+package sensors
+
+import (
+	"github.com/d2r2/go-i2c"
+)
+
+// i2cDev is a Bus and a Conn over linux /dev/i2c-N
+type i2cDev struct {
+	conn *i2c.I2C
+}
+
+func openI2CDev(bus int, addr uint8) (*i2cDev, error) {
+	conn, err := i2c.NewI2C(addr, bus)
+	if err != nil {
+		return nil, err
+	}
+
+	return &i2cDev{conn: conn}, nil
+}
+
+func (d *i2cDev) ReadReg(reg byte, buf []byte) error {
+	b, _, err := d.conn.ReadRegBytes(reg, len(buf))
+	if err != nil {
+		return err
+	}
+	copy(buf, b)
+
+	return nil
+}
+
+func (d *i2cDev) WriteReg(reg, val byte) error {
+	return d.conn.WriteRegU8(reg, val)
+}
+
+func (d *i2cDev) Write(buf []byte) error {
+	_, err := d.conn.WriteBytes(buf)
+	return err
+}
+
+func (d *i2cDev) Read(buf []byte) error {
+	_, err := d.conn.ReadBytes(buf)
+	return err
+}
+
+func (d *i2cDev) Close() error {
+	return d.conn.Close()
+}
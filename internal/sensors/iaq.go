@@ -0,0 +1,85 @@
+package sensors
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// iaqBurnIn is the time a new MOX sensor needs to settle, IAQ is unknown meanwhile
+	iaqBurnIn = 5 * time.Minute
+	// iaqBaselineDecay is the time constant of the baseline following lower resistance,
+	// so the baseline adapts to the sensor drift but not to a bad air for a few hours
+	iaqBaselineDecay = 24 * time.Hour
+	// iaqHumBaseline is the optimal indoor humidity and iaqHumWeight is its share in the score
+	iaqHumBaseline = 40.0
+	iaqHumWeight   = 0.25
+	// iaqMaxVOC is the upper bound of HomeKit VOC density in µg/m³
+	iaqMaxVOC = 1000.0
+)
+
+// AirQuality is a gas sensor reading with an indoor air quality estimate
+type AirQuality struct {
+	// GasResistance is MOX sensor resistance in Ω, it's lower with more VOCs in the air
+	GasResistance float64
+	// IAQ is an index from 0 (clean air) to 500 (heavily polluted), NaN during the burn-in
+	IAQ float64
+	// VOC is a rough VOC density estimate in µg/m³ from the resistance drop, NaN during the burn-in
+	VOC float64
+}
+
+// iaqTracker keeps the gas resistance baseline of clean air. It follows higher
+// resistance at once and lower one slowly. It's not Bosch BSEC, but an estimate
+// good enough to see when a room needs ventilation.
+type iaqTracker struct {
+	now      func() time.Time
+	start    time.Time
+	last     time.Time
+	baseline float64
+}
+
+func newIAQTracker() *iaqTracker {
+	return &iaqTracker{now: time.Now}
+}
+
+func (tr *iaqTracker) update(gas float64) {
+	now := tr.now()
+	if tr.start.IsZero() {
+		tr.start, tr.last, tr.baseline = now, now, gas
+		return
+	}
+
+	dt := now.Sub(tr.last)
+	tr.last = now
+
+	if gas >= tr.baseline || now.Sub(tr.start) < iaqBurnIn {
+		tr.baseline = max(tr.baseline, gas)
+		return
+	}
+
+	tr.baseline -= (tr.baseline - gas) * min(1, dt.Seconds()/iaqBaselineDecay.Seconds())
+}
+
+// airQuality scores gas resistance against the baseline and humidity against the optimal one
+func (tr *iaqTracker) airQuality(gas, rh float64) AirQuality {
+	aq := AirQuality{GasResistance: gas, IAQ: math.NaN(), VOC: math.NaN()}
+	if tr.start.IsZero() || tr.now().Sub(tr.start) < iaqBurnIn {
+		return aq
+	}
+
+	gasScore := min(1, gas/tr.baseline) * (1 - iaqHumWeight) * 100
+
+	humScore := iaqHumWeight * 100
+	if !math.IsNaN(rh) {
+		if offset := rh - iaqHumBaseline; offset > 0 {
+			humScore *= (100 - iaqHumBaseline - offset) / (100 - iaqHumBaseline)
+		} else {
+			humScore *= (iaqHumBaseline + offset) / iaqHumBaseline
+		}
+	}
+
+	aq.IAQ = (100 - gasScore - max(0, humScore)) * 5
+	aq.VOC = min(iaqMaxVOC, max(0, tr.baseline/gas-1)*iaqMaxVOC)
+
+	return aq
+}
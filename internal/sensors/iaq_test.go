@@ -0,0 +1,47 @@
+package sensors
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIAQTracker(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr := newIAQTracker()
+	tr.now = func() time.Time { return now }
+
+	// resistance grows while the sensor settles, the baseline follows it
+	for _, gas := range []float64{50e3, 80e3, 100e3, 90e3} {
+		tr.update(gas)
+		assert.True(t, math.IsNaN(tr.airQuality(gas, 40).IAQ))
+		now = now.Add(time.Minute)
+	}
+	now = now.Add(iaqBurnIn)
+	assert.InDelta(t, 100e3, tr.baseline, 1e-9)
+
+	// clean air with optimal humidity
+	aq := tr.airQuality(100e3, 40)
+	assert.InDelta(t, 0, aq.IAQ, 1e-9)
+	assert.InDelta(t, 0, aq.VOC, 1e-9)
+
+	// VOCs halve the resistance, the baseline barely moves
+	tr.update(50e3)
+	assert.InDelta(t, 100e3, tr.baseline, 250)
+	aq = tr.airQuality(50e3, 40)
+	assert.InDelta(t, 187.5, aq.IAQ, 0.5)
+	assert.InDelta(t, iaqMaxVOC, aq.VOC, 5)
+
+	// humidity far from optimal lowers the score
+	aq = tr.airQuality(100e3, 70)
+	assert.InDelta(t, 62.5, aq.IAQ, 1e-9)
+	aq = tr.airQuality(100e3, math.NaN())
+	assert.InDelta(t, 0, aq.IAQ, 1e-9)
+
+	// the baseline slowly follows lower resistance
+	now = now.Add(iaqBaselineDecay / 2)
+	tr.update(50e3)
+	assert.InDelta(t, 75e3, tr.baseline, 250)
+}
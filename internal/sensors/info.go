@@ -0,0 +1,22 @@
+package sensors
+
+import "fmt"
+
+// Info describes a sensor for diagnostics, unknown fields are empty
+type Info struct {
+	// Chip is the sensor model, e.g. "BME280"
+	Chip string
+	// ID is the chip ID or serial number, e.g. "0x60"
+	ID string
+	// Addr is where the sensor is connected, e.g. "i2c-1 0x76"
+	Addr string
+	// Calibration is the result of chip calibration coefficients check, nil if they're valid or there are none
+	Calibration error
+	// Settings are measurement settings, empty if they aren't configurable
+	Settings string
+}
+
+// i2cAddr formats the bus and the address of I2C device
+func i2cAddr(bus int, addr uint8) string {
+	return fmt.Sprintf("i2c-%d 0x%x", bus, addr)
+}
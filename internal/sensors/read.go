@@ -0,0 +1,48 @@
+package sensors
+
+import (
+	"context"
+	"fmt"
+)
+
+// reader makes blocking driver reads cancellable. A bus transaction can't be interrupted,
+// so a hung read goes on in background and the next one waits for it as long as its context allows.
+type reader struct {
+	busy chan struct{}
+}
+
+func newReader() *reader {
+	return &reader{busy: make(chan struct{}, 1)}
+}
+
+// read calls fn unless ctx is done, fn calls never overlap
+func (r *reader) read(ctx context.Context, fn func() (Reading, error)) (Reading, error) {
+	if err := ctx.Err(); err != nil {
+		return Reading{}, err
+	}
+
+	select {
+	case r.busy <- struct{}{}:
+	case <-ctx.Done():
+		return Reading{}, fmt.Errorf("sensor is busy with the previous read: %w", ctx.Err())
+	}
+
+	type result struct {
+		rd  Reading
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { <-r.busy }()
+
+		rd, err := fn()
+		done <- result{rd: rd, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.rd, res.err
+	case <-ctx.Done():
+		return Reading{}, fmt.Errorf("read is not finished: %w", ctx.Err())
+	}
+}
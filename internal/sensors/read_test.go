@@ -0,0 +1,41 @@
+package sensors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTimeout(t *testing.T) {
+	// the latency fault hangs the bus till it's released
+	release := make(chan struct{})
+	sim := NewSimulated(Diurnal{MeanT: 20, MeanH: 50, MeanP: 1000},
+		WithClock(time.Now, func(time.Duration) { <-release }),
+		WithFaults(Fault{Kind: FaultLatency, To: time.Hour, Latency: time.Minute}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := sim.Read(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the next read waits for the hung one
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = sim.Read(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "busy with the previous read")
+
+	close(release)
+	rd, err := sim.Read(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, 20, rd.Temperature, 1e-9)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = sim.Read(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,111 @@
+package sensors
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	recoveryThreshold  = 3
+	recoveryMinBackoff = 30 * time.Second
+	recoveryMaxBackoff = 30 * time.Minute
+)
+
+// recovery tracks consecutive I/O failures of a sensor and decides when
+// the bus connection should be reopened. Attempts are spread with exponential backoff.
+type recovery struct {
+	threshold              int
+	minBackoff, maxBackoff time.Duration
+	now                    func() time.Time
+
+	mu          sync.Mutex
+	failures    int
+	attempts    int
+	backoff     time.Duration
+	nextAttempt time.Time
+	status      string
+}
+
+func newRecovery() *recovery {
+	return &recovery{
+		threshold:  recoveryThreshold,
+		minBackoff: recoveryMinBackoff,
+		maxBackoff: recoveryMaxBackoff,
+		now:        time.Now,
+	}
+}
+
+// IsIOError reports whether err came from the bus, e.g. "write /dev/i2c-1: remote I/O error"
+func IsIOError(err error) bool {
+	var (
+		pathErr *fs.PathError
+		errno   syscall.Errno
+	)
+
+	return errors.As(err, &pathErr) || errors.As(err, &errno)
+}
+
+// success resets failures counter after a good read
+func (r *recovery) success() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.attempts > 0 {
+		r.status = fmt.Sprintf("recovered after %d attempt(s) at %s", r.attempts, r.now().Format(time.DateTime))
+	}
+	r.failures = 0
+	r.attempts = 0
+	r.backoff = 0
+}
+
+// failure registers a failed read and returns true if it's time to try to recover
+func (r *recovery) failure(err error) bool {
+	if !IsIOError(err) {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures++
+	if r.failures < r.threshold {
+		return false
+	}
+
+	return !r.now().Before(r.nextAttempt)
+}
+
+// attempted registers the result of recovery attempt and schedules the next one
+func (r *recovery) attempted(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.attempts++
+
+	// even successful reconnect may not help, so the next attempt waits anyway
+	if r.backoff == 0 {
+		r.backoff = r.minBackoff
+	} else {
+		r.backoff = min(2*r.backoff, r.maxBackoff)
+	}
+	r.nextAttempt = r.now().Add(r.backoff)
+
+	if err == nil {
+		r.status = fmt.Sprintf("reconnected (attempt %d)", r.attempts)
+
+		return
+	}
+	r.status = fmt.Sprintf("attempt %d failed: %s, next in %s", r.attempts, err.Error(), r.backoff)
+}
+
+// Status returns human-readable state of the last recovery, empty if there was none
+func (r *recovery) Status() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
@@ -0,0 +1,51 @@
+package sensors
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecovery(t *testing.T) {
+	now := time.Date(2024, 11, 29, 16, 30, 0, 0, time.UTC)
+	r := newRecovery()
+	r.now = func() time.Time { return now }
+
+	ioErr := &fs.PathError{Op: "write", Path: "/dev/i2c-1", Err: syscall.EIO}
+
+	// not I/O errors are ignored
+	assert.False(t, r.failure(errors.New("bad data")))
+
+	// recover only after threshold
+	assert.False(t, r.failure(ioErr))
+	assert.False(t, r.failure(ioErr))
+	assert.True(t, r.failure(ioErr))
+
+	// failed attempt makes backoff
+	r.attempted(errors.New("no such device"))
+	assert.Equal(t, "attempt 1 failed: no such device, next in 30s", r.Status())
+	assert.False(t, r.failure(ioErr))
+
+	now = now.Add(30 * time.Second)
+	assert.True(t, r.failure(ioErr))
+	r.attempted(errors.New("no such device"))
+	assert.Equal(t, recoveryMinBackoff*2, r.backoff)
+
+	// backoff is limited
+	for range 10 {
+		r.attempted(errors.New("no such device"))
+	}
+	assert.Equal(t, recoveryMaxBackoff, r.backoff)
+
+	now = now.Add(recoveryMaxBackoff)
+	assert.True(t, r.failure(ioErr))
+	r.attempted(nil)
+	r.success()
+	assert.Equal(t, "recovered after 13 attempt(s) at 2024-11-29 17:00:30", r.Status())
+	assert.Zero(t, r.failures)
+	assert.False(t, r.failure(ioErr))
+}
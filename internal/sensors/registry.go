@@ -0,0 +1,29 @@
+package sensors
+
+import "github.com/egregors/hk/internal/registry"
+
+// Driver makes a climate sensor described by spec
+type Driver func(spec Spec) (Climate, error)
+
+var drivers = registry.New[Driver]("sensor")
+
+// Register makes a driver available by name. Hardware drivers register
+// themselves only on platforms where they can work.
+func Register(name string, d Driver) {
+	drivers.Register(name, d)
+}
+
+// Drivers returns names of registered drivers
+func Drivers() []string {
+	return drivers.Names()
+}
+
+// Open makes a sensor with the driver from spec
+func Open(spec Spec) (Climate, error) {
+	d, err := drivers.Get(spec.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return d(spec)
+}
@@ -0,0 +1,254 @@
+package sensors
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+// SCD4xAddr is the fixed I2C address of Sensirion SCD40/SCD41
+const SCD4xAddr uint8 = 0x62
+
+// SCD4x commands, see Sensirion SCD4x datasheet
+const (
+	scd4xCmdStartPeriodic   = 0x21B1
+	scd4xCmdReadMeasurement = 0xEC05
+	scd4xCmdStopPeriodic    = 0x3F86
+	scd4xCmdDataReady       = 0xE4B8
+	scd4xCmdForcedRecal     = 0x362F
+	scd4xCmdSerialNumber    = 0x3682
+
+	scd4xStopDelay   = 500 * time.Millisecond
+	scd4xFRCDelay    = 400 * time.Millisecond
+	scd4xCmdDelay    = time.Millisecond
+	scd4xPeriod      = 5 * time.Second
+	scd4xPollEvery   = 100 * time.Millisecond
+	scd4xFRCFailed   = 0xFFFF
+	scd4xFRCZero     = 0x8000
+	scd4xReadyMask   = 0x07FF
+	sensirionCRCInit = 0xFF
+	sensirionCRCPoly = 0x31
+)
+
+// SCD4x is Sensirion SCD40/SCD41 photoacoustic CO2 sensor, it measures
+// temperature and humidity too. The sensor runs in periodic mode (every 5s),
+// readings are cached between measurements.
+type SCD4x struct {
+	conn  Conn
+	sleep func(time.Duration)
+	reads *reader
+	// addr is where the sensor is connected, for diagnostics
+	addr string
+
+	mu         sync.Mutex
+	serial     uint64
+	measured   bool
+	co2, t, rh float64
+}
+
+// newSCD4x stops periodic measurement left from the previous run,
+// checks the sensor answers with valid serial number and starts measurement
+func newSCD4x(conn Conn, sleep func(time.Duration)) (*SCD4x, error) {
+	d := &SCD4x{conn: conn, sleep: sleep, reads: newReader()}
+
+	if err := d.command(scd4xCmdStopPeriodic); err != nil {
+		return nil, fmt.Errorf("can't stop periodic measurement: %w", err)
+	}
+	d.sleep(scd4xStopDelay)
+
+	words, err := d.read(scd4xCmdSerialNumber, 3)
+	if err != nil {
+		return nil, fmt.Errorf("can't read serial number: %w", err)
+	}
+	d.serial = uint64(words[0])<<32 | uint64(words[1])<<16 | uint64(words[2])
+
+	if err := d.command(scd4xCmdStartPeriodic); err != nil {
+		return nil, fmt.Errorf("can't start periodic measurement: %w", err)
+	}
+	log.Info.Printf("SCD4x sensor 0x%012x started periodic measurement", d.serial)
+
+	return d, nil
+}
+
+// Serial returns 48 bit serial number of the sensor
+func (d *SCD4x) Serial() uint64 {
+	return d.serial
+}
+
+// Info describes the sensor and its connection
+func (d *SCD4x) Info() Info {
+	return Info{Chip: "SCD4x", ID: fmt.Sprintf("0x%012x", d.serial), Addr: d.addr}
+}
+
+// command sends a command with optional arguments, every argument word is followed by its CRC
+func (d *SCD4x) command(cmd uint16, args ...uint16) error {
+	buf := binary.BigEndian.AppendUint16(make([]byte, 0, 2+3*len(args)), cmd)
+	for _, arg := range args {
+		buf = binary.BigEndian.AppendUint16(buf, arg)
+		buf = append(buf, sensirionCRC(buf[len(buf)-2:]))
+	}
+
+	return d.conn.Write(buf)
+}
+
+// read sends a command and reads n response words checking their CRC
+func (d *SCD4x) read(cmd uint16, n int) ([]uint16, error) {
+	if err := d.command(cmd); err != nil {
+		return nil, err
+	}
+	d.sleep(scd4xCmdDelay)
+
+	return d.readWords(n)
+}
+
+func (d *SCD4x) readWords(n int) ([]uint16, error) {
+	buf := make([]byte, 3*n)
+	if err := d.conn.Read(buf); err != nil {
+		return nil, err
+	}
+
+	words := make([]uint16, n)
+	for i := range words {
+		w := buf[3*i : 3*i+3]
+		if crc := sensirionCRC(w[:2]); crc != w[2] {
+			return nil, fmt.Errorf("CRC mismatch in word %d: got 0x%02x, want 0x%02x", i, w[2], crc)
+		}
+		words[i] = binary.BigEndian.Uint16(w)
+	}
+
+	return words, nil
+}
+
+func (d *SCD4x) dataReady() (bool, error) {
+	words, err := d.read(scd4xCmdDataReady, 1)
+	if err != nil {
+		return false, fmt.Errorf("can't get data ready status: %w", err)
+	}
+
+	return words[0]&scd4xReadyMask != 0, nil
+}
+
+// update reads a new measurement if there is one. Only the first call
+// waits for the measurement, later ones return cached values until the next one.
+func (d *SCD4x) update() error {
+	for waited := time.Duration(0); ; waited += scd4xPollEvery {
+		ready, err := d.dataReady()
+		if err != nil {
+			return err
+		}
+		if ready {
+			break
+		}
+		if d.measured {
+			return nil
+		}
+		if waited > 2*scd4xPeriod {
+			return errors.New("no SCD4x measurement in time")
+		}
+		d.sleep(scd4xPollEvery)
+	}
+
+	words, err := d.read(scd4xCmdReadMeasurement, 3)
+	if err != nil {
+		return fmt.Errorf("can't read measurement: %w", err)
+	}
+
+	d.co2 = float64(words[0])
+	d.t = -45 + 175*float64(words[1])/65535
+	d.rh = 100 * float64(words[2]) / 65535
+	d.measured = true
+
+	return nil
+}
+
+// Read returns CO2 in ppm, temperature and humidity of the last measurement, the sensor has no pressure
+func (d *SCD4x) Read(ctx context.Context) (Reading, error) {
+	return d.reads.read(ctx, func() (Reading, error) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		if err := d.update(); err != nil {
+			return Reading{}, err
+		}
+
+		rd := NewReading(time.Now())
+		rd.Temperature, rd.Humidity, rd.CO2 = d.t, d.rh, d.co2
+
+		return rd, nil
+	})
+}
+
+// CalibrateCO2 runs forced recalibration (FRC) to the reference CO2 concentration
+// and returns the correction in ppm. The sensor should be measuring for at least
+// 3 minutes in the environment with stable reference concentration (e.g. ~420 ppm outdoors).
+func (d *SCD4x) CalibrateCO2(ref float64) (float64, error) {
+	if math.IsNaN(ref) || ref < 0 || ref > 0xFFFF {
+		return 0, fmt.Errorf("invalid reference CO2 concentration %.0f ppm", ref)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.command(scd4xCmdStopPeriodic); err != nil {
+		return 0, fmt.Errorf("can't stop periodic measurement: %w", err)
+	}
+	d.sleep(scd4xStopDelay)
+
+	correction, frcErr := d.forcedRecalibration(uint16(ref))
+
+	// measurement has to be restarted even if FRC failed
+	if err := d.command(scd4xCmdStartPeriodic); err != nil {
+		return 0, errors.Join(frcErr, fmt.Errorf("can't start periodic measurement: %w", err))
+	}
+	d.measured = false
+
+	return correction, frcErr
+}
+
+func (d *SCD4x) forcedRecalibration(ref uint16) (float64, error) {
+	if err := d.command(scd4xCmdForcedRecal, ref); err != nil {
+		return 0, fmt.Errorf("can't send forced recalibration: %w", err)
+	}
+	d.sleep(scd4xFRCDelay)
+
+	words, err := d.readWords(1)
+	if err != nil {
+		return 0, fmt.Errorf("can't read forced recalibration result: %w", err)
+	}
+	if words[0] == scd4xFRCFailed {
+		return 0, errors.New("forced recalibration failed, is the sensor measuring for 3 minutes?")
+	}
+
+	return float64(int(words[0]) - scd4xFRCZero), nil
+}
+
+// Close stops periodic measurement and closes the connection
+func (d *SCD4x) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return errors.Join(d.command(scd4xCmdStopPeriodic), d.conn.Close())
+}
+
+// sensirionCRC is CRC-8 of Sensirion sensors (polynomial 0x31, init 0xFF)
+func sensirionCRC(data []byte) byte {
+	crc := byte(sensirionCRCInit)
+	for _, b := range data {
+		crc ^= b
+		for range 8 {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ sensirionCRCPoly
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
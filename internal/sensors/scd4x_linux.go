@@ -0,0 +1,39 @@
+//go:build linux
+
+package sensors
+
+import (
+	"time"
+
+	"github.com/egregors/hk/log"
+)
+
+func init() {
+	Register("scd4x", func(spec Spec) (Climate, error) {
+		return NewSCD4x(spec.Opts()...)
+	})
+}
+
+// NewSCD4x opens SCD40/SCD41 sensor, AddrAuto means the fixed SCD4xAddr
+func NewSCD4x(opts ...Option) (*SCD4x, error) {
+	log.Info.Println("make SCD4x sensor")
+
+	o := makeOpts(opts...)
+	if o.Addr == AddrAuto {
+		o.Addr = SCD4xAddr
+	}
+
+	conn, err := openI2CDev(o.Bus, o.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := newSCD4x(conn, time.Sleep)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	d.addr = i2cAddr(o.Bus, o.Addr)
+
+	return d, nil
+}
@@ -0,0 +1,142 @@
+package sensors
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSCD4x answers SCD4x commands like the real sensor
+type fakeSCD4x struct {
+	cmds   []uint16
+	last   uint16
+	ready  bool
+	words  map[uint16][]uint16
+	frcArg uint16
+	badCRC bool
+}
+
+func newFakeSCD4x() *fakeSCD4x {
+	return &fakeSCD4x{
+		ready: true,
+		words: map[uint16][]uint16{
+			scd4xCmdSerialNumber:    {0xf896, 0x9f07, 0x3bb3},
+			scd4xCmdReadMeasurement: {0x01f4, 0x6667, 0x5eb9},
+			scd4xCmdForcedRecal:     {0x7fce},
+		},
+	}
+}
+
+func (f *fakeSCD4x) Write(buf []byte) error {
+	f.last = binary.BigEndian.Uint16(buf)
+	f.cmds = append(f.cmds, f.last)
+	if len(buf) == 5 {
+		if sensirionCRC(buf[2:4]) != buf[4] {
+			return errors.New("bad argument CRC")
+		}
+		f.frcArg = binary.BigEndian.Uint16(buf[2:])
+	}
+
+	return nil
+}
+
+func (f *fakeSCD4x) Read(buf []byte) error {
+	words := f.words[f.last]
+	if f.last == scd4xCmdDataReady {
+		words = []uint16{0x8000}
+		if f.ready {
+			words[0] |= 0x0006
+		}
+	}
+
+	for i := range len(buf) / 3 {
+		binary.BigEndian.PutUint16(buf[3*i:], words[i])
+		buf[3*i+2] = sensirionCRC(buf[3*i : 3*i+2])
+		if f.badCRC {
+			buf[3*i+2]++
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeSCD4x) Close() error { return nil }
+
+func TestSensirionCRC(t *testing.T) {
+	// the example from SCD4x datasheet
+	assert.Equal(t, byte(0x92), sensirionCRC([]byte{0xbe, 0xef}))
+}
+
+func TestSCD4x(t *testing.T) {
+	conn := newFakeSCD4x()
+	d, err := newSCD4x(conn, func(time.Duration) {})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0xf8969f073bb3), d.Serial())
+	assert.Equal(t, []uint16{scd4xCmdStopPeriodic, scd4xCmdSerialNumber, scd4xCmdStartPeriodic}, conn.cmds)
+
+	rd, err := d.Read(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, 500, rd.CO2, 1e-9)
+	assert.InDelta(t, 25.0, rd.Temperature, 0.01)
+	assert.InDelta(t, 37.0, rd.Humidity, 0.01)
+	assert.True(t, math.IsNaN(rd.Pressure))
+
+	// cached values are used until the next measurement
+	conn.ready = false
+	cached, err := d.Read(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, 500, cached.CO2, 1e-9)
+
+	conn.ready, conn.badCRC = true, true
+	_, err = d.Read(context.Background())
+	assert.ErrorContains(t, err, "CRC mismatch")
+}
+
+func TestSCD4xWaitsFirstMeasurement(t *testing.T) {
+	conn := newFakeSCD4x()
+	conn.ready = false
+
+	var slept time.Duration
+	d, err := newSCD4x(conn, func(d time.Duration) { slept += d })
+	require.NoError(t, err)
+
+	_, err = d.Read(context.Background())
+	assert.ErrorContains(t, err, "no SCD4x measurement in time")
+	assert.Greater(t, slept, 2*scd4xPeriod)
+}
+
+func TestSCD4xCalibrateCO2(t *testing.T) {
+	conn := newFakeSCD4x()
+	d, err := newSCD4x(conn, func(time.Duration) {})
+	require.NoError(t, err)
+	conn.cmds = nil
+
+	correction, err := d.CalibrateCO2(420)
+	require.NoError(t, err)
+	assert.InDelta(t, -50, correction, 1e-9)
+	assert.Equal(t, uint16(420), conn.frcArg)
+	assert.Equal(t, []uint16{scd4xCmdStopPeriodic, scd4xCmdForcedRecal, scd4xCmdStartPeriodic}, conn.cmds)
+
+	conn.words[scd4xCmdForcedRecal] = []uint16{scd4xFRCFailed}
+	_, err = d.CalibrateCO2(420)
+	assert.ErrorContains(t, err, "forced recalibration failed")
+	assert.Equal(t, uint16(scd4xCmdStartPeriodic), conn.last, "measurement is restarted")
+
+	_, err = d.CalibrateCO2(-1)
+	assert.Error(t, err)
+	_, err = d.CalibrateCO2(math.NaN())
+	assert.Error(t, err)
+
+	// calibrated wrapper passes CO2 through
+	c := NewCalibrated(d, "co2", nil)
+	_, err = c.CalibrateCO2(-1)
+	assert.ErrorContains(t, err, "invalid reference")
+	_, err = NewCalibrated(&fakeClimate{}, "t", nil).CalibrateCO2(420)
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
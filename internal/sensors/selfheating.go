@@ -0,0 +1,108 @@
+package sensors
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// selfHeatingMinSamples is how many reference readings the model needs
+	selfHeatingMinSamples = 3
+	// selfHeatingMinSpread is the least range of SoC to sensor temperature difference in samples, °C.
+	// Samples taken at the same CPU load can't tell heating from an offset.
+	selfHeatingMinSpread = 2.0
+)
+
+// SelfHeatingSample is a reference reading taken during the calibration period
+type SelfHeatingSample struct {
+	T time.Time `json:"t"`
+	// Sensor is the temperature with linear calibration, but without compensation
+	Sensor float64 `json:"sensor"`
+	SoC    float64 `json:"soc"`
+	Ref    float64 `json:"ref"`
+}
+
+// SelfHeating compensates heating of a sensor by the Raspberry Pi SoC in the same case.
+// The sensor error is modeled as linear to the SoC to sensor temperature difference:
+//
+//	t = sensor - (K*(soc - sensor) + B)
+//
+// K and B are fitted by least squares from reference readings, the model isn't applied till it's fitted.
+type SelfHeating struct {
+	K       float64             `json:"k"`
+	B       float64             `json:"b"`
+	Fitted  bool                `json:"fitted"`
+	RMSE    float64             `json:"rmse"`
+	Samples []SelfHeatingSample `json:"samples"`
+}
+
+// apply returns compensated temperature, t is as is if the model isn't fitted
+func (sh *SelfHeating) apply(t, soc float64) float64 {
+	if sh == nil || !sh.Fitted || math.IsNaN(soc) {
+		return t
+	}
+
+	return t - (sh.K*(soc-t) + sh.B)
+}
+
+// check returns why samples can't tell heating from an offset, nil if the model can be fitted
+func (sh *SelfHeating) check() error {
+	if len(sh.Samples) < selfHeatingMinSamples {
+		return fmt.Errorf("need %d reference readings, got %d", selfHeatingMinSamples, len(sh.Samples))
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range sh.Samples {
+		lo, hi = min(lo, s.SoC-s.Sensor), max(hi, s.SoC-s.Sensor)
+	}
+	if hi-lo < selfHeatingMinSpread {
+		return fmt.Errorf("SoC to sensor difference varies by %.1f °C only, take readings at different CPU load", hi-lo)
+	}
+
+	return nil
+}
+
+// fit computes K and B from samples by least squares of the sensor error
+func (sh *SelfHeating) fit() {
+	sh.Fitted = false
+	if sh.check() != nil {
+		return
+	}
+
+	n := float64(len(sh.Samples))
+	var sx, sy, sxx, sxy float64
+	for _, s := range sh.Samples {
+		x, y := s.SoC-s.Sensor, s.Sensor-s.Ref
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+	sh.K = (n*sxy - sx*sy) / (n*sxx - sx*sx)
+	sh.B = (sy - sh.K*sx) / n
+	sh.Fitted = true
+
+	var se float64
+	for _, s := range sh.Samples {
+		d := sh.apply(s.Sensor, s.SoC) - s.Ref
+		se += d * d
+	}
+	sh.RMSE = math.Sqrt(se / n)
+}
+
+func (sh *SelfHeating) String() string {
+	if sh == nil || len(sh.Samples) == 0 {
+		return "off"
+	}
+	if err := sh.check(); err != nil {
+		return "calibrating, " + err.Error()
+	}
+	if !sh.Fitted {
+		return fmt.Sprintf("not fitted, %d reference readings", len(sh.Samples))
+	}
+
+	return fmt.Sprintf("t - (%.4f * (soc - t) %+.2f), %d reference readings, rmse %.2f °C",
+		sh.K, sh.B, len(sh.Samples), sh.RMSE)
+}
+
+// errNoSoC means the calibrated sensor has no SoC temperature source
+var errNoSoC = errors.New("no SoC temperature, self-heating compensation isn't enabled for the sensor")
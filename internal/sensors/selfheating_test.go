@@ -0,0 +1,100 @@
+package sensors
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfHeatingFit(t *testing.T) {
+	var sh *SelfHeating
+	assert.Equal(t, "off", sh.String())
+	assert.InDelta(t, 25, sh.apply(25, 50), 1e-9)
+
+	// the sensor reads 0.1 °C more per degree of SoC to sensor difference plus 0.5 °C
+	sample := func(ref, diff float64) SelfHeatingSample {
+		sensor := ref + 0.1*diff + 0.5
+		return SelfHeatingSample{Sensor: sensor, SoC: sensor + diff, Ref: ref}
+	}
+
+	sh = &SelfHeating{Samples: []SelfHeatingSample{sample(20, 20), sample(21, 20.5)}}
+	sh.fit()
+	assert.False(t, sh.Fitted)
+	assert.Contains(t, sh.String(), "calibrating, need 3 reference readings, got 2")
+	assert.InDelta(t, 25, sh.apply(25, 50), 1e-9, "not fitted model isn't applied")
+
+	// the same CPU load can't tell heating from an offset
+	sh.Samples = append(sh.Samples, sample(22, 21))
+	sh.fit()
+	assert.False(t, sh.Fitted)
+	assert.Contains(t, sh.String(), "take readings at different CPU load")
+
+	sh.Samples = append(sh.Samples, sample(20, 35))
+	sh.fit()
+	require.True(t, sh.Fitted)
+	assert.InDelta(t, 0.1, sh.K, 1e-9)
+	assert.InDelta(t, 0.5, sh.B, 1e-9)
+	assert.InDelta(t, 0, sh.RMSE, 1e-9)
+	assert.Equal(t, "t - (0.1000 * (soc - t) +0.50), 4 reference readings, rmse 0.00 °C", sh.String())
+
+	s := sample(23, 30)
+	assert.InDelta(t, 23, sh.apply(s.Sensor, s.SoC), 1e-9)
+	assert.InDelta(t, s.Sensor, sh.apply(s.Sensor, math.NaN()), 1e-9, "no SoC temperature, no compensation")
+}
+
+func TestCalibratedSelfHeating(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calibration.json")
+	store, err := NewCalibrationStore(path)
+	require.NoError(t, err)
+
+	_, err = NewCalibrated(&fakeClimate{t: 25}, "kitchen", store).AddSelfHeatingReference(21)
+	assert.ErrorIs(t, err, errNoSoC)
+
+	raw, soc := &fakeClimate{t: 25, h: 40}, &fakeClimate{t: 45, h: math.NaN()}
+	c := NewCalibrated(raw, "kitchen", store, WithSoC(soc))
+	assert.True(t, c.SelfHeatingEnabled())
+
+	_, err = c.AddSelfHeatingReference(21)
+	assert.Error(t, err, "no readings yet")
+
+	// the sensor is 0.2 °C off per degree of SoC to sensor difference
+	for i, ref := range []float64{21, 22, 20} {
+		diff := 15 + 5*float64(i)
+		raw.t = ref + 0.2*diff
+		soc.t = raw.t + diff
+		rd, err := c.Read(context.Background())
+		require.NoError(t, err)
+		assert.InDelta(t, raw.t, rd.Temperature, 1e-9, "not compensated while calibrating")
+
+		_, err = c.AddSelfHeatingReference(ref)
+		require.NoError(t, err)
+	}
+
+	sh := store.Get("kitchen").SelfHeating
+	require.NotNil(t, sh)
+	require.True(t, sh.Fitted, sh.String())
+	assert.InDelta(t, 0.2, sh.K, 1e-9)
+	assert.InDelta(t, 0, sh.B, 1e-9)
+
+	raw.t, soc.t = 24, 60
+	rd, err := c.Read(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, 16.8, rd.Temperature, 1e-9)
+	assert.InDelta(t, 40, rd.Humidity, 1e-9, "humidity isn't compensated")
+	uncompensated, socT := c.Uncompensated()
+	assert.InDelta(t, 24, uncompensated, 1e-9)
+	assert.InDelta(t, 60, socT, 1e-9)
+
+	// samples and the model are persisted
+	reloaded, err := NewCalibrationStore(path)
+	require.NoError(t, err)
+	assert.Equal(t, sh.K, reloaded.Get("kitchen").SelfHeating.K)
+	assert.Len(t, reloaded.Get("kitchen").SelfHeating.Samples, 3)
+
+	require.NoError(t, c.ResetSelfHeating())
+	assert.Nil(t, store.Get("kitchen").SelfHeating)
+}
@@ -0,0 +1,202 @@
+package sensors
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotSupported means the sensor chip can't measure requested quantity
+// (e.g. humidity on BMP280), so the reading is unavailable rather than failed
+var ErrNotSupported = errors.New("reading isn't supported by the sensor")
+
+// Reading is a single measurement of all quantities the sensor has, unsupported ones are NaN.
+// Traces and remote nodes carry temperature, humidity and pressure only.
+type Reading struct {
+	T           time.Time `json:"t"`
+	Temperature float64   `json:"temperature"`
+	Humidity    float64   `json:"humidity"`
+	Pressure    float64   `json:"pressure"`
+	// CO2 is in ppm
+	CO2 float64 `json:"-"`
+	// AirQuality has NaN gas resistance for sensors without gas measurement
+	AirQuality AirQuality `json:"-"`
+}
+
+// NewReading makes a reading taken at t with all quantities unsupported
+func NewReading(t time.Time) Reading {
+	nan := math.NaN()
+
+	return Reading{
+		T:           t,
+		Temperature: nan,
+		Humidity:    nan,
+		Pressure:    nan,
+		CO2:         nan,
+		AirQuality:  AirQuality{GasResistance: nan, IAQ: nan, VOC: nan},
+	}
+}
+
+const (
+	// DefaultBus is the I2C bus exposed on Raspberry Pi GPIO header (/dev/i2c-1)
+	DefaultBus = 1
+	// AddrAuto makes NewBME280 probe all known BMx addresses on the bus
+	AddrAuto uint8 = 0
+)
+
+// ProbeAddrs are the addresses BMx chips can be strapped to (SDO to GND or VDDIO)
+var ProbeAddrs = []uint8{0x76, 0x77}
+
+type Option func(o *Opts)
+
+// Opts are I2C connection settings of the sensor
+type Opts struct {
+	Bus  int
+	Addr uint8
+	// Heater is a gas sensor heater profile, used by BME680 only
+	Heater HeaterProfile
+	// Settings are BMx measurement settings
+	Settings Settings
+}
+
+// WithBus sets I2C bus number, i.e. N in /dev/i2c-N
+func WithBus(bus int) Option {
+	return func(o *Opts) {
+		o.Bus = bus
+	}
+}
+
+// WithAddr sets I2C address of the sensor, AddrAuto means probe ProbeAddrs
+func WithAddr(addr uint8) Option {
+	return func(o *Opts) {
+		o.Addr = addr
+	}
+}
+
+// WithHeater sets gas sensor heater profile
+func WithHeater(h HeaterProfile) Option {
+	return func(o *Opts) {
+		o.Heater = h
+	}
+}
+
+// WithSettings sets BMx measurement settings
+func WithSettings(s Settings) Option {
+	return func(o *Opts) {
+		o.Settings = s
+	}
+}
+
+func makeOpts(opts ...Option) Opts {
+	o := Opts{
+		Bus:      DefaultBus,
+		Addr:     AddrAuto,
+		Heater:   DefaultHeaterProfile,
+		Settings: DefaultSettings,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// addrs returns the list of addresses to try
+func (o Opts) addrs() []uint8 {
+	if o.Addr == AddrAuto {
+		return ProbeAddrs
+	}
+
+	return []uint8{o.Addr}
+}
+
+// Spec describes where a sensor is connected and placed
+type Spec struct {
+	ID     string
+	Room   string
+	Bus    int
+	Addr   uint8
+	Driver string
+	// Path is a sysfs device directory of 1-Wire sensors
+	Path string
+	// Heater is a gas sensor heater profile, zero means the default one
+	Heater HeaterProfile
+	// Settings are BMx measurement settings, zero means DefaultSettings
+	Settings Settings
+}
+
+// Opts returns connection options of the sensor
+func (s Spec) Opts() []Option {
+	opts := []Option{WithBus(s.Bus), WithAddr(s.Addr)}
+	if s.Heater != (HeaterProfile{}) {
+		opts = append(opts, WithHeater(s.Heater))
+	}
+	if s.Settings != (Settings{}) {
+		opts = append(opts, WithSettings(s.Settings))
+	}
+
+	return opts
+}
+
+// ParseSpecs parses comma separated list of sensors in format "id:room:bus:addr[:driver]",
+// e.g. "kitchen:Kitchen:1:0x76,bedroom:Bedroom:3:auto:sim". Empty driver is defaultDriver.
+func ParseSpecs(s, defaultDriver string) ([]Spec, error) {
+	var specs []Spec
+	ids := make(map[string]bool)
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		parts := strings.Split(raw, ":")
+		if len(parts) != 4 && len(parts) != 5 {
+			return nil, fmt.Errorf("invalid sensor spec %q, want id:room:bus:addr[:driver]", raw)
+		}
+
+		driver := defaultDriver
+		if len(parts) == 5 && parts[4] != "" {
+			driver = parts[4]
+		}
+
+		id, room := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if id == "" || room == "" {
+			return nil, fmt.Errorf("invalid sensor spec %q: id and room are required", raw)
+		}
+		if ids[id] {
+			return nil, fmt.Errorf("duplicate sensor id %q", id)
+		}
+		ids[id] = true
+
+		bus, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bus in sensor spec %q: %w", raw, err)
+		}
+
+		addr, err := ParseAddr(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid addr in sensor spec %q: %w", raw, err)
+		}
+
+		specs = append(specs, Spec{ID: id, Room: room, Bus: bus, Addr: addr, Driver: driver})
+	}
+
+	return specs, nil
+}
+
+// ParseAddr parses I2C address like "0x76" or "118", "auto" means AddrAuto
+func ParseAddr(s string) (uint8, error) {
+	if s == "auto" {
+		return AddrAuto, nil
+	}
+
+	addr, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint8(addr), nil
+}
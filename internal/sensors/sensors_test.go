@@ -0,0 +1,57 @@
+package sensors
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpecs(t *testing.T) {
+	specs, err := ParseSpecs("kitchen:Kitchen:1:0x76, bedroom:Bedroom:3:auto:sim", "bme280")
+	require.NoError(t, err)
+	assert.Equal(t, []Spec{
+		{ID: "kitchen", Room: "Kitchen", Bus: 1, Addr: 0x76, Driver: "bme280"},
+		{ID: "bedroom", Room: "Bedroom", Bus: 3, Addr: AddrAuto, Driver: "sim"},
+	}, specs)
+
+	specs, err = ParseSpecs("", "bme280")
+	require.NoError(t, err)
+	assert.Empty(t, specs)
+}
+
+func TestParseSpecsErrors(t *testing.T) {
+	for _, s := range []string{
+		"kitchen:Kitchen:1",
+		"kitchen:Kitchen:1:0x76:sim:extra",
+		":Kitchen:1:0x76",
+		"kitchen:Kitchen:one:0x76",
+		"kitchen:Kitchen:1:0x776",
+		"kitchen:Kitchen:1:0x76,kitchen:Other:1:0x77",
+	} {
+		_, err := ParseSpecs(s, "bme280")
+		assert.Error(t, err, s)
+	}
+}
+
+func TestOptsAddrs(t *testing.T) {
+	assert.Equal(t, ProbeAddrs, makeOpts().addrs())
+	assert.Equal(t, []uint8{0x77}, makeOpts(WithAddr(0x77)).addrs())
+	assert.Equal(t, 3, makeOpts(WithBus(3)).Bus)
+}
+
+func TestOpen(t *testing.T) {
+	assert.Contains(t, Drivers(), "sim")
+
+	s, err := Open(Spec{ID: "lab", Driver: "sim"})
+	require.NoError(t, err)
+	rd, err := s.Read(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, DefaultDiurnal.MeanT, rd.Temperature, DefaultDiurnal.AmpT+DefaultDiurnal.Noise)
+	assert.True(t, math.IsNaN(rd.CO2))
+
+	_, err = Open(Spec{ID: "lab", Driver: "nope"})
+	assert.ErrorContains(t, err, `unknown sensor driver "nope"`)
+}
@@ -0,0 +1,208 @@
+package sensors
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter is IIR filter coefficient of Bosch chips, register values.
+// The filter smooths short disturbances, e.g. a door slam in pressure readings.
+type Filter byte
+
+const (
+	FilterOff Filter = iota
+	Filter2
+	Filter4
+	Filter8
+	Filter16
+)
+
+func (f Filter) String() string {
+	if f == FilterOff {
+		return "off"
+	}
+
+	return "×" + strconv.Itoa(1<<f)
+}
+
+// PowerMode of Bosch chips, register values
+type PowerMode byte
+
+const (
+	// ModeForced runs a single measurement on every read, the chip sleeps in between
+	ModeForced PowerMode = 0x01
+	// ModeNormal measures all the time with Standby pause, reads get the latest measurement
+	ModeNormal PowerMode = 0x03
+)
+
+func (m PowerMode) String() string {
+	switch m {
+	case ModeForced:
+		return "forced"
+	case ModeNormal:
+		return "normal"
+	default:
+		return fmt.Sprintf("mode(%d)", byte(m))
+	}
+}
+
+// Settings are measurement settings of Bosch BMx chips
+type Settings struct {
+	OsrsT, OsrsP, OsrsH Oversampling
+	Filter              Filter
+	Mode                PowerMode
+	// Standby is the pause between measurements in normal mode, supported values depend on the chip
+	Standby time.Duration
+}
+
+// DefaultSettings is the highest resolution with a single forced measurement per read
+var DefaultSettings = Settings{
+	OsrsT: Oversampling16x, OsrsP: Oversampling16x, OsrsH: Oversampling16x,
+	Filter: FilterOff,
+	Mode:   ModeForced,
+}
+
+func (s Settings) String() string {
+	mode := s.Mode.String()
+	if s.Mode == ModeNormal {
+		mode += ", standby " + s.Standby.String()
+	}
+
+	return fmt.Sprintf("%s, oversampling T %s P %s H %s, filter %s", mode, s.OsrsT, s.OsrsP, s.OsrsH, s.Filter)
+}
+
+// validate checks settings any chip can take, see chip drivers for the rest
+func (s Settings) validate() error {
+	if s.OsrsT == OversamplingSkip {
+		return errors.New("temperature can't be skipped, it's needed to compensate other readings")
+	}
+	for _, o := range []Oversampling{s.OsrsT, s.OsrsP, s.OsrsH} {
+		if o > Oversampling16x {
+			return fmt.Errorf("invalid oversampling %d", o)
+		}
+	}
+	if s.Filter > Filter16 {
+		return fmt.Errorf("invalid filter %d", s.Filter)
+	}
+	if s.Mode != ModeForced && s.Mode != ModeNormal {
+		return fmt.Errorf("invalid power mode %d", s.Mode)
+	}
+
+	return nil
+}
+
+// ParseSettings parses comma separated "key:value" list on top of DefaultSettings, e.g.
+// "t:1,p:1,h:1,filter:off,mode:normal,standby:1s". Oversampling is 0 (skip), 1, 2, 4, 8 or 16,
+// filter is off, 2, 4, 8 or 16, mode is forced or normal.
+func ParseSettings(s string) (Settings, error) {
+	settings := DefaultSettings
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(raw, ":")
+		if !ok {
+			return Settings{}, fmt.Errorf("invalid setting %q, want key:value", raw)
+		}
+
+		var err error
+		switch key {
+		case "t":
+			settings.OsrsT, err = parseOversampling(val)
+		case "p":
+			settings.OsrsP, err = parseOversampling(val)
+		case "h":
+			settings.OsrsH, err = parseOversampling(val)
+		case "filter":
+			settings.Filter, err = parseFilter(val)
+		case "mode":
+			settings.Mode, err = parsePowerMode(val)
+		case "standby":
+			settings.Standby, err = time.ParseDuration(val)
+		default:
+			err = errors.New("unknown setting")
+		}
+		if err != nil {
+			return Settings{}, fmt.Errorf("invalid setting %q: %w", raw, err)
+		}
+	}
+
+	if settings.Mode == ModeNormal && settings.Standby <= 0 {
+		return Settings{}, errors.New("normal mode needs standby time")
+	}
+
+	return settings, settings.validate()
+}
+
+// ParseSettingsByID parses "id=settings;..." list of per-sensor settings, "*" is for all other sensors,
+// e.g. "*=mode:normal,standby:1s;kitchen=t:1,p:1,h:1"
+func ParseSettingsByID(s string) (map[string]Settings, error) {
+	settings := make(map[string]Settings)
+	for _, raw := range strings.Split(s, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		id, val, ok := strings.Cut(raw, "=")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid sensor settings %q, want id=settings", raw)
+		}
+		if _, ok := settings[id]; ok {
+			return nil, fmt.Errorf("duplicate settings of sensor %q", id)
+		}
+
+		st, err := ParseSettings(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid settings of sensor %q: %w", id, err)
+		}
+		settings[id] = st
+	}
+
+	return settings, nil
+}
+
+var oversamplings = map[string]Oversampling{
+	"0": OversamplingSkip, "1": Oversampling1x, "2": Oversampling2x,
+	"4": Oversampling4x, "8": Oversampling8x, "16": Oversampling16x,
+}
+
+func parseOversampling(s string) (Oversampling, error) {
+	o, ok := oversamplings[s]
+	if !ok {
+		return 0, errors.New("want 0, 1, 2, 4, 8 or 16")
+	}
+
+	return o, nil
+}
+
+func parseFilter(s string) (Filter, error) {
+	if s == "off" || s == "0" {
+		return FilterOff, nil
+	}
+
+	i := slices.Index([]string{"2", "4", "8", "16"}, s)
+	if i < 0 {
+		return 0, errors.New("want off, 2, 4, 8 or 16")
+	}
+
+	return Filter(i + 1), nil
+}
+
+func parsePowerMode(s string) (PowerMode, error) {
+	switch s {
+	case "forced":
+		return ModeForced, nil
+	case "normal":
+		return ModeNormal, nil
+	default:
+		return 0, errors.New("want forced or normal")
+	}
+}
@@ -0,0 +1,45 @@
+package sensors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSettings(t *testing.T) {
+	s, err := ParseSettings("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSettings, s)
+	assert.Equal(t, "forced, oversampling T ×16 P ×16 H ×16, filter off", s.String())
+
+	s, err = ParseSettings("t:1, p:1,h:0,filter:16,mode:normal,standby:1s")
+	require.NoError(t, err)
+	assert.Equal(t, Settings{
+		OsrsT: Oversampling1x, OsrsP: Oversampling1x, OsrsH: OversamplingSkip,
+		Filter: Filter16, Mode: ModeNormal, Standby: time.Second,
+	}, s)
+	assert.Equal(t, "normal, standby 1s, oversampling T ×1 P ×1 H skip, filter ×16", s.String())
+
+	for _, raw := range []string{"t", "t:3", "t:0", "filter:3", "mode:sleep", "mode:normal", "standby:x", "iir:2"} {
+		_, err := ParseSettings(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestParseSettingsByID(t *testing.T) {
+	settings, err := ParseSettingsByID("*=mode:normal,standby:1s; kitchen=t:1,p:1,h:1")
+	require.NoError(t, err)
+	assert.Equal(t, ModeNormal, settings["*"].Mode)
+	assert.Equal(t, Oversampling1x, settings["kitchen"].OsrsH)
+	assert.Equal(t, ModeForced, settings["kitchen"].Mode)
+
+	for _, raw := range []string{"t:1", "=t:1", "kitchen=t:1;kitchen=p:1", "kitchen=t:5"} {
+		_, err := ParseSettingsByID(raw)
+		assert.Error(t, err, raw)
+	}
+
+	assert.Equal(t, settings["kitchen"], makeOpts(Spec{Settings: settings["kitchen"]}.Opts()...).Settings)
+	assert.Equal(t, DefaultSettings, makeOpts(Spec{}.Opts()...).Settings)
+}
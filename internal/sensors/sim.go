@@ -0,0 +1,327 @@
+package sensors
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrSimulatedIO looks like a real bus failure, so it's handled the same way
+var ErrSimulatedIO = &fs.PathError{Op: "write", Path: "/dev/i2c-sim", Err: syscall.EIO}
+
+func init() {
+	Register("sim", func(_ Spec) (Climate, error) {
+		return NewSimulated(DefaultDiurnal), nil
+	})
+}
+
+// Source produces clean temperature, humidity and pressure readings for the simulated sensor,
+// now is the current time and elapsed is the time since the simulation start
+type Source interface {
+	Reading(now time.Time, elapsed time.Duration) Reading
+}
+
+// Diurnal generates daily sine waves: temperature peaks at Peak (time of day),
+// humidity goes the opposite way, pressure drifts slowly
+type Diurnal struct {
+	MeanT, AmpT float64
+	MeanH, AmpH float64
+	MeanP, AmpP float64
+	Peak        time.Duration
+	// Noise is a max random deviation added to every value
+	Noise float64
+}
+
+// DefaultDiurnal is a room with heating on a calm day
+var DefaultDiurnal = Diurnal{
+	MeanT: 21, AmpT: 2,
+	MeanH: 45, AmpH: 8,
+	MeanP: 1013, AmpP: 3,
+	Peak:  15 * time.Hour,
+	Noise: 0.1,
+}
+
+func (d Diurnal) Reading(now time.Time, _ time.Duration) Reading {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	phase := 2 * math.Pi * (now.Sub(midnight) - d.Peak).Hours() / 24
+	noise := func() float64 {
+		//nolint:gosec // this is a simulation
+		return d.Noise * (2*rand.Float64() - 1)
+	}
+
+	return Reading{
+		T:           now,
+		Temperature: d.MeanT + d.AmpT*math.Cos(phase) + noise(),
+		Humidity:    d.MeanH - d.AmpH*math.Cos(phase) + noise(),
+		// pressure changes with a period of a few days
+		Pressure: d.MeanP + d.AmpP*math.Sin(phase/3) + noise(),
+	}
+}
+
+// Trace replays recorded readings, keeping the intervals between them
+type Trace struct {
+	readings []Reading
+	// Loop starts the trace over when it's finished, otherwise the last reading is kept
+	Loop bool
+}
+
+// NewTrace makes a trace of readings sorted by time
+func NewTrace(readings []Reading) (*Trace, error) {
+	if len(readings) == 0 {
+		return nil, errors.New("empty trace")
+	}
+
+	rs := append([]Reading(nil), readings...)
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].T.Before(rs[j].T)
+	})
+
+	return &Trace{readings: rs}, nil
+}
+
+// LoadTrace reads a trace from .csv or .jsonl file
+func LoadTrace(path string) (*Trace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open trace: %w", err)
+	}
+	defer f.Close()
+
+	var readings []Reading
+	switch ext := filepath.Ext(path); ext {
+	case ".csv":
+		readings, err = ParseCSVTrace(f)
+	case ".jsonl":
+		readings, err = ParseJSONLTrace(f)
+	default:
+		return nil, fmt.Errorf("unknown trace format %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTrace(readings)
+}
+
+// ParseCSVTrace parses lines like "2024-11-29T15:45:56Z,21.5,45.2,1013.2"
+// (RFC 3339 time, temperature, humidity, pressure), the header line is optional
+func ParseCSVTrace(r io.Reader) ([]Reading, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("can't read csv trace: %w", err)
+	}
+
+	var readings []Reading
+	for i, rec := range records {
+		if len(rec) != 4 {
+			return nil, fmt.Errorf("line %d: want 4 fields, got %d", i+1, len(rec))
+		}
+
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(rec[0]))
+		if err != nil {
+			if i == 0 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		vs := make([]float64, 3)
+		for j := range vs {
+			vs[j], err = strconv.ParseFloat(strings.TrimSpace(rec[j+1]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+
+		readings = append(readings, Reading{T: t, Temperature: vs[0], Humidity: vs[1], Pressure: vs[2]})
+	}
+
+	return readings, nil
+}
+
+// ParseJSONLTrace parses lines like {"t":"2024-11-29T15:45:56Z","temperature":21.5,"humidity":45.2,"pressure":1013.2}
+func ParseJSONLTrace(r io.Reader) ([]Reading, error) {
+	var readings []Reading
+	scanner := bufio.NewScanner(r)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var rd Reading
+		if err := json.Unmarshal([]byte(line), &rd); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i, err)
+		}
+		readings = append(readings, rd)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can't read jsonl trace: %w", err)
+	}
+
+	return readings, nil
+}
+
+// Duration is the time between the first and the last readings
+func (tr *Trace) Duration() time.Duration {
+	return tr.readings[len(tr.readings)-1].T.Sub(tr.readings[0].T)
+}
+
+func (tr *Trace) Reading(now time.Time, elapsed time.Duration) Reading {
+	if tr.Loop && tr.Duration() > 0 {
+		elapsed %= tr.Duration()
+	}
+
+	at := tr.readings[0].T.Add(elapsed)
+	// the last reading which is not after the current trace time
+	i := sort.Search(len(tr.readings), func(i int) bool {
+		return tr.readings[i].T.After(at)
+	})
+
+	rd := tr.readings[max(0, i-1)]
+	rd.T = now
+
+	return rd
+}
+
+type FaultKind int
+
+const (
+	// FaultIOError makes reads fail with ErrSimulatedIO
+	FaultIOError FaultKind = iota
+	// FaultStuck freezes readings at the last value before the fault
+	FaultStuck
+	// FaultSpike adds Fault.Spike to temperature
+	FaultSpike
+	// FaultLatency makes reads slow by Fault.Latency
+	FaultLatency
+)
+
+// Fault is active from From till To since the simulation start.
+// If Every is set, the window repeats with this period.
+type Fault struct {
+	Kind     FaultKind
+	From, To time.Duration
+	Every    time.Duration
+
+	Spike   float64
+	Latency time.Duration
+}
+
+func (f Fault) active(elapsed time.Duration) bool {
+	if f.Every > 0 {
+		elapsed %= f.Every
+	}
+
+	return elapsed >= f.From && elapsed < f.To
+}
+
+// Simulated is a climate sensor for tests and demos on any platform
+type Simulated struct {
+	source Source
+	faults []Fault
+
+	now   func() time.Time
+	sleep func(time.Duration)
+	start time.Time
+
+	reads *reader
+
+	mu   sync.Mutex
+	last *Reading
+}
+
+type SimOption func(s *Simulated)
+
+// WithFaults sets the fault schedule
+func WithFaults(faults ...Fault) SimOption {
+	return func(s *Simulated) {
+		s.faults = append(s.faults, faults...)
+	}
+}
+
+// WithClock replaces time.Now and time.Sleep, e.g. to run deterministic tests
+func WithClock(now func() time.Time, sleep func(time.Duration)) SimOption {
+	return func(s *Simulated) {
+		s.now, s.sleep = now, sleep
+	}
+}
+
+func NewSimulated(source Source, opts ...SimOption) *Simulated {
+	s := &Simulated{
+		source: source,
+		now:    time.Now,
+		sleep:  time.Sleep,
+		reads:  newReader(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.start = s.now()
+
+	return s
+}
+
+// Read returns a reading with all active faults applied, latency faults are cut by ctx
+func (s *Simulated) Read(ctx context.Context) (Reading, error) {
+	return s.reads.read(ctx, s.read)
+}
+
+// Info describes the sensor for diagnostics, it has no chip
+func (s *Simulated) Info() Info {
+	return Info{Chip: "simulated"}
+}
+
+func (s *Simulated) read() (Reading, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	elapsed := now.Sub(s.start)
+	src := s.source.Reading(now, elapsed)
+	rd := NewReading(src.T)
+	rd.Temperature, rd.Humidity, rd.Pressure = src.Temperature, src.Humidity, src.Pressure
+
+	var stuck bool
+	for _, f := range s.faults {
+		if !f.active(elapsed) {
+			continue
+		}
+
+		switch f.Kind {
+		case FaultIOError:
+			return Reading{}, ErrSimulatedIO
+		case FaultStuck:
+			stuck = true
+		case FaultSpike:
+			rd.Temperature += f.Spike
+		case FaultLatency:
+			s.sleep(f.Latency)
+		}
+	}
+
+	if stuck && s.last != nil {
+		rd = *s.last
+		rd.T = now
+	} else if !stuck {
+		s.last = &rd
+	}
+
+	return rd, nil
+}
@@ -0,0 +1,135 @@
+package sensors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const csvTrace = `time,temperature,humidity,pressure
+2024-11-29T15:00:00Z,21.0,40.0,1010.0
+2024-11-29T15:05:00Z,22.0,41.0,1011.0
+2024-11-29T15:10:00Z,23.0,42.0,1012.0
+`
+
+// readTemperature takes a reading and returns its temperature
+func readTemperature(c Climate) (float64, error) {
+	rd, err := c.Read(context.Background())
+	return rd.Temperature, err
+}
+
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) { c.slept += d }
+
+func TestTraceReplay(t *testing.T) {
+	readings, err := ParseCSVTrace(strings.NewReader(csvTrace))
+	require.NoError(t, err)
+	require.Len(t, readings, 3)
+
+	tr, err := NewTrace(readings)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, tr.Duration())
+
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sim := NewSimulated(tr, WithClock(clock.Now, clock.Sleep))
+
+	temp, err := readTemperature(sim)
+	require.NoError(t, err)
+	assert.InDelta(t, 21.0, temp, 1e-9)
+
+	clock.now = clock.now.Add(6 * time.Minute)
+	temp, _ = readTemperature(sim)
+	assert.InDelta(t, 22.0, temp, 1e-9)
+
+	// the last reading is kept after the end
+	clock.now = clock.now.Add(time.Hour)
+	temp, _ = readTemperature(sim)
+	assert.InDelta(t, 23.0, temp, 1e-9)
+
+	// or the trace starts over: 66m is 6m of the 10m trace
+	tr.Loop = true
+	temp, _ = readTemperature(sim)
+	assert.InDelta(t, 22.0, temp, 1e-9)
+}
+
+func TestLoadTraceJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(
+		`{"t":"2024-11-29T15:05:00Z","temperature":22,"humidity":41,"pressure":1011}`+"\n\n"+
+			`{"t":"2024-11-29T15:00:00Z","temperature":21,"humidity":40,"pressure":1010}`+"\n",
+	), 0o600))
+
+	tr, err := LoadTrace(path)
+	require.NoError(t, err)
+
+	rd := tr.Reading(time.Now(), 0)
+	assert.InDelta(t, 21.0, rd.Temperature, 1e-9, "readings are sorted")
+	assert.InDelta(t, 1010.0, rd.Pressure, 1e-9)
+
+	_, err = LoadTrace(filepath.Join(t.TempDir(), "trace.txt"))
+	assert.Error(t, err)
+}
+
+func TestSimulatedFaults(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	source := Diurnal{MeanT: 20, AmpT: 0, MeanH: 50, MeanP: 1000}
+	sim := NewSimulated(source, WithClock(clock.Now, clock.Sleep), WithFaults(
+		Fault{Kind: FaultIOError, From: 10 * time.Minute, To: 12 * time.Minute, Every: time.Hour},
+		Fault{Kind: FaultSpike, From: 20 * time.Minute, To: 21 * time.Minute, Spike: 40},
+		Fault{Kind: FaultLatency, From: 30 * time.Minute, To: 31 * time.Minute, Latency: 5 * time.Second},
+	))
+
+	at := func(d time.Duration) (float64, error) {
+		clock.now = sim.start.Add(d)
+		rd, err := sim.Read(context.Background())
+		return rd.Temperature, err
+	}
+
+	temp, err := at(0)
+	require.NoError(t, err)
+	assert.InDelta(t, 20, temp, 1e-9)
+
+	_, err = at(11 * time.Minute)
+	require.ErrorIs(t, err, ErrSimulatedIO)
+	assert.True(t, IsIOError(err))
+
+	_, err = at(time.Hour + 11*time.Minute)
+	assert.ErrorIs(t, err, ErrSimulatedIO, "fault repeats every hour")
+
+	temp, _ = at(20 * time.Minute)
+	assert.InDelta(t, 60, temp, 1e-9)
+
+	_, _ = at(30 * time.Minute)
+	assert.Equal(t, 5*time.Second, clock.slept)
+}
+
+func TestSimulatedStuck(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	readings, err := ParseCSVTrace(strings.NewReader(csvTrace))
+	require.NoError(t, err)
+	tr, err := NewTrace(readings)
+	require.NoError(t, err)
+
+	sim := NewSimulated(tr, WithClock(clock.Now, clock.Sleep), WithFaults(
+		Fault{Kind: FaultStuck, From: 4 * time.Minute, To: time.Hour},
+	))
+
+	temp, _ := readTemperature(sim)
+	assert.InDelta(t, 21.0, temp, 1e-9)
+
+	clock.now = clock.now.Add(8 * time.Minute)
+	temp, _ = readTemperature(sim)
+	assert.InDelta(t, 21.0, temp, 1e-9, "value is stuck")
+}
@@ -0,0 +1,177 @@
+package sensors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultW1Root is where w1-gpio kernel driver exposes 1-Wire devices
+const DefaultW1Root = "/sys/bus/w1/devices"
+
+// ds18b20Family is 1-Wire family code of DS18B20 (and compatible) probes
+const ds18b20Family = "28"
+
+// ds18b20ResetRaw is the power-on value of the temperature register (85 °C),
+// it's read when the probe lost power during the conversion
+const ds18b20ResetRaw = 0x0550
+
+func init() {
+	Register("ds18b20", func(spec Spec) (Climate, error) {
+		return NewDS18B20(spec.Path)
+	})
+}
+
+// DiscoverDS18B20 enumerates DS18B20 probes under 1-Wire sysfs root and makes a spec for each one.
+// The probe serial is the sensor ID, rooms maps serials to room names, unnamed probes use the serial.
+// No root directory means 1-Wire is disabled, it's not an error.
+func DiscoverDS18B20(root string, rooms map[string]string) ([]Spec, error) {
+	paths, err := filepath.Glob(filepath.Join(root, ds18b20Family+"-*"))
+	if err != nil {
+		return nil, fmt.Errorf("can't list 1-Wire devices: %w", err)
+	}
+	sort.Strings(paths)
+
+	specs := make([]Spec, 0, len(paths))
+	for _, path := range paths {
+		serial := filepath.Base(path)
+		room := rooms[serial]
+		if room == "" {
+			room = serial
+		}
+		specs = append(specs, Spec{ID: serial, Room: room, Driver: "ds18b20", Path: path})
+	}
+
+	return specs, nil
+}
+
+// ParseW1Rooms parses "serial:room,..." list of 1-Wire probe names
+func ParseW1Rooms(s string) (map[string]string, error) {
+	rooms := make(map[string]string)
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		serial, room, ok := strings.Cut(raw, ":")
+		serial, room = strings.TrimSpace(serial), strings.TrimSpace(room)
+		if !ok || serial == "" || room == "" {
+			return nil, fmt.Errorf("invalid 1-Wire probe %q, want serial:room", raw)
+		}
+		rooms[serial] = room
+	}
+
+	return rooms, nil
+}
+
+// DS18B20 is a 1-Wire temperature probe read via w1_therm sysfs interface
+type DS18B20 struct {
+	path  string
+	reads *reader
+}
+
+func NewDS18B20(path string) (*DS18B20, error) {
+	if path == "" {
+		return nil, errors.New("1-Wire device path is required")
+	}
+	if _, err := os.Stat(filepath.Join(path, "w1_slave")); err != nil {
+		return nil, fmt.Errorf("can't find DS18B20 probe: %w", err)
+	}
+
+	return &DS18B20{path: path, reads: newReader()}, nil
+}
+
+// Read reads w1_slave, the kernel starts a conversion on every read, it takes up to 750ms.
+// The probe measures temperature only.
+func (d *DS18B20) Read(ctx context.Context) (Reading, error) {
+	return d.reads.read(ctx, func() (Reading, error) {
+		data, err := os.ReadFile(filepath.Join(d.path, "w1_slave"))
+		if err != nil {
+			return Reading{}, err
+		}
+
+		t, err := parseW1Slave(string(data))
+		if err != nil {
+			return Reading{}, err
+		}
+
+		rd := NewReading(time.Now())
+		rd.Temperature = t
+
+		return rd, nil
+	})
+}
+
+// Info describes the probe, its serial is the name of the sysfs device directory
+func (d *DS18B20) Info() Info {
+	return Info{Chip: "DS18B20", ID: filepath.Base(d.path), Addr: d.path}
+}
+
+// parseW1Slave parses w1_therm output and checks scratchpad CRC, e.g.:
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+func parseW1Slave(s string) (float64, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) != 2 {
+		return 0, fmt.Errorf("invalid w1_slave: want 2 lines, got %d", len(lines))
+	}
+
+	fields := strings.Fields(lines[0])
+	if len(fields) != 12 {
+		return 0, fmt.Errorf("invalid w1_slave scratchpad: %q", lines[0])
+	}
+
+	scratchpad := make([]byte, 9)
+	for i := range scratchpad {
+		b, err := strconv.ParseUint(fields[i], 16, 8)
+		if err != nil {
+			return 0, fmt.Errorf("invalid w1_slave scratchpad: %w", err)
+		}
+		scratchpad[i] = byte(b)
+	}
+
+	if crc8(scratchpad) != 0 || fields[11] != "YES" {
+		return 0, fmt.Errorf("w1_slave CRC mismatch: %q", lines[0])
+	}
+
+	if raw := uint16(scratchpad[1])<<8 | uint16(scratchpad[0]); raw == ds18b20ResetRaw {
+		return 0, errors.New("DS18B20 returned power-on reset value")
+	}
+
+	_, milli, ok := strings.Cut(lines[1], "t=")
+	if !ok {
+		return 0, fmt.Errorf("no temperature in w1_slave: %q", lines[1])
+	}
+
+	t, err := strconv.Atoi(strings.TrimSpace(milli))
+	if err != nil {
+		return 0, fmt.Errorf("invalid w1_slave temperature: %w", err)
+	}
+
+	return float64(t) / 1000, nil
+}
+
+// crc8 is Dallas/Maxim 1-Wire CRC (x^8 + x^5 + x^4 + 1), data with its CRC gives 0
+func crc8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		for range 8 {
+			mix := (crc ^ b) & 0x01
+			crc >>= 1
+			if mix != 0 {
+				crc ^= 0x8C
+			}
+			b >>= 1
+		}
+	}
+
+	return crc
+}
@@ -0,0 +1,81 @@
+package sensors
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeW1Probe(t *testing.T, root, serial, data string) {
+	t.Helper()
+
+	dir := filepath.Join(root, serial)
+	require.NoError(t, os.MkdirAll(dir, 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "w1_slave"), []byte(data), 0o600))
+}
+
+func TestParseW1Slave(t *testing.T) {
+	temp, err := parseW1Slave("72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+	require.NoError(t, err)
+	assert.InDelta(t, 23.125, temp, 1e-9)
+
+	// negative temperature
+	temp, err = parseW1Slave("5e ff 4b 46 7f ff 02 10 b6 : crc=b6 YES\n5e ff 4b 46 7f ff 02 10 b6 t=-10125\n")
+	require.NoError(t, err)
+	assert.InDelta(t, -10.125, temp, 1e-9)
+
+	for name, s := range map[string]string{
+		"bad crc":     "72 01 4b 46 7f ff 0e 10 58 : crc=58 YES\n72 01 4b 46 7f ff 0e 10 58 t=23125\n",
+		"kernel NO":   "72 01 4b 46 7f ff 0e 10 57 : crc=57 NO\n72 01 4b 46 7f ff 0e 10 57 t=23125\n",
+		"reset value": "50 05 4b 46 7f ff 0c 10 1c : crc=1c YES\n50 05 4b 46 7f ff 0c 10 1c t=85000\n",
+		"no t":        "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57\n",
+		"one line":    "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n",
+		"empty":       "",
+	} {
+		_, err := parseW1Slave(s)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestDiscoverDS18B20(t *testing.T) {
+	root := t.TempDir()
+	writeW1Probe(t, root, "28-0316a2794aff", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+	writeW1Probe(t, root, "28-00000a1b2c3d", "5e ff 4b 46 7f ff 02 10 b6 : crc=b6 YES\n5e ff 4b 46 7f ff 02 10 b6 t=-10125\n")
+	// bus master and other families are ignored
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "w1_bus_master1"), 0o750))
+	writeW1Probe(t, root, "10-000802b4bd39", "")
+
+	rooms, err := ParseW1Rooms("28-00000a1b2c3d:Outdoor")
+	require.NoError(t, err)
+
+	specs, err := DiscoverDS18B20(root, rooms)
+	require.NoError(t, err)
+	assert.Equal(t, []Spec{
+		{ID: "28-00000a1b2c3d", Room: "Outdoor", Driver: "ds18b20", Path: filepath.Join(root, "28-00000a1b2c3d")},
+		{ID: "28-0316a2794aff", Room: "28-0316a2794aff", Driver: "ds18b20", Path: filepath.Join(root, "28-0316a2794aff")},
+	}, specs)
+
+	s, err := Open(specs[0])
+	require.NoError(t, err)
+	rd, err := s.Read(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, -10.125, rd.Temperature, 1e-9)
+	assert.True(t, math.IsNaN(rd.Humidity))
+	assert.True(t, math.IsNaN(rd.Pressure))
+
+	// no 1-Wire at all
+	specs, err = DiscoverDS18B20(filepath.Join(root, "nope"), nil)
+	require.NoError(t, err)
+	assert.Empty(t, specs)
+
+	_, err = NewDS18B20(filepath.Join(root, "28-ffffffffffff"))
+	assert.Error(t, err)
+
+	_, err = ParseW1Rooms("28-00000a1b2c3d")
+	assert.Error(t, err)
+}
@@ -0,0 +1,30 @@
+package srv
+
+import (
+	"github.com/egregors/hk/log"
+)
+
+// listenBrokerEvents drives USB power by broker commands and keeps HomeKit switch in sync
+func (s *Server) listenBrokerEvents() {
+	for v := range s.broker.USB2PowerChan() {
+		log.Debg.Printf("got %v from broker", v)
+		if s.setUSB2Power(v) {
+			s.hkSrv.SetUSB2Power(v)
+		}
+	}
+}
+
+// publish sends the sensor status and, if it's online, the last readings to the broker
+func (s *Server) publish(st *sensorState) {
+	if s.broker == nil {
+		return
+	}
+
+	s.broker.PublishStatus(st.ID, st.status)
+	if st.status != ONLINE {
+		return
+	}
+	for key, val := range st.readings() {
+		s.broker.PublishReading(st.ID, key, val)
+	}
+}
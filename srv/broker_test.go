@@ -0,0 +1,162 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egregors/hk/internal/homekit"
+	"github.com/egregors/hk/internal/sensors"
+)
+
+type fakeBroker struct {
+	mu       sync.Mutex
+	readings map[string]float64
+	statuses map[string]string
+	power    []bool
+	ch       chan bool
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		readings: make(map[string]float64),
+		statuses: make(map[string]string),
+		ch:       make(chan bool),
+	}
+}
+
+func (b *fakeBroker) PublishReading(sensorID, quantity string, val float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.readings[sensorID+"/"+quantity] = val
+}
+
+func (b *fakeBroker) PublishStatus(sensorID, status string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.statuses[sensorID] = status
+}
+
+func (b *fakeBroker) PublishUSB2Power(on bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.power = append(b.power, on)
+}
+
+func (b *fakeBroker) USB2PowerChan() chan bool { return b.ch }
+
+func (b *fakeBroker) published() []bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]bool(nil), b.power...)
+}
+
+type fakeHap struct {
+	homekit.NoopHap
+	ch chan bool
+
+	mu sync.Mutex
+	on []bool
+}
+
+func (h *fakeHap) SetUSB2Power(on bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.on = append(h.on, on)
+}
+
+func (h *fakeHap) USB2PowerChan() chan bool { return h.ch }
+
+func (h *fakeHap) switched() []bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]bool(nil), h.on...)
+}
+
+type fakePower struct {
+	err error
+	on  []bool
+}
+
+func (p *fakePower) On() error {
+	p.on = append(p.on, true)
+	return p.err
+}
+
+func (p *fakePower) Off() error {
+	p.on = append(p.on, false)
+	return p.err
+}
+
+func TestPublishReadings(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	sim := sensors.NewSimulated(
+		sensors.Diurnal{MeanT: 20, MeanH: 50, MeanP: 1000},
+		sensors.WithClock(func() time.Time { return now }, func(time.Duration) {}),
+		sensors.WithFaults(sensors.Fault{Kind: sensors.FaultIOError, From: 10 * time.Minute, To: time.Hour}),
+	)
+
+	b := newFakeBroker()
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	server := New(nil, []Sensor{{ID: "sim", Room: "Lab", Climate: sim}}, nil, nil, m, nil, b, nil)
+	st := server.sensors[0]
+
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, ONLINE, b.statuses["sim"])
+	assert.InDelta(t, 20, b.readings["sim/current_temperature"], 1e-9)
+	assert.InDelta(t, 50, b.readings["sim/current_humidity"], 1e-9)
+	assert.InDelta(t, 1000, b.readings["sim/current_pressure"], 1e-9)
+	assert.Contains(t, b.readings, "sim/dew_point")
+	// the sensor has no CO2 and gas measurement
+	assert.NotContains(t, b.readings, "sim/current_co2")
+	assert.NotContains(t, b.readings, "sim/iaq")
+
+	// readings are published as they are gauged
+	for key, vals := range m.gauges {
+		assert.Contains(t, b.readings, key)
+		assert.InDelta(t, vals[len(vals)-1], b.readings[key], 1e-9, key)
+	}
+
+	// offline sensor keeps the last readings retained
+	now = now.Add(15 * time.Minute)
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, OFFLINE, b.statuses["sim"])
+	assert.InDelta(t, 20, b.readings["sim/current_temperature"], 1e-9)
+}
+
+func TestUSB2PowerSync(t *testing.T) {
+	b := newFakeBroker()
+	hk := &fakeHap{ch: make(chan bool)}
+	power := &fakePower{}
+	server := New(nil, nil, power, hk, nil, nil, b, nil)
+
+	go server.listenBrokerEvents()
+	go server.listenHapEvents()
+
+	// broker command drives the power and the HomeKit switch
+	b.ch <- true
+	require.Eventually(t, func() bool { return len(b.published()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, []bool{true}, power.on)
+	assert.Equal(t, []bool{true}, hk.switched())
+
+	// HomeKit switch is mirrored to the broker
+	hk.ch <- false
+	require.Eventually(t, func() bool { return len(b.published()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []bool{true, false}, power.on)
+	assert.Equal(t, []bool{true, false}, b.published())
+	assert.Equal(t, []bool{true}, hk.switched(), "HomeKit knows its own state")
+
+	// failed power switch isn't mirrored anywhere
+	power.err = errors.New("uhubctl failed")
+	b.ch <- false
+	b.ch <- true // wait for the previous command to be handled
+	assert.Equal(t, []bool{true, false}, b.published())
+	assert.Equal(t, []bool{true}, hk.switched())
+}
@@ -0,0 +1,274 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/egregors/hk/internal/sensors"
+	"github.com/egregors/hk/log"
+)
+
+const (
+	rawTemperatureKey           = "raw_temperature"
+	rawHumidityKey              = "raw_humidity"
+	uncompensatedTemperatureKey = "uncompensated_temperature"
+)
+
+// Calibrator is implemented by sensors with linear calibration
+type Calibrator interface {
+	Raw() (t, h float64)
+	Calibration() sensors.Calibration
+	SetCalibration(c sensors.Calibration) error
+	CalibrateReference(refT, refH float64) (sensors.Calibration, error)
+}
+
+// SelfHeatingCalibrator is implemented by calibrated sensors with self-heating compensation
+type SelfHeatingCalibrator interface {
+	SelfHeatingEnabled() bool
+	Uncompensated() (t, soc float64)
+	AddSelfHeatingReference(refT float64) (sensors.SelfHeating, error)
+	ResetSelfHeating() error
+}
+
+// gaugeRaw keeps raw readings of calibrated sensors measured at t, so history can be recalibrated later
+// and compared with calibrated values side by side
+func (s *Server) gaugeRaw(st *sensorState, at time.Time) {
+	c, ok := st.Climate.(Calibrator)
+	if !ok {
+		return
+	}
+
+	t, h := c.Raw()
+	if !math.IsNaN(t) {
+		s.metrics.GaugeAt(st.key(rawTemperatureKey), t, at)
+	}
+	if !math.IsNaN(h) {
+		s.metrics.GaugeAt(st.key(rawHumidityKey), h, at)
+	}
+
+	if sh, ok := st.Climate.(SelfHeatingCalibrator); ok {
+		if t, _ := sh.Uncompensated(); !math.IsNaN(t) {
+			s.metrics.GaugeAt(st.key(uncompensatedTemperatureKey), t, at)
+		}
+	}
+}
+
+func (s *Server) calibrator(w http.ResponseWriter, r *http.Request) (*sensorState, Calibrator, bool) {
+	id := r.FormValue("sensor")
+	for _, st := range s.sensors {
+		if st.ID != id {
+			continue
+		}
+
+		c, ok := st.Climate.(Calibrator)
+		if !ok {
+			http.Error(w, fmt.Sprintf("sensor %q doesn't support calibration", id), http.StatusBadRequest)
+			return nil, nil, false
+		}
+
+		return st, c, true
+	}
+
+	http.Error(w, fmt.Sprintf("sensor %q not found", id), http.StatusNotFound)
+
+	return nil, nil, false
+}
+
+// handleCalibrationShow shows calibrations and last raw readings of all sensors
+func (s *Server) handleCalibrationShow(w http.ResponseWriter, _ *http.Request) {
+	var builder strings.Builder
+	for _, st := range s.sensors {
+		c, ok := st.Climate.(Calibrator)
+		if !ok {
+			continue
+		}
+		builder.WriteString(renderCalibration(st, c))
+	}
+
+	builder.WriteString("\nPOST /calibration sensor=<id> [t_offset t_gain h_offset h_gain]\n")
+	builder.WriteString("POST /calibration/reference sensor=<id> [t h]\n")
+	builder.WriteString("POST /calibration/co2 sensor=<id> ppm=<reference>\n")
+	builder.WriteString("POST /calibration/selfheating sensor=<id> [t=<reference> | reset=true]\n")
+
+	_, _ = fmt.Fprint(w, builder.String())
+}
+
+// handleCalibrationSet updates offsets and gains, missing values are kept
+func (s *Server) handleCalibrationSet(w http.ResponseWriter, r *http.Request) {
+	st, c, ok := s.calibrator(w, r)
+	if !ok {
+		return
+	}
+
+	cal := c.Calibration()
+	for name, v := range map[string]*float64{
+		"t_offset": &cal.Temperature.Offset,
+		"t_gain":   &cal.Temperature.Gain,
+		"h_offset": &cal.Humidity.Offset,
+		"h_gain":   &cal.Humidity.Gain,
+	} {
+		raw := r.FormValue(name)
+		if raw == "" {
+			continue
+		}
+
+		f, err := parseFinite(raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s: %s", name, err.Error()), http.StatusBadRequest)
+			return
+		}
+		*v = f
+	}
+
+	if err := c.SetCalibration(cal); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Info.Printf("calibration of %q updated: %+v", st.Room, cal)
+
+	_, _ = fmt.Fprint(w, renderCalibration(st, c))
+}
+
+// handleCalibrationReference computes offsets from reference readings of a trusted thermometer
+func (s *Server) handleCalibrationReference(w http.ResponseWriter, r *http.Request) {
+	st, c, ok := s.calibrator(w, r)
+	if !ok {
+		return
+	}
+
+	refT, refH := math.NaN(), math.NaN()
+	for name, v := range map[string]*float64{"t": &refT, "h": &refH} {
+		raw := r.FormValue(name)
+		if raw == "" {
+			continue
+		}
+
+		f, err := parseFinite(raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s: %s", name, err.Error()), http.StatusBadRequest)
+			return
+		}
+		*v = f
+	}
+
+	cal, err := c.CalibrateReference(refT, refH)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Info.Printf("calibration of %q updated by reference T %.2f H %.2f: %+v", st.Room, refT, refH, cal)
+
+	_, _ = fmt.Fprint(w, renderCalibration(st, c))
+}
+
+// handleCalibrationCO2 runs forced recalibration of CO2 sensor to a reference concentration
+func (s *Server) handleCalibrationCO2(w http.ResponseWriter, r *http.Request) {
+	st, _, ok := s.calibrator(w, r)
+	if !ok {
+		return
+	}
+
+	c, ok := st.Climate.(sensors.CO2Calibrator)
+	if !ok {
+		http.Error(w, fmt.Sprintf("sensor %q doesn't measure CO2", st.ID), http.StatusBadRequest)
+		return
+	}
+
+	ref, err := parseFinite(r.FormValue("ppm"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ppm: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	correction, err := c.CalibrateCO2(ref)
+	if errors.Is(err, sensors.ErrNotSupported) {
+		http.Error(w, fmt.Sprintf("sensor %q doesn't measure CO2", st.ID), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Info.Printf("CO2 of %q recalibrated to %.0f ppm, correction %+.0f ppm", st.Room, ref, correction)
+
+	_, _ = fmt.Fprintf(w, "[ %s ] sensor=%s\nCO2 correction %+.0f ppm\n", st.Room, st.ID, correction)
+}
+
+// handleCalibrationSelfHeating adds a reference reading to self-heating compensation samples,
+// or drops the model with reset=true
+func (s *Server) handleCalibrationSelfHeating(w http.ResponseWriter, r *http.Request) {
+	st, c, ok := s.calibrator(w, r)
+	if !ok {
+		return
+	}
+
+	sh, ok := st.Climate.(SelfHeatingCalibrator)
+	if !ok || !sh.SelfHeatingEnabled() {
+		http.Error(w, fmt.Sprintf("sensor %q doesn't support self-heating compensation", st.ID), http.StatusBadRequest)
+		return
+	}
+
+	if r.FormValue("reset") == "true" {
+		if err := sh.ResetSelfHeating(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Info.Printf("self-heating compensation of %q is reset", st.Room)
+		_, _ = fmt.Fprint(w, renderCalibration(st, c))
+
+		return
+	}
+
+	ref, err := parseFinite(r.FormValue("t"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid t: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	model, err := sh.AddSelfHeatingReference(ref)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Info.Printf("self-heating reference T %.2f of %q added: %s", ref, st.Room, model.String())
+
+	_, _ = fmt.Fprint(w, renderCalibration(st, c))
+}
+
+func renderCalibration(st *sensorState, c Calibrator) string {
+	cal := c.Calibration()
+	t, h := c.Raw()
+
+	out := fmt.Sprintf(
+		"[ %s ] sensor=%s\nT = raw * %.4f %+.2f (raw %s)\nH = raw * %.4f %+.2f (raw %s)\n",
+		st.Room, st.ID,
+		cal.Temperature.Gain, cal.Temperature.Offset, fmtReading("%0.2f °C", t),
+		cal.Humidity.Gain, cal.Humidity.Offset, fmtReading("%0.2f %%", h),
+	)
+
+	if sh, ok := st.Climate.(SelfHeatingCalibrator); ok && sh.SelfHeatingEnabled() {
+		t, soc := sh.Uncompensated()
+		out += fmt.Sprintf("Self-heating: %s (uncompensated %s, SoC %s)\n",
+			cal.SelfHeating, fmtReading("%0.2f °C", t), fmtReading("%0.2f °C", soc))
+	}
+
+	return out
+}
+
+// parseFinite parses a number of a form, NaN and infinities would poison calibrations
+func parseFinite(raw string) (float64, error) {
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%q isn't a finite number", raw)
+	}
+
+	return f, nil
+}
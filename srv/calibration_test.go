@@ -0,0 +1,182 @@
+package srv
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egregors/hk/internal/sensors"
+)
+
+func TestCalibrationHandlers(t *testing.T) {
+	store, err := sensors.NewCalibrationStore(filepath.Join(t.TempDir(), "calibration.json"))
+	require.NoError(t, err)
+
+	calibrated := sensors.NewCalibrated(&recoveringClimate{}, "kitchen", store)
+	server := &Server{
+		sensors: []*sensorState{newSensorState(Sensor{ID: "kitchen", Room: "Kitchen", Climate: calibrated})},
+	}
+
+	post := func(handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/calibration", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		return rec
+	}
+
+	rec := post(server.handleCalibrationSet, url.Values{"sensor": {"kitchen"}, "t_offset": {"-1.5"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "T = raw * 1.0000 -1.50 (raw n/a)")
+	assert.InDelta(t, -1.5, store.Get("kitchen").Temperature.Offset, 1e-9)
+
+	rec = post(server.handleCalibrationSet, url.Values{"sensor": {"kitchen"}, "t_gain": {"abc"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	for _, v := range []string{"NaN", "Inf", "-inf"} {
+		rec = post(server.handleCalibrationSet, url.Values{"sensor": {"kitchen"}, "t_gain": {v}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code, v)
+		rec = post(server.handleCalibrationReference, url.Values{"sensor": {"kitchen"}, "t": {v}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code, v)
+	}
+	assert.InDelta(t, 1, store.Get("kitchen").Temperature.Gain, 1e-9)
+
+	rec = post(server.handleCalibrationSet, url.Values{"sensor": {"bedroom"}})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// reference needs a raw reading first
+	rec = post(server.handleCalibrationReference, url.Values{"sensor": {"kitchen"}, "t": {"21"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	_, err = calibrated.Read(context.Background())
+	require.NoError(t, err)
+	rec = post(server.handleCalibrationReference, url.Values{"sensor": {"kitchen"}, "t": {"21"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.InDelta(t, 21, store.Get("kitchen").Temperature.Offset, 1e-9)
+
+	rec = httptest.NewRecorder()
+	server.handleCalibrationShow(rec, httptest.NewRequest(http.MethodGet, "/calibration", nil))
+	assert.Contains(t, rec.Body.String(), "[ Kitchen ] sensor=kitchen")
+}
+
+type co2Climate struct {
+	recoveringClimate
+	co2, ref float64
+}
+
+func (c *co2Climate) Read(ctx context.Context) (sensors.Reading, error) {
+	rd, err := c.recoveringClimate.Read(ctx)
+	rd.CO2 = c.co2
+
+	return rd, err
+}
+
+func (c *co2Climate) CalibrateCO2(ref float64) (float64, error) {
+	c.ref = ref
+	return ref - c.co2, nil
+}
+
+func TestCalibrationCO2Handler(t *testing.T) {
+	store, err := sensors.NewCalibrationStore(filepath.Join(t.TempDir(), "calibration.json"))
+	require.NoError(t, err)
+
+	sensor := &co2Climate{co2: 470}
+	server := &Server{
+		sensors: []*sensorState{
+			newSensorState(Sensor{ID: "office", Room: "Office", Climate: sensors.NewCalibrated(sensor, "office", store)}),
+			newSensorState(Sensor{ID: "kitchen", Room: "Kitchen", Climate: sensors.NewCalibrated(&recoveringClimate{}, "kitchen", store)}),
+		},
+	}
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/calibration/co2", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		server.handleCalibrationCO2(rec, req)
+
+		return rec
+	}
+
+	rec := post(url.Values{"sensor": {"office"}, "ppm": {"420"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "[ Office ] sensor=office\nCO2 correction -50 ppm\n", rec.Body.String())
+	assert.InDelta(t, 420, sensor.ref, 1e-9)
+
+	rec = post(url.Values{"sensor": {"office"}, "ppm": {"fresh"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = post(url.Values{"sensor": {"office"}, "ppm": {"NaN"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.InDelta(t, 420, sensor.ref, 1e-9, "the sensor isn't calibrated")
+
+	rec = post(url.Values{"sensor": {"kitchen"}, "ppm": {"420"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `sensor "kitchen" doesn't measure CO2`)
+}
+
+func TestCalibrationSelfHeatingHandler(t *testing.T) {
+	store, err := sensors.NewCalibrationStore(filepath.Join(t.TempDir(), "calibration.json"))
+	require.NoError(t, err)
+
+	// the sensor reads 0.1 °C more per degree of SoC to sensor difference, the room is 23 °C
+	soc := &seqClimate{temps: []float64{45, 56, 67, 40}}
+	calibrated := sensors.NewCalibrated(&seqClimate{temps: []float64{25, 26, 27, 24}}, "kitchen", store, sensors.WithSoC(soc))
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	server := New(nil, []Sensor{
+		{ID: "kitchen", Room: "Kitchen", Climate: calibrated},
+		{ID: "bedroom", Room: "Bedroom", Climate: sensors.NewCalibrated(&recoveringClimate{}, "bedroom", store)},
+	}, nil, nil, m, nil, nil, nil)
+	st := server.sensors[0]
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/calibration/selfheating", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		server.handleCalibrationSelfHeating(rec, req)
+
+		return rec
+	}
+
+	rec := post(url.Values{"sensor": {"kitchen"}, "t": {"23"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "no readings yet")
+
+	rec = post(url.Values{"sensor": {"bedroom"}, "t": {"23"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `sensor "bedroom" doesn't support self-heating compensation`)
+
+	for range 3 {
+		server.pullDataFromSensor(context.Background(), st)
+		rec = post(url.Values{"sensor": {"kitchen"}, "t": {"23"}})
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	assert.Contains(t, rec.Body.String(), "Self-heating: t - (0.1000 * (soc - t) +0.00), 3 reference readings")
+	assert.Equal(t, []float64{25, 26, 27}, m.gauges["kitchen/uncompensated_temperature"])
+
+	// compensated and uncompensated temperatures are both recorded
+	server.pullDataFromSensor(context.Background(), st)
+	assert.InDelta(t, 22.4, m.gauges["kitchen/current_temperature"][3], 1e-9)
+	assert.InDelta(t, 24, m.gauges["kitchen/uncompensated_temperature"][3], 1e-9)
+	assert.Equal(t, m.times["kitchen/current_temperature"], m.times["kitchen/uncompensated_temperature"],
+		"the series are recorded at the time of readings")
+	assert.Equal(t, m.times["kitchen/current_temperature"], m.times["kitchen/raw_temperature"])
+
+	rec = post(url.Values{"sensor": {"kitchen"}, "t": {"warm"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = post(url.Values{"sensor": {"kitchen"}, "t": {"NaN"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Len(t, store.Get("kitchen").SelfHeating.Samples, 3)
+	_, err = calibrated.AddSelfHeatingReference(math.Inf(1))
+	assert.Error(t, err)
+
+	rec = post(url.Values{"sensor": {"kitchen"}, "reset": {"true"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Self-heating: off (uncompensated 24.00 °C, SoC 40.00 °C)")
+	assert.Nil(t, store.Get("kitchen").SelfHeating)
+}
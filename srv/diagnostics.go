@@ -0,0 +1,349 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/egregors/hk/internal/sensors"
+	"github.com/egregors/hk/log"
+)
+
+const (
+	// statsLatencies is how many last read latencies make percentiles
+	statsLatencies = 100
+	// statsRecent is how many last readings a self-test reading is compared with
+	statsRecent = 10
+)
+
+// selfTestTolerance is how far a fresh reading can be from the median of recent ones by quantity
+var selfTestTolerance = map[string]float64{
+	temperatureKey: 1.5,
+	humidityKey:    5,
+	pressureKey:    2,
+	co2Key:         200,
+}
+
+// InfoReporter is implemented by sensors which can describe their chip and connection
+type InfoReporter interface {
+	Info() sensors.Info
+}
+
+// readStats are latencies, errors and recent readings of a sensor
+type readStats struct {
+	reads     int
+	latencies []time.Duration
+	errors    map[string]int
+	lastOK    time.Time
+	recent    []sensors.Reading
+}
+
+// add registers a read started at start
+func (rs *readStats) add(start time.Time, latency time.Duration, rd sensors.Reading, err error) {
+	rs.reads++
+	rs.latencies = append(rs.latencies, latency)
+	if len(rs.latencies) > statsLatencies {
+		rs.latencies = rs.latencies[len(rs.latencies)-statsLatencies:]
+	}
+
+	if err != nil {
+		if rs.errors == nil {
+			rs.errors = make(map[string]int)
+		}
+		rs.errors[errorClass(err)]++
+
+		return
+	}
+
+	rs.lastOK = start
+	rs.recent = append(rs.recent, rd)
+	if len(rs.recent) > statsRecent {
+		rs.recent = rs.recent[len(rs.recent)-statsRecent:]
+	}
+}
+
+// percentile returns the nearest rank percentile of the last latencies, 0 if there were no reads
+func (rs *readStats) percentile(p float64) time.Duration {
+	if len(rs.latencies) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(rs.latencies)
+	slices.Sort(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+
+	return sorted[max(rank, 1)-1]
+}
+
+// errorClass groups read errors for diagnostics
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrNoReadings), errors.Is(err, ErrStale):
+		return "stale"
+	case errors.Is(err, sensors.ErrNotSupported):
+		return "not supported"
+	case sensors.IsIOError(err):
+		return "i/o"
+	default:
+		return "other"
+	}
+}
+
+// readSensor reads the sensor with the timeout and registers the read in its stats
+func (s *Server) readSensor(ctx context.Context, st *sensorState) (sensors.Reading, error) {
+	// the sensor is read without the lock, so a slow one doesn't block the web and HAP servers
+	ctx, cancel := context.WithTimeout(ctx, s.readTimeout)
+	defer cancel()
+
+	start := time.Now()
+	rd, err := st.Climate.Read(ctx)
+	latency := time.Since(start)
+
+	s.mu.Lock()
+	st.stats.add(start, latency, rd, err)
+	s.mu.Unlock()
+
+	return rd, err
+}
+
+// sensorsByForm returns the sensor set by "sensor" form value, or all sensors if it's missing
+func (s *Server) sensorsByForm(w http.ResponseWriter, r *http.Request) ([]*sensorState, bool) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if !r.Form.Has("sensor") {
+		return s.sensors, true
+	}
+
+	id := r.Form.Get("sensor")
+	for _, st := range s.sensors {
+		if st.ID == id {
+			return []*sensorState{st}, true
+		}
+	}
+	http.Error(w, fmt.Sprintf("sensor %q not found", id), http.StatusNotFound)
+
+	return nil, false
+}
+
+// handleDiagnostics shows chips, read latencies and errors of all sensors or the one set by "sensor"
+func (s *Server) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	states, ok := s.sensorsByForm(w, r)
+	if !ok {
+		return
+	}
+
+	var builder strings.Builder
+	s.mu.RLock()
+	for _, st := range states {
+		builder.WriteString(renderDiagnostics(st, time.Now()))
+	}
+	s.mu.RUnlock()
+
+	builder.WriteString("\nPOST /diagnostics/selftest [sensor=<id>]\n")
+
+	_, _ = fmt.Fprint(w, builder.String())
+}
+
+// handleSelfTest reads all sensors or the one set by "sensor" and compares fresh readings with recent ones.
+// It responds with 503 if any sensor failed.
+func (s *Server) handleSelfTest(w http.ResponseWriter, r *http.Request) {
+	states, ok := s.sensorsByForm(w, r)
+	if !ok {
+		return
+	}
+
+	var builder strings.Builder
+	passed := true
+	for _, st := range states {
+		report, ok := s.selfTest(r.Context(), st)
+		builder.WriteString(report)
+		passed = passed && ok
+	}
+
+	if !passed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = fmt.Fprint(w, builder.String())
+}
+
+// selfTest makes a fresh read and compares it with the median of recent readings,
+// it returns the report and whether the sensor passed
+func (s *Server) selfTest(ctx context.Context, st *sensorState) (string, bool) {
+	s.mu.RLock()
+	recent := slices.Clone(st.stats.recent)
+	s.mu.RUnlock()
+
+	var builder strings.Builder
+	line := func(name, format string, args ...any) {
+		builder.WriteString(fmt.Sprintf("%-20s %s\n", name, fmt.Sprintf(format, args...)))
+	}
+	builder.WriteString(fmt.Sprintf("[ %s ] sensor=%s self-test\n", st.Room, st.ID))
+
+	passed := true
+	start := time.Now()
+	rd, err := s.readSensor(ctx, st)
+	if err != nil {
+		line("read", "FAIL %s: %s", errorClass(err), err.Error())
+		passed = false
+	} else {
+		line("read", "ok in %s", time.Since(start).Round(time.Millisecond))
+	}
+
+	if ir, ok := st.Climate.(InfoReporter); ok {
+		if cerr := ir.Info().Calibration; cerr != nil {
+			line("calibration", "FAIL %s", cerr.Error())
+			passed = false
+		}
+	}
+
+	if err == nil {
+		fresh := measured(rd)
+		for _, key := range []string{temperatureKey, humidityKey, pressureKey, co2Key} {
+			v, ok := compareRecent(key, fresh[key], recent)
+			if v != "" {
+				line(key, "%s", v)
+			}
+			passed = passed && ok
+		}
+	}
+
+	result := "PASS"
+	if !passed {
+		result = "FAIL"
+	}
+	line("result", "%s", result)
+	log.Info.Printf("self-test of %q: %s", st.Room, result)
+
+	return builder.String(), passed
+}
+
+// compareRecent checks a fresh value is within selfTestTolerance from the median of recent ones.
+// It returns an empty line for quantities the sensor doesn't measure.
+func compareRecent(key string, v float64, recent []sensors.Reading) (string, bool) {
+	var vals []float64
+	for _, rd := range recent {
+		if rv := measured(rd)[key]; !math.IsNaN(rv) {
+			vals = append(vals, rv)
+		}
+	}
+
+	switch {
+	case math.IsNaN(v) && len(vals) == 0:
+		return "", true
+	case math.IsNaN(v):
+		return "FAIL missing, it was in recent readings", false
+	case len(vals) == 0:
+		return fmt.Sprintf("%.2f, no recent readings to compare", v), true
+	}
+
+	m := median(vals)
+	tolerance := selfTestTolerance[key]
+	if diff := math.Abs(v - m); diff > tolerance {
+		return fmt.Sprintf("FAIL %.2f, recent %.2f, off by %.2f > %g", v, m, diff, tolerance), false
+	}
+
+	return fmt.Sprintf("ok %.2f, recent %.2f", v, m), true
+}
+
+// median returns the median of non-empty vals, vals are sorted in place
+func median(vals []float64) float64 {
+	slices.Sort(vals)
+	m := vals[len(vals)/2]
+	if len(vals)%2 == 0 {
+		m = (vals[len(vals)/2-1] + m) / 2
+	}
+
+	return m
+}
+
+// renderDiagnostics shows what's known about the sensor, its reads and errors
+func renderDiagnostics(st *sensorState, now time.Time) string {
+	var builder strings.Builder
+	line := func(name, format string, args ...any) {
+		builder.WriteString(fmt.Sprintf("%-12s %s\n", name, fmt.Sprintf(format, args...)))
+	}
+	builder.WriteString(fmt.Sprintf("[ %s ] sensor=%s\n", st.Room, st.ID))
+
+	// wrapped sensors without a description have an empty chip
+	if ir, ok := st.Climate.(InfoReporter); ok && ir.Info().Chip != "" {
+		info := ir.Info()
+		chip := info.Chip
+		if info.ID != "" {
+			chip += " id " + info.ID
+		}
+		if info.Addr != "" {
+			chip += " at " + info.Addr
+		}
+		line("Chip", "%s", chip)
+
+		if info.Calibration != nil {
+			line("Calibration", "invalid: %s", info.Calibration.Error())
+		} else {
+			line("Calibration", "ok")
+		}
+		if info.Settings != "" {
+			line("Settings", "%s", info.Settings)
+		}
+	}
+
+	status := st.status
+	if st.err != nil {
+		status += ": " + st.err.Error()
+	}
+	line("Status", "%s", status)
+
+	if st.stats.lastOK.IsZero() {
+		line("Last read", "never")
+	} else {
+		line("Last read", "%s (%s ago)", st.stats.lastOK.Format(time.DateTime),
+			now.Sub(st.stats.lastOK).Truncate(time.Second))
+	}
+
+	p := func(p float64) time.Duration { return st.stats.percentile(p).Round(time.Millisecond) }
+	line("Latency", "p50 %s p90 %s p99 %s of last %d reads", p(50), p(90), p(99), len(st.stats.latencies))
+
+	line("Errors", "%s", renderErrorClasses(st.stats.errors, st.stats.reads))
+
+	if rr, ok := st.Climate.(RecoveryReporter); ok {
+		if recovery := rr.RecoveryStatus(); recovery != "" {
+			line("Recovery", "%s", recovery)
+		}
+	}
+
+	if gr, ok := st.Climate.(GroupReporter); ok {
+		line("Members", "%s", gr.MembersStatus())
+	}
+
+	return builder.String()
+}
+
+// renderErrorClasses shows error counts by class, e.g. "i/o 3, timeout 1 of 120 reads"
+func renderErrorClasses(errs map[string]int, reads int) string {
+	if len(errs) == 0 {
+		return fmt.Sprintf("none of %d reads", reads)
+	}
+
+	classes := make([]string, 0, len(errs))
+	for class := range errs {
+		classes = append(classes, class)
+	}
+	slices.Sort(classes)
+
+	counts := make([]string, 0, len(classes))
+	for _, class := range classes {
+		counts = append(counts, fmt.Sprintf("%s %d", class, errs[class]))
+	}
+
+	return fmt.Sprintf("%s of %d reads", strings.Join(counts, ", "), reads)
+}
@@ -0,0 +1,120 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egregors/hk/internal/sensors"
+)
+
+// seqClimate returns temperatures one by one, NaN temperature is an I/O error
+type seqClimate struct {
+	temps []float64
+	i     int
+}
+
+func (c *seqClimate) Read(_ context.Context) (sensors.Reading, error) {
+	t := c.temps[min(c.i, len(c.temps)-1)]
+	c.i++
+	if math.IsNaN(t) {
+		return sensors.Reading{}, &fs.PathError{Op: "read", Path: "/dev/i2c-1", Err: errors.New("remote I/O error")}
+	}
+
+	rd := sensors.NewReading(time.Now())
+	rd.Temperature = t
+
+	return rd, nil
+}
+
+func (c *seqClimate) Info() sensors.Info {
+	return sensors.Info{Chip: "BME280", ID: "0x60", Addr: "i2c-1 0x76", Settings: "forced"}
+}
+
+func TestReadStats(t *testing.T) {
+	var rs readStats
+	assert.Equal(t, time.Duration(0), rs.percentile(50))
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 1; i <= 200; i++ {
+		rs.add(start, time.Duration(i)*time.Millisecond, sensors.NewReading(start), nil)
+	}
+	rs.add(start.Add(time.Minute), time.Second, sensors.Reading{}, context.DeadlineExceeded)
+	rs.add(start.Add(time.Minute), time.Second, sensors.Reading{}, fmt.Errorf("can't read: %w", ErrStale))
+
+	assert.Equal(t, 202, rs.reads)
+	assert.Len(t, rs.latencies, statsLatencies)
+	assert.Len(t, rs.recent, statsRecent)
+	assert.Equal(t, start, rs.lastOK)
+	assert.Equal(t, 152*time.Millisecond, rs.percentile(50))
+	assert.Equal(t, time.Second, rs.percentile(99))
+	assert.Equal(t, "stale 1, timeout 1 of 202 reads", renderErrorClasses(rs.errors, rs.reads))
+}
+
+func TestErrorClass(t *testing.T) {
+	ioErr := &fs.PathError{Op: "write", Path: "/dev/i2c-1", Err: errors.New("remote I/O error")}
+
+	assert.Equal(t, "timeout", errorClass(context.DeadlineExceeded))
+	assert.Equal(t, "timeout", errorClass(fmt.Errorf("sensor is busy with the previous read: %w", context.DeadlineExceeded)))
+	assert.Equal(t, "stale", errorClass(ErrNoReadings))
+	assert.Equal(t, "i/o", errorClass(fmt.Errorf("%w (recovery failed: no device)", ioErr)))
+	assert.Equal(t, "other", errorClass(errors.New("invalid calibration: empty T1 or P1")))
+}
+
+func TestDiagnosticsHandlers(t *testing.T) {
+	climate := &seqClimate{temps: []float64{21, 21.2, math.NaN(), 21.1, 21.3, 25}}
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	server := New(nil, []Sensor{{ID: "kitchen", Room: "Kitchen", Climate: climate}}, nil, nil, m, nil, nil, nil)
+	st := server.sensors[0]
+	for range 4 {
+		server.pullDataFromSensor(context.Background(), st)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleDiagnostics(rec, httptest.NewRequest(http.MethodGet, "/diagnostics?sensor=kitchen", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "[ Kitchen ] sensor=kitchen\n")
+	assert.Contains(t, body, "Chip         BME280 id 0x60 at i2c-1 0x76\n")
+	assert.Contains(t, body, "Calibration  ok\n")
+	assert.Contains(t, body, "Settings     forced\n")
+	assert.Contains(t, body, "Status       online\n")
+	assert.Contains(t, body, "of last 4 reads\n")
+	assert.Contains(t, body, "Errors       i/o 1 of 4 reads\n")
+
+	rec = httptest.NewRecorder()
+	server.handleDiagnostics(rec, httptest.NewRequest(http.MethodGet, "/diagnostics?sensor=bedroom", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/diagnostics/selftest", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		server.handleSelfTest(rec, req)
+
+		return rec
+	}
+
+	// 21.3 is close to the median of 21, 21.2 and 21.1
+	rec = post(url.Values{"sensor": {"kitchen"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "current_temperature  ok 21.30, recent 21.10\n")
+	assert.Contains(t, rec.Body.String(), "result               PASS\n")
+
+	// 25 is too far from recent readings
+	rec = post(nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "current_temperature  FAIL 25.00, recent 21.15, off by 3.85 > 1.5\n")
+	assert.Contains(t, rec.Body.String(), "result               FAIL\n")
+}
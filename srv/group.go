@@ -0,0 +1,362 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/egregors/hk/internal/sensors"
+	"github.com/egregors/hk/log"
+)
+
+// DefaultDisagreeFor is how long group members can disagree before it's reported
+const DefaultDisagreeFor = 15 * time.Minute
+
+// DefaultGroupTolerance is how far apart readings of group members can be by quantity
+var DefaultGroupTolerance = map[string]float64{
+	temperatureKey: 1,
+	humidityKey:    5,
+	pressureKey:    2,
+	co2Key:         150,
+}
+
+// GroupSpec is a redundant group of sensors placed side by side
+type GroupSpec struct {
+	ID      string
+	Room    string
+	Members []string
+}
+
+// ParseGroups parses "id:room:member+member,..." list of redundant groups, members are sensor IDs
+func ParseGroups(s string) ([]GroupSpec, error) {
+	var groups []GroupSpec
+	ids, members := make(map[string]bool), make(map[string]bool)
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		parts := strings.Split(raw, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid group spec %q, want id:room:member+member", raw)
+		}
+
+		g := GroupSpec{ID: strings.TrimSpace(parts[0]), Room: strings.TrimSpace(parts[1])}
+		if g.ID == "" || g.Room == "" {
+			return nil, fmt.Errorf("invalid group spec %q: id and room are required", raw)
+		}
+		if ids[g.ID] {
+			return nil, fmt.Errorf("duplicate group id %q", g.ID)
+		}
+		ids[g.ID] = true
+
+		for _, m := range strings.Split(parts[2], "+") {
+			m = strings.TrimSpace(m)
+			if m == "" {
+				return nil, fmt.Errorf("invalid group spec %q: empty member", raw)
+			}
+			if members[m] {
+				return nil, fmt.Errorf("sensor %q is in several groups", m)
+			}
+			members[m] = true
+			g.Members = append(g.Members, m)
+		}
+		if len(g.Members) < 2 {
+			return nil, fmt.Errorf("group %q needs 2 or more members", g.ID)
+		}
+
+		groups = append(groups, g)
+	}
+
+	return groups, nil
+}
+
+// ParseTolerance parses "quantity=tolerance,..." e.g. "current_temperature=0.5,current_humidity=3"
+// over DefaultGroupTolerance
+func ParseTolerance(s string) (map[string]float64, error) {
+	tolerance := make(map[string]float64, len(DefaultGroupTolerance))
+	for key, tol := range DefaultGroupTolerance {
+		tolerance[key] = tol
+	}
+
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(raw, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tolerance %q, want quantity=tolerance", raw)
+		}
+		if _, known := DefaultGroupTolerance[key]; !known {
+			return nil, fmt.Errorf("unknown quantity %q", key)
+		}
+		tol, err := strconv.ParseFloat(val, 64)
+		if err != nil || tol <= 0 {
+			return nil, fmt.Errorf("invalid tolerance of %s: %q", key, val)
+		}
+		tolerance[key] = tol
+	}
+
+	return tolerance, nil
+}
+
+// GroupMember is a sensor of a redundant group
+type GroupMember struct {
+	ID      string
+	Climate ClimateSensor
+}
+
+type GroupOption func(g *GroupSensor)
+
+// WithTolerance sets how far apart member readings can be by quantity, DefaultGroupTolerance if not set
+func WithTolerance(tolerance map[string]float64) GroupOption {
+	return func(g *GroupSensor) {
+		g.tolerance = tolerance
+	}
+}
+
+// WithDisagreeFor sets how long members can disagree before the notification, DefaultDisagreeFor if not set
+func WithDisagreeFor(d time.Duration) GroupOption {
+	return func(g *GroupSensor) {
+		g.disagreeFor = d
+	}
+}
+
+// memberState is the last read of a group member
+type memberState struct {
+	GroupMember
+	rd  sensors.Reading
+	err error
+}
+
+// GroupSensor is a ClimateSensor made of several sensors side by side. It reports the median
+// of members which are online and fails only if all of them fail. Members disagreeing by more
+// than the tolerance for a sustained time usually mean one is failing, it's sent to the notifier.
+type GroupSensor struct {
+	name        string
+	notifier    Notifier
+	tolerance   map[string]float64
+	disagreeFor time.Duration
+	now         func() time.Time
+
+	mu      sync.RWMutex
+	members []*memberState
+	// disagreeSince is when members went apart by quantity, notified are the ones already reported
+	disagreeSince map[string]time.Time
+	notified      map[string]bool
+}
+
+// NewGroupSensor makes a group of 2 or more members, name is used in notifications
+func NewGroupSensor(name string, members []GroupMember, notifier Notifier, opts ...GroupOption) (*GroupSensor, error) {
+	if len(members) < 2 {
+		return nil, fmt.Errorf("group %q needs 2 or more members, got %d", name, len(members))
+	}
+
+	g := &GroupSensor{
+		name:          name,
+		notifier:      notifier,
+		tolerance:     DefaultGroupTolerance,
+		disagreeFor:   DefaultDisagreeFor,
+		now:           time.Now,
+		disagreeSince: make(map[string]time.Time),
+		notified:      make(map[string]bool),
+	}
+	for _, m := range members {
+		g.members = append(g.members, &memberState{GroupMember: m})
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return g, nil
+}
+
+// Read reads all members at once and returns the median of each quantity over the ones which are online
+func (g *GroupSensor) Read(ctx context.Context) (sensors.Reading, error) {
+	results := make([]memberState, len(g.members))
+	var wg sync.WaitGroup
+	for i, m := range g.members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rd, err := m.Climate.Read(ctx)
+			results[i] = memberState{GroupMember: m.GroupMember, rd: rd, err: err}
+		}()
+	}
+	wg.Wait()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var (
+		online []sensors.Reading
+		ids    []string
+		errs   []error
+	)
+	for i, res := range results {
+		m := g.members[i]
+		switch {
+		case res.err != nil && m.err == nil:
+			log.Erro.Printf("member %q of %q failed, it's left out: %s", m.ID, g.name, res.err.Error())
+			g.notify("Sensor Group Degraded: "+g.name, fmt.Sprintf("%s is offline: %s", m.ID, res.err.Error()))
+		case res.err == nil && m.err != nil:
+			log.Info.Printf("member %q of %q is back online", m.ID, g.name)
+		}
+		m.rd, m.err = res.rd, res.err
+
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.ID, res.err))
+			continue
+		}
+		online = append(online, res.rd)
+		ids = append(ids, m.ID)
+	}
+
+	if len(online) == 0 {
+		return sensors.Reading{}, fmt.Errorf("all members failed: %w", errors.Join(errs...))
+	}
+
+	g.checkAgreement(ids, online)
+
+	return medianReading(online), nil
+}
+
+// checkAgreement tracks how long online members disagree and notifies once per disagreement
+func (g *GroupSensor) checkAgreement(ids []string, online []sensors.Reading) {
+	now := g.now()
+	for key, tol := range g.tolerance {
+		vals := make([]string, 0, len(online))
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for i, rd := range online {
+			v := measured(rd)[key]
+			if math.IsNaN(v) {
+				continue
+			}
+			lo, hi = min(lo, v), max(hi, v)
+			vals = append(vals, fmt.Sprintf("%s %.2f", ids[i], v))
+		}
+
+		// a single member can't disagree
+		if len(vals) < 2 || hi-lo <= tol {
+			if g.notified[key] {
+				log.Info.Printf("members of %q agree on %s again", g.name, key)
+			}
+			delete(g.disagreeSince, key)
+			delete(g.notified, key)
+			continue
+		}
+
+		since, ok := g.disagreeSince[key]
+		if !ok {
+			g.disagreeSince[key] = now
+			since = now
+		}
+		if g.notified[key] || now.Sub(since) < g.disagreeFor {
+			continue
+		}
+
+		msg := fmt.Sprintf("%s: %s, spread %.2f > %g for %s, a sensor may be failing",
+			key, strings.Join(vals, ", "), hi-lo, tol, now.Sub(since).Truncate(time.Second))
+		log.Erro.Printf("members of %q disagree on %s", g.name, msg)
+		g.notify("Sensors Disagree: "+g.name, msg)
+		g.notified[key] = true
+	}
+}
+
+func (g *GroupSensor) notify(title, msg string) {
+	if g.notifier == nil {
+		return
+	}
+
+	go func() {
+		if err := g.notifier.Notify(title, msg); err != nil {
+			log.Erro.Printf("can't send notification: %s", err.Error())
+		}
+	}()
+}
+
+// Info describes the group for diagnostics, member IDs are its ID
+func (g *GroupSensor) Info() sensors.Info {
+	ids := make([]string, 0, len(g.members))
+	for _, m := range g.members {
+		ids = append(ids, m.ID)
+	}
+
+	return sensors.Info{Chip: "redundant group", ID: strings.Join(ids, "+")}
+}
+
+// MembersStatus shows the last reads of members and what they disagree on,
+// e.g. "rack-top 21.30 °C, rack-bottom offline"
+func (g *GroupSensor) MembersStatus() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	members := make([]string, 0, len(g.members))
+	for _, m := range g.members {
+		switch {
+		case m.err != nil:
+			members = append(members, m.ID+" offline")
+		case m.rd.T.IsZero():
+			members = append(members, m.ID+" not read yet")
+		default:
+			members = append(members, fmt.Sprintf("%s %s", m.ID, fmtReading("%0.2f °C", m.rd.Temperature)))
+		}
+	}
+	status := strings.Join(members, ", ")
+
+	keys := make([]string, 0, len(g.disagreeSince))
+	for key := range g.disagreeSince {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		status += fmt.Sprintf("; disagree on %s for %s", key, g.now().Sub(g.disagreeSince[key]).Truncate(time.Second))
+	}
+
+	return status
+}
+
+// medianReading returns the median of each quantity over readings with it, taken at the latest time.
+// Air quality isn't a median, it's the one of the first reading with gas resistance.
+func medianReading(readings []sensors.Reading) sensors.Reading {
+	rd := sensors.NewReading(readings[0].T)
+	for _, r := range readings {
+		if r.T.After(rd.T) {
+			rd.T = r.T
+		}
+		if math.IsNaN(rd.AirQuality.GasResistance) {
+			rd.AirQuality = r.AirQuality
+		}
+	}
+
+	fields := map[string]*float64{
+		temperatureKey: &rd.Temperature,
+		humidityKey:    &rd.Humidity,
+		pressureKey:    &rd.Pressure,
+		co2Key:         &rd.CO2,
+	}
+	for key, field := range fields {
+		var vals []float64
+		for _, r := range readings {
+			if v := measured(r)[key]; !math.IsNaN(v) {
+				vals = append(vals, v)
+			}
+		}
+		if len(vals) == 0 {
+			continue
+		}
+
+		*field = median(vals)
+	}
+
+	return rd
+}
@@ -0,0 +1,113 @@
+package srv
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egregors/hk/internal/sensors"
+)
+
+func TestParseGroups(t *testing.T) {
+	groups, err := ParseGroups("server:Server room:rack-top+rack-bottom, lab:Lab:a+b+c")
+	require.NoError(t, err)
+	assert.Equal(t, []GroupSpec{
+		{ID: "server", Room: "Server room", Members: []string{"rack-top", "rack-bottom"}},
+		{ID: "lab", Room: "Lab", Members: []string{"a", "b", "c"}},
+	}, groups)
+
+	for _, s := range []string{"server:Server room", "server:Server room:a", ":Server room:a+b", "server:Server room:a+", "x:X:a+b,y:Y:b+c", "x:X:a+b,x:Y:c+d"} {
+		_, err := ParseGroups(s)
+		assert.Error(t, err, s)
+	}
+
+	tolerance, err := ParseTolerance("current_temperature=0.5")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, tolerance[temperatureKey], 1e-9)
+	assert.InDelta(t, DefaultGroupTolerance[humidityKey], tolerance[humidityKey], 1e-9)
+	for _, s := range []string{"current_temperature", "current_temperature=-1", "dew_point=1"} {
+		_, err := ParseTolerance(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestGroupSensor(t *testing.T) {
+	nan := math.NaN()
+	top := &seqClimate{temps: []float64{21, nan, 23.5, 23.5, 23.5, 23.5, 21.3, nan}}
+	bottom := &seqClimate{temps: []float64{21.2, 21.3, 21.3, 21.3, 21.3, 21.3, 21.3, nan}}
+	n := &fakeNotifier{ch: make(chan string, 4)}
+
+	_, err := NewGroupSensor("Server room", []GroupMember{{ID: "rack-top", Climate: top}}, n)
+	require.Error(t, err)
+
+	g, err := NewGroupSensor("Server room", []GroupMember{
+		{ID: "rack-top", Climate: top},
+		{ID: "rack-bottom", Climate: bottom},
+	}, n, WithDisagreeFor(10*time.Minute))
+	require.NoError(t, err)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+	assert.Equal(t, "rack-top not read yet, rack-bottom not read yet", g.MembersStatus())
+
+	read := func() float64 {
+		rd, err := g.Read(context.Background())
+		require.NoError(t, err)
+		now = now.Add(5 * time.Minute)
+
+		return rd.Temperature
+	}
+	noNotification := func() {
+		select {
+		case msg := <-n.ch:
+			t.Errorf("unexpected notification %q", msg)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	assert.InDelta(t, 21.1, read(), 1e-9)
+
+	// fails over to the member which is online
+	assert.InDelta(t, 21.3, read(), 1e-9)
+	assert.Contains(t, <-n.ch, "Sensor Group Degraded: Server room: rack-top is offline: read /dev/i2c-1")
+	assert.Equal(t, "rack-top offline, rack-bottom 21.30 °C", g.MembersStatus())
+
+	// disagreement is reported once it lasts 10m
+	assert.InDelta(t, 22.4, read(), 1e-9)
+	assert.InDelta(t, 22.4, read(), 1e-9)
+	assert.Equal(t, "rack-top 23.50 °C, rack-bottom 21.30 °C; disagree on current_temperature for 10m0s", g.MembersStatus())
+	noNotification()
+	read()
+	assert.Equal(t,
+		"Sensors Disagree: Server room: current_temperature: rack-top 23.50, rack-bottom 21.30, spread 2.20 > 1 for 10m0s, a sensor may be failing",
+		<-n.ch)
+	read()
+	noNotification()
+
+	assert.InDelta(t, 21.3, read(), 1e-9)
+	assert.Equal(t, "rack-top 21.30 °C, rack-bottom 21.30 °C", g.MembersStatus())
+
+	_, err = g.Read(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "i/o", errorClass(err))
+	assert.Contains(t, err.Error(), "all members failed")
+}
+
+func TestMedianReading(t *testing.T) {
+	rd := func(sec int, t, h, co2 float64) sensors.Reading {
+		r := sensors.NewReading(time.Date(2025, 1, 1, 12, 0, sec, 0, time.UTC))
+		r.Temperature, r.Humidity, r.CO2 = t, h, co2
+
+		return r
+	}
+
+	m := medianReading([]sensors.Reading{rd(1, 21, 40, math.NaN()), rd(3, 25, math.NaN(), math.NaN()), rd(2, 21.4, 44, 600)})
+	assert.Equal(t, time.Date(2025, 1, 1, 12, 0, 3, 0, time.UTC), m.T)
+	assert.InDelta(t, 21.4, m.Temperature, 1e-9, "an outlier doesn't move the median")
+	assert.InDelta(t, 42, m.Humidity, 1e-9)
+	assert.InDelta(t, 600, m.CO2, 1e-9)
+	assert.True(t, math.IsNaN(m.Pressure))
+}
@@ -0,0 +1,61 @@
+package srv
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/egregors/hk/internal/host"
+)
+
+// hostKeyPrefix is the metric keys prefix of host metrics, e.g. "host/soc_temperature"
+const hostKeyPrefix = "host/"
+
+// HostMonitor collects metrics of the machine hk runs on, e.g. Raspberry Pi SoC temperature and throttling
+type HostMonitor interface {
+	Collect(ctx context.Context) host.Stats
+}
+
+// pullDataFromHost records host metrics, the ones the host doesn't have are skipped
+func (s *Server) pullDataFromHost(ctx context.Context) {
+	if s.host == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.readTimeout)
+	stats := s.host.Collect(ctx)
+	cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hostStats = stats
+	for key, val := range stats.Gauges() {
+		s.metrics.GaugeAt(hostKeyPrefix+key, val, stats.T)
+	}
+}
+
+// renderHost shows host metrics, unavailable ones are "n/a"
+func renderHost(stats host.Stats) string {
+	wifi := "n/a"
+	if stats.WiFi != "" {
+		wifi = fmt.Sprintf("%s link %0.0f, signal %0.0f dBm", stats.WiFi, stats.WiFiLink, stats.WiFiSignal)
+	}
+
+	throttled := "n/a"
+	if stats.ThrottledKnown {
+		throttled = stats.Throttled.String()
+	}
+
+	return fmt.Sprintf(
+		"[ Host ]\nSoC  %s\nLoad %s %s %s\nMem  %s available, %s used\nDisk %s\nWiFi %s\nThrt %s\n\n",
+		fmtReading("%0.2f °C", stats.SoCTemperature),
+		fmtReading("%0.2f", stats.Load1),
+		fmtReading("%0.2f", stats.Load5),
+		fmtReading("%0.2f", stats.Load15),
+		fmtReading("%0.0f MB", stats.MemAvailable),
+		fmtReading("%0.0f %%", stats.MemUsed),
+		fmtReading("%0.0f MB free", stats.DiskFree),
+		wifi,
+		throttled,
+	)
+}
@@ -0,0 +1,54 @@
+package srv
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/egregors/hk/internal/host"
+)
+
+type fakeHost struct {
+	stats host.Stats
+}
+
+func (h *fakeHost) Collect(_ context.Context) host.Stats {
+	return h.stats
+}
+
+func TestPullDataFromHost(t *testing.T) {
+	nan := math.NaN()
+	stats := host.Stats{
+		T:              time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		SoCTemperature: 52.6,
+		Load1:          0.12, Load5: 0.2, Load15: 0.25,
+		MemAvailable: 2930, MemUsed: 25,
+		DiskFree: nan,
+		WiFi:     "wlan0", WiFiLink: 56, WiFiSignal: -54,
+		Throttled: host.UnderVoltageOccurred, ThrottledKnown: true,
+	}
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	server := New(nil, nil, nil, nil, m, nil, nil, &fakeHost{stats: stats})
+
+	server.pullDataFromHost(context.Background())
+	assert.Equal(t, []float64{52.6}, m.gauges["host/soc_temperature"])
+	assert.Equal(t, []float64{0x10000}, m.gauges["host/throttled"])
+	assert.Equal(t, []time.Time{stats.T}, m.times["host/load1"])
+	assert.NotContains(t, m.gauges, "host/disk_free")
+
+	assert.Equal(t, "[ Host ]\n"+
+		"SoC  52.60 °C\n"+
+		"Load 0.12 0.20 0.25\n"+
+		"Mem  2930 MB available, 25 % used\n"+
+		"Disk n/a\n"+
+		"WiFi wlan0 link 56, signal -54 dBm\n"+
+		"Thrt since boot: under-voltage\n\n", renderHost(server.hostStats))
+
+	// no host monitor, nothing is recorded
+	m = &fakeMetrics{gauges: make(map[string][]float64)}
+	New(nil, nil, nil, nil, m, nil, nil, nil).pullDataFromHost(context.Background())
+	assert.Empty(t, m.gauges)
+}
@@ -0,0 +1,238 @@
+package srv
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/egregors/hk/internal/sensors"
+	"github.com/egregors/hk/log"
+)
+
+const (
+	// DefaultStaleAfter is how long a remote node can be silent before it's offline
+	DefaultStaleAfter = 5 * time.Minute
+	// maxClockSkew is how far in the future a reading timestamp of a node can be
+	maxClockSkew = time.Minute
+	// maxBatchSize limits readings in a single request
+	maxBatchSize = 1000
+)
+
+var (
+	// ErrNoReadings means a remote node hasn't reported anything yet
+	ErrNoReadings = errors.New("no readings from the node yet")
+	// ErrStale means a remote node stopped reporting
+	ErrStale = errors.New("node is stale")
+)
+
+// NodeSpec is a remote sensor node registered with its own token
+type NodeSpec struct {
+	ID    string
+	Room  string
+	Token string
+}
+
+// ParseNodes parses "id:room:token,..." list of remote nodes
+func ParseNodes(s string) ([]NodeSpec, error) {
+	var nodes []NodeSpec
+	ids := make(map[string]bool)
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		parts := strings.Split(raw, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid node spec %q, want id:room:token", raw)
+		}
+
+		n := NodeSpec{ID: strings.TrimSpace(parts[0]), Room: strings.TrimSpace(parts[1]), Token: parts[2]}
+		if n.ID == "" || n.Room == "" || n.Token == "" {
+			return nil, fmt.Errorf("invalid node spec %q: id, room and token are required", n.ID)
+		}
+		if ids[n.ID] {
+			return nil, fmt.Errorf("duplicate node id %q", n.ID)
+		}
+		ids[n.ID] = true
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+// RemoteSensor is a ClimateSensor fed by a node pushing readings over HTTP (e.g. ESP32).
+// It returns the latest reading, or an error if the node stopped reporting.
+type RemoteSensor struct {
+	token      string
+	staleAfter time.Duration
+	now        func() time.Time
+
+	mu   sync.RWMutex
+	last *sensors.Reading
+}
+
+func NewRemoteSensor(token string, staleAfter time.Duration) *RemoteSensor {
+	return &RemoteSensor{token: token, staleAfter: staleAfter, now: time.Now}
+}
+
+// authorized checks the node token in constant time
+func (r *RemoteSensor) authorized(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.token), []byte(token)) == 1
+}
+
+// update keeps the reading and returns true if it's the latest one
+func (r *RemoteSensor) update(rd sensors.Reading) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.last != nil && !rd.T.After(r.last.T) {
+		return false
+	}
+	r.last = &rd
+
+	return true
+}
+
+// Read returns the latest reading with its own timestamp
+func (r *RemoteSensor) Read(ctx context.Context) (sensors.Reading, error) {
+	if err := ctx.Err(); err != nil {
+		return sensors.Reading{}, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.last == nil {
+		return sensors.Reading{}, ErrNoReadings
+	}
+	if age := r.now().Sub(r.last.T); age > r.staleAfter {
+		return sensors.Reading{}, fmt.Errorf("%w, the last reading was %s ago", ErrStale, age.Truncate(time.Second))
+	}
+
+	return *r.last, nil
+}
+
+// Info describes the node for diagnostics
+func (r *RemoteSensor) Info() sensors.Info {
+	return sensors.Info{Chip: "remote node", Addr: "HTTP"}
+}
+
+// nodeReading is a single reading in a node request, missing time means now
+// and missing humidity or pressure means the node can't measure it
+type nodeReading struct {
+	T           time.Time `json:"t"`
+	Temperature *float64  `json:"temperature"`
+	Humidity    *float64  `json:"humidity"`
+	Pressure    *float64  `json:"pressure"`
+}
+
+type nodeRequest struct {
+	Readings []nodeReading `json:"readings"`
+}
+
+// handleNodeReadings ingests a batch of readings from a remote node.
+// Readings go through the filters in time order and are recorded with their own timestamps,
+// so a node can backfill what it measured while the network was down. Readings which aren't newer
+// than the latest one can't be filtered in order, they are dropped and their count is returned
+// as {"dropped":N} with 200, otherwise the answer is 204.
+func (s *Server) handleNodeReadings(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var (
+		st     *sensorState
+		remote *RemoteSensor
+	)
+	for _, state := range s.sensors {
+		if rs, ok := state.Climate.(*RemoteSensor); ok && state.ID == id {
+			st, remote = state, rs
+			break
+		}
+	}
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if remote == nil || !remote.authorized(token) {
+		// the same answer for unknown nodes and wrong tokens
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req nodeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if len(req.Readings) == 0 || len(req.Readings) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("want 1 to %d readings", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	now := remote.now()
+	readings := make([]sensors.Reading, 0, len(req.Readings))
+	for i, nr := range req.Readings {
+		rd, err := nr.reading(now)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid reading %d: %s", i, err.Error()), http.StatusBadRequest)
+			return
+		}
+		readings = append(readings, rd)
+	}
+	sort.Slice(readings, func(i, j int) bool { return readings[i].T.Before(readings[j].T) })
+
+	// filters need readings in time order, the ones before the latest one are dropped, e.g. resent ones
+	var dropped int
+	s.mu.Lock()
+	for _, rd := range readings {
+		if !remote.update(rd) {
+			dropped++
+			continue
+		}
+		s.ingest(st, rd)
+	}
+	s.mu.Unlock()
+	log.Debg.Printf("got %d readings from node %q", len(readings), id)
+
+	if dropped == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	log.Info.Printf("dropped %d readings of node %q older than the latest one", dropped, id)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Dropped int `json:"dropped"`
+	}{dropped}); err != nil {
+		log.Erro.Printf("can't write response to node %q: %s", id, err.Error())
+	}
+}
+
+func (nr nodeReading) reading(now time.Time) (sensors.Reading, error) {
+	if nr.Temperature == nil {
+		return sensors.Reading{}, errors.New("temperature is required")
+	}
+
+	rd := sensors.NewReading(nr.T)
+	rd.Temperature = *nr.Temperature
+	if rd.T.IsZero() {
+		rd.T = now
+	}
+	if rd.T.After(now.Add(maxClockSkew)) {
+		return sensors.Reading{}, fmt.Errorf("time %s is in the future", rd.T.Format(time.RFC3339))
+	}
+	if nr.Humidity != nil {
+		rd.Humidity = *nr.Humidity
+	}
+	if nr.Pressure != nil {
+		rd.Pressure = *nr.Pressure
+	}
+
+	return rd, nil
+}
@@ -0,0 +1,150 @@
+package srv
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egregors/hk/internal/filter"
+)
+
+func TestParseNodes(t *testing.T) {
+	nodes, err := ParseNodes("attic:Attic:s3cret, garage:Garage:t0ken")
+	require.NoError(t, err)
+	assert.Equal(t, []NodeSpec{
+		{ID: "attic", Room: "Attic", Token: "s3cret"},
+		{ID: "garage", Room: "Garage", Token: "t0ken"},
+	}, nodes)
+
+	for _, s := range []string{"attic:Attic", "attic:Attic:", ":Attic:s3cret", "attic:Attic:a,attic:Other:b"} {
+		_, err := ParseNodes(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestHandleNodeReadings(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	remote := NewRemoteSensor("s3cret", DefaultStaleAfter)
+	remote.now = func() time.Time { return now }
+
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	server := New(nil, []Sensor{{ID: "attic", Room: "Attic", Climate: remote}}, nil, nil, m, nil, nil, nil)
+	st := server.sensors[0]
+
+	post := func(id, token, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/nodes/"+id+"/readings", strings.NewReader(body))
+		req.SetPathValue("id", id)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.handleNodeReadings(rec, req)
+
+		return rec.Code
+	}
+
+	// nothing reported yet
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, OFFLINE, st.status)
+	assert.ErrorIs(t, st.err, ErrNoReadings)
+
+	body := `{"readings":[
+		{"t":"2025-01-01T11:58:00Z","temperature":18.5,"humidity":55},
+		{"t":"2025-01-01T11:50:00Z","temperature":18.0,"humidity":56}
+	]}`
+	assert.Equal(t, http.StatusUnauthorized, post("attic", "", body))
+	assert.Equal(t, http.StatusUnauthorized, post("attic", "wrong", body))
+	assert.Equal(t, http.StatusUnauthorized, post("garage", "s3cret", body))
+	assert.Equal(t, http.StatusBadRequest, post("attic", "s3cret", `{"readings":[{"humidity":55}]}`))
+	assert.Equal(t, http.StatusBadRequest, post("attic", "s3cret", `{"readings":[{"t":"2025-01-01T13:00:00Z","temperature":1}]}`))
+	assert.Equal(t, http.StatusBadRequest, post("attic", "s3cret", `{"readings":[]}`))
+	assert.Empty(t, m.gauges)
+
+	// backfilled readings keep their timestamps and are recorded in order
+	assert.Equal(t, http.StatusNoContent, post("attic", "s3cret", body))
+	assert.Equal(t, []float64{18.0, 18.5}, m.gauges["attic/current_temperature"])
+	assert.Equal(t, []time.Time{
+		time.Date(2025, 1, 1, 11, 50, 0, 0, time.UTC),
+		time.Date(2025, 1, 1, 11, 58, 0, 0, time.UTC),
+	}, m.times["attic/current_temperature"])
+	assert.Len(t, m.gauges["attic/dew_point"], 2)
+	assert.NotContains(t, m.gauges, "attic/current_pressure")
+
+	// the latest reading makes the node online, the pull loop doesn't record it again
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, ONLINE, st.status)
+	assert.InDelta(t, 18.5, st.t, 1e-9)
+	assert.Len(t, m.gauges["attic/current_temperature"], 2)
+
+	// an older batch doesn't replace the latest reading, nor is it recorded, the node gets the dropped count
+	req := httptest.NewRequest(http.MethodPost, "/nodes/attic/readings",
+		strings.NewReader(`{"readings":[{"t":"2025-01-01T11:00:00Z","temperature":10}]}`))
+	req.SetPathValue("id", "attic")
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	server.handleNodeReadings(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"dropped":1}`, rec.Body.String())
+	rd, err := remote.Read(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, 18.5, rd.Temperature, 1e-9)
+	assert.InDelta(t, 18.5, st.t, 1e-9)
+	assert.Len(t, m.gauges["attic/current_temperature"], 2)
+	assert.True(t, math.IsNaN(rd.Pressure), "the node has no pressure")
+
+	// and the node goes offline when it stops reporting
+	now = now.Add(DefaultStaleAfter)
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, OFFLINE, st.status)
+	assert.ErrorContains(t, st.err, "node is stale, the last reading was 7m0s ago")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	now = now.Add(-DefaultStaleAfter)
+	_, err = remote.Read(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestHandleNodeReadingsFilters(t *testing.T) {
+	now := time.Date(2025, 1, 1, 11, 55, 0, 0, time.UTC)
+	remote := NewRemoteSensor("s3cret", DefaultStaleAfter)
+	remote.now = func() time.Time { return now }
+	filters, err := filter.ParseConfig("current_temperature=range:-40:85,rate:2,median:3")
+	require.NoError(t, err)
+
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	server := New(nil, []Sensor{{ID: "attic", Room: "Attic", Climate: remote, Filters: filters}}, nil, nil, m, nil, nil, nil)
+	st := server.sensors[0]
+
+	// a glitch out of range and a spike of a backfilled batch are rejected, the rest is smoothed
+	req := httptest.NewRequest(http.MethodPost, "/nodes/attic/readings", strings.NewReader(`{"readings":[
+		{"t":"2025-01-01T11:50:00Z","temperature":18},
+		{"t":"2025-01-01T11:51:00Z","temperature":150},
+		{"t":"2025-01-01T11:52:00Z","temperature":30},
+		{"t":"2025-01-01T11:53:00Z","temperature":19},
+		{"t":"2025-01-01T11:54:00Z","temperature":20}
+	]}`))
+	req.SetPathValue("id", "attic")
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	server.handleNodeReadings(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	assert.Equal(t, []float64{18, 18.5, 19}, m.gauges["attic/current_temperature"])
+	assert.Equal(t, 2, st.rejected())
+
+	// the pull loop doesn't feed the latest reading to the filters again
+	for range 3 {
+		server.pullDataFromSensor(context.Background(), st)
+	}
+	assert.Equal(t, ONLINE, st.status)
+	assert.InDelta(t, 19, st.t, 1e-9)
+	assert.Len(t, m.gauges["attic/current_temperature"], 3)
+}
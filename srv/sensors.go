@@ -0,0 +1,150 @@
+package srv
+
+import (
+	"math"
+
+	"github.com/egregors/hk/internal/derived"
+	"github.com/egregors/hk/internal/filter"
+	"github.com/egregors/hk/internal/sensors"
+	"github.com/egregors/hk/log"
+)
+
+// unfilteredSuffix marks metric keys of unfiltered readings
+const unfilteredSuffix = "_unfiltered"
+
+// Sensor is a named climate sensor placed in some room
+type Sensor struct {
+	// ID is used in metric keys and to match HomeKit accessories.
+	// Empty ID keeps legacy metric keys (without prefix).
+	ID string
+	// Room is a human-readable name of the place where the sensor is
+	Room    string
+	Climate ClimateSensor
+	// Filters check and smooth readings, no filtering if not set
+	Filters filter.Config
+}
+
+// sensorState keeps the last readings and online/offline status of a single sensor
+type sensorState struct {
+	Sensor
+
+	status  string
+	err     error
+	t, h, p float64
+	// co2 is NaN for sensors without CO2 measurement
+	co2 float64
+	// aq has NaN gas resistance for sensors without gas measurement
+	aq      sensors.AirQuality
+	derived derived.Values
+
+	// pipelines filter measured quantities by keys
+	pipelines map[string]*filter.Pipeline
+	// stats are read latencies, errors and recent readings for diagnostics
+	stats readStats
+}
+
+func newSensorState(sensor Sensor) *sensorState {
+	st := &sensorState{
+		Sensor:    sensor,
+		status:    ONLINE,
+		co2:       math.NaN(),
+		aq:        noAirQuality(),
+		pipelines: make(map[string]*filter.Pipeline),
+	}
+
+	// IAQ and VOC are computed from gas resistance, so only measured quantities are filtered
+	for _, key := range []string{temperatureKey, humidityKey, pressureKey, co2Key, gasKey} {
+		if p := sensor.Filters.Pipeline(st.key(key), key); p != nil {
+			st.pipelines[key] = p
+		}
+	}
+
+	return st
+}
+
+// update sets readings passed through the filters, rejected readings keep the last accepted values.
+// It returns unfiltered readings and keys of rejected ones.
+func (st *sensorState) update(rd sensors.Reading) (map[string]float64, map[string]bool) {
+	unfiltered := measured(rd)
+
+	gas := st.aq.GasResistance
+	st.aq = rd.AirQuality
+	st.aq.GasResistance = gas
+	fields := map[string]*float64{
+		temperatureKey: &st.t,
+		humidityKey:    &st.h,
+		pressureKey:    &st.p,
+		co2Key:         &st.co2,
+		gasKey:         &st.aq.GasResistance,
+	}
+
+	rejected := make(map[string]bool)
+	for key, v := range unfiltered {
+		if pipeline, ok := st.pipelines[key]; ok {
+			var err error
+			v, err = pipeline.Apply(rd.T, v)
+			if err != nil {
+				log.Info.Printf("reject %s of %q: %s", key, st.Room, err.Error())
+				rejected[key] = true
+				continue
+			}
+		}
+		*fields[key] = v
+	}
+
+	return unfiltered, rejected
+}
+
+// rejected returns the number of readings rejected by filters
+func (st *sensorState) rejected() int {
+	n := 0
+	for _, p := range st.pipelines {
+		n += p.Rejected()
+	}
+
+	return n
+}
+
+// key returns the metric key of the sensor for a quantity, e.g. "kitchen/current_temperature"
+func (st *sensorState) key(quantity string) string {
+	if st.ID == "" {
+		return quantity
+	}
+
+	return st.ID + "/" + quantity
+}
+
+// readings returns the last readings and derived values by quantity, the ones the sensor can't measure are skipped
+func (st *sensorState) readings() map[string]float64 {
+	readings := st.derived.Gauges()
+	for key, val := range map[string]float64{
+		temperatureKey: st.t,
+		humidityKey:    st.h,
+		pressureKey:    st.p,
+		co2Key:         st.co2,
+		gasKey:         st.aq.GasResistance,
+		iaqKey:         st.aq.IAQ,
+		vocKey:         st.aq.VOC,
+	} {
+		if !math.IsNaN(val) {
+			readings[key] = val
+		}
+	}
+
+	return readings
+}
+
+// measured returns measured quantities of the reading by keys, unsupported ones are NaN
+func measured(rd sensors.Reading) map[string]float64 {
+	return map[string]float64{
+		temperatureKey: rd.Temperature,
+		humidityKey:    rd.Humidity,
+		pressureKey:    rd.Pressure,
+		co2Key:         rd.CO2,
+		gasKey:         rd.AirQuality.GasResistance,
+	}
+}
+
+func noAirQuality() sensors.AirQuality {
+	return sensors.AirQuality{GasResistance: math.NaN(), IAQ: math.NaN(), VOC: math.NaN()}
+}
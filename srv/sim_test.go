@@ -0,0 +1,200 @@
+package srv
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egregors/hk/internal/filter"
+	"github.com/egregors/hk/internal/metrics"
+	"github.com/egregors/hk/internal/sensors"
+)
+
+type fakeMetrics struct {
+	gauges map[string][]float64
+	times  map[string][]time.Time
+}
+
+func (m *fakeMetrics) Gauge(key string, val float64) {
+	m.gauges[key] = append(m.gauges[key], val)
+}
+
+func (m *fakeMetrics) GaugeAt(key string, val float64, t time.Time) {
+	m.Gauge(key, val)
+	if m.times == nil {
+		m.times = make(map[string][]time.Time)
+	}
+	m.times[key] = append(m.times[key], t)
+}
+
+func (m *fakeMetrics) Avg(_ string, _ time.Duration) []metrics.Value {
+	return nil
+}
+
+type fakeNotifier struct {
+	ch chan string
+}
+
+func (n *fakeNotifier) Notify(title, message string) error {
+	n.ch <- title + ": " + message
+	return nil
+}
+
+func TestPullDataFromSimulatedSensor(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	sim := sensors.NewSimulated(
+		sensors.Diurnal{MeanT: 20, MeanH: 50, MeanP: 1000},
+		sensors.WithClock(func() time.Time { return now }, func(time.Duration) {}),
+		sensors.WithFaults(sensors.Fault{Kind: sensors.FaultIOError, From: 10 * time.Minute, To: 20 * time.Minute}),
+	)
+
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	n := &fakeNotifier{ch: make(chan string, 1)}
+	server := New(nil, []Sensor{{ID: "sim", Room: "Lab", Climate: sim}}, nil, nil, m, n, nil, nil)
+	st := server.sensors[0]
+
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, ONLINE, st.status)
+	assert.InDelta(t, 20, st.t, 1e-9)
+	assert.Equal(t, []float64{20}, m.gauges["sim/current_temperature"])
+
+	// sensor fails and goes offline, notification is sent
+	now = now.Add(15 * time.Minute)
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, OFFLINE, st.status)
+	require.ErrorIs(t, st.err, sensors.ErrSimulatedIO)
+	select {
+	case msg := <-n.ch:
+		assert.Equal(t, "Sensor Error: Lab: write /dev/i2c-sim: input/output error", msg)
+	case <-time.After(time.Second):
+		t.Fatal("notification wasn't sent")
+	}
+	assert.Len(t, m.gauges["sim/current_temperature"], 1, "no gauges while offline")
+
+	// and comes back
+	now = now.Add(10 * time.Minute)
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, ONLINE, st.status)
+	assert.NoError(t, st.err)
+	assert.Len(t, m.gauges["sim/current_temperature"], 2)
+}
+
+func TestPullDataFromTemperatureOnlySensor(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "28-0316a2794aff")
+	require.NoError(t, os.MkdirAll(dir, 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "w1_slave"), []byte(
+		"72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n",
+	), 0o600))
+
+	probe, err := sensors.NewDS18B20(dir)
+	require.NoError(t, err)
+
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	server := New(nil, []Sensor{{ID: "outdoor", Room: "Outdoor", Climate: probe}}, nil, nil, m, nil, nil, nil)
+	st := server.sensors[0]
+
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, ONLINE, st.status)
+	assert.Equal(t, map[string][]float64{"outdoor/current_temperature": {23.125}}, m.gauges)
+}
+
+func TestPullDataFromHungSensor(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	sim := sensors.NewSimulated(
+		sensors.Diurnal{MeanT: 20, MeanH: 50, MeanP: 1000},
+		sensors.WithClock(time.Now, func(time.Duration) { <-release }),
+		sensors.WithFaults(sensors.Fault{Kind: sensors.FaultLatency, To: time.Hour, Latency: time.Hour}),
+	)
+
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	server := New(nil, []Sensor{{ID: "sim", Room: "Lab", Climate: sim}}, nil, nil, m, nil, nil, nil)
+	server.readTimeout = 10 * time.Millisecond
+	st := server.sensors[0]
+
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, OFFLINE, st.status)
+	assert.ErrorIs(t, st.err, context.DeadlineExceeded)
+	assert.Empty(t, m.gauges)
+}
+
+func TestPullDataFromCO2Sensor(t *testing.T) {
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	server := New(nil, []Sensor{{ID: "office", Room: "Office", Climate: &co2Climate{co2: 870}}}, nil, nil, m, nil, nil, nil)
+	st := server.sensors[0]
+
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, ONLINE, st.status)
+	assert.InDelta(t, 870, st.co2, 1e-9)
+	assert.Equal(t, []float64{870}, m.gauges["office/current_co2"])
+	assert.Equal(t, "CO2  870 ppm\n", renderCO2(st.co2))
+	assert.Empty(t, renderCO2(math.NaN()))
+}
+
+type gasClimate struct {
+	recoveringClimate
+	aq sensors.AirQuality
+}
+
+func (c *gasClimate) Read(ctx context.Context) (sensors.Reading, error) {
+	rd, err := c.recoveringClimate.Read(ctx)
+	rd.AirQuality = c.aq
+
+	return rd, err
+}
+
+func TestPullDataFromGasSensor(t *testing.T) {
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	sensor := &gasClimate{aq: sensors.AirQuality{GasResistance: 120e3, IAQ: math.NaN(), VOC: math.NaN()}}
+	server := New(nil, []Sensor{{ID: "office", Room: "Office", Climate: sensor}}, nil, nil, m, nil, nil, nil)
+	st := server.sensors[0]
+
+	// IAQ isn't recorded during the burn-in
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, []float64{120e3}, m.gauges["office/gas_resistance"])
+	assert.NotContains(t, m.gauges, "office/iaq")
+	assert.Equal(t, "IAQ  n/a\nVOC  n/a\nGas  120.0 kΩ\n", renderAirQuality(st.aq))
+
+	sensor.aq = sensors.AirQuality{GasResistance: 100e3, IAQ: 42, VOC: 200}
+	server.pullDataFromSensor(context.Background(), st)
+	assert.Equal(t, []float64{42}, m.gauges["office/iaq"])
+	assert.Equal(t, []float64{200}, m.gauges["office/voc_density"])
+	assert.Equal(t, "IAQ  42\nVOC  200 µg/m³\nGas  100.0 kΩ\n", renderAirQuality(st.aq))
+
+	assert.Empty(t, renderAirQuality(noAirQuality()))
+}
+
+func TestPullDataWithFilters(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	sim := sensors.NewSimulated(
+		sensors.Diurnal{MeanT: 20, MeanH: 50, MeanP: 1000},
+		sensors.WithClock(func() time.Time { return now }, func(time.Duration) {}),
+		sensors.WithFaults(sensors.Fault{Kind: sensors.FaultSpike, Spike: 40, From: time.Minute, To: 2 * time.Minute}),
+	)
+	filters, err := filter.ParseConfig(filter.DefaultConfig)
+	require.NoError(t, err)
+	filters.KeepRaw = true
+
+	m := &fakeMetrics{gauges: make(map[string][]float64)}
+	server := New(nil, []Sensor{{ID: "sim", Room: "Lab", Climate: sim, Filters: filters}}, nil, nil, m, nil, nil, nil)
+	st := server.sensors[0]
+
+	for i := 0; i < 6; i++ {
+		server.pullDataFromSensor(context.Background(), st)
+		assert.InDelta(t, 20, st.t, 1e-9, "HomeKit doesn't see the spike")
+		now = now.Add(30 * time.Second)
+	}
+
+	assert.Equal(t, ONLINE, st.status)
+	assert.Equal(t, 2, st.rejected())
+	assert.Equal(t, []float64{20, 20, 20, 20}, m.gauges["sim/current_temperature"])
+	assert.Equal(t, []float64{20, 20, 60, 60, 20, 20}, m.gauges["sim/current_temperature_unfiltered"])
+	assert.Len(t, m.gauges["sim/current_humidity"], 6)
+	assert.Contains(t, server.title(st), "Filters: 2 rejected\n")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"sort"
 	"strings"
@@ -11,7 +12,10 @@ import (
 	"time"
 
 	"github.com/brutella/hap"
+	"github.com/egregors/hk/internal/derived"
+	"github.com/egregors/hk/internal/host"
 	"github.com/egregors/hk/internal/metrics"
+	"github.com/egregors/hk/internal/sensors"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/egregors/hk/log"
@@ -20,25 +24,53 @@ import (
 
 const (
 	pullPushSleep = 30 * time.Second
+	// readTimeout cuts a hung bus transaction, SCD4x waits up to 10s for the first measurement
+	readTimeout = 15 * time.Second
 
 	temperatureKey = "current_temperature"
 	humidityKey    = "current_humidity"
+	pressureKey    = "current_pressure"
+	co2Key         = "current_co2"
+	gasKey         = "gas_resistance"
+	iaqKey         = "iaq"
+	vocKey         = "voc_density"
 
 	ONLINE  = "online"
 	OFFLINE = "offline"
 )
 
 type HapServer interface {
-	SetCurrentTemperature(t float64)
-	SetCurrentHumidity(h float64)
+	SetCurrentTemperature(sensorID string, t float64)
+	SetCurrentHumidity(sensorID string, h float64)
+	SetCurrentPressure(sensorID string, p float64)
+	SetCurrentCO2(sensorID string, ppm float64)
+	SetAirQuality(sensorID string, iaq, voc float64)
+	// SetUSB2Power sets the switch state without sending it to USB2PowerChan
+	SetUSB2Power(on bool)
 	USB2PowerChan() chan bool
 
 	ListenAndServe(ctx context.Context) error
 }
 
+// ClimateSensor takes a single measurement of all quantities it has, the ones it can't measure are NaN.
+// Read returns when ctx is done even if the bus hangs.
 type ClimateSensor interface {
-	CurrentTemperature() (float64, error)
-	CurrentHumidity() (float64, error)
+	Read(ctx context.Context) (sensors.Reading, error)
+}
+
+// RecoveryReporter is implemented by sensors which can reconnect to the bus after failures
+type RecoveryReporter interface {
+	RecoveryStatus() string
+}
+
+// SettingsReporter is implemented by sensors with configurable measurement settings
+type SettingsReporter interface {
+	Settings() string
+}
+
+// GroupReporter is implemented by sensors made of several members, e.g. GroupSensor
+type GroupReporter interface {
+	MembersStatus() string
 }
 
 type USB2PowerCtrl interface {
@@ -52,6 +84,7 @@ type Store interface {
 
 type Metrics interface {
 	Gauge(key string, val float64)
+	GaugeAt(key string, val float64, t time.Time)
 	Avg(key string, dur time.Duration) []metrics.Value
 }
 
@@ -59,43 +92,60 @@ type Notifier interface {
 	Notify(title, message string) error
 }
 
+// Broker publishes readings to a message broker and takes USB power commands from it, e.g. MQTT
+type Broker interface {
+	PublishReading(sensorID, quantity string, val float64)
+	PublishStatus(sensorID, status string)
+	PublishUSB2Power(on bool)
+	USB2PowerChan() chan bool
+}
+
 type Server struct {
 	webSrv    *http.Server
 	hkSrv     HapServer
-	climate   ClimateSensor
+	sensors   []*sensorState
 	usb2power USB2PowerCtrl
 	store     Store
 	metrics   Metrics
 	notifier  Notifier
+	broker    Broker
+	host      HostMonitor
+	hostStats host.Stats
 
-	sensorStatus string
-	sensorErr    error
-	startTime    time.Time
+	startTime   time.Time
+	readTimeout time.Duration
 
-	mu           *sync.RWMutex
-	currT, currH float64
+	mu *sync.RWMutex
 }
 
 func New(
 	store Store,
-	climate ClimateSensor,
+	sensors []Sensor,
 	usb2power USB2PowerCtrl,
 	hapSrv HapServer,
 	metrics Metrics,
 	notifier Notifier,
+	broker Broker,
+	hostMonitor HostMonitor,
 ) *Server {
+	states := make([]*sensorState, 0, len(sensors))
+	for _, sensor := range sensors {
+		states = append(states, newSensorState(sensor))
+	}
+
 	return &Server{
-		webSrv:       nil,
-		hkSrv:        hapSrv,
-		climate:      climate,
-		usb2power:    usb2power,
-		store:        store,
-		metrics:      metrics,
-		notifier:     notifier,
-		sensorStatus: ONLINE,
-		sensorErr:    nil,
-		startTime:    time.Now(),
-		mu:           &sync.RWMutex{},
+		webSrv:      nil,
+		hkSrv:       hapSrv,
+		sensors:     states,
+		usb2power:   usb2power,
+		store:       store,
+		metrics:     metrics,
+		notifier:    notifier,
+		broker:      broker,
+		host:        hostMonitor,
+		startTime:   time.Now(),
+		readTimeout: readTimeout,
+		mu:          &sync.RWMutex{},
 	}
 }
 
@@ -103,7 +153,8 @@ func (s *Server) Run(ctx context.Context) error {
 	go func() {
 		log.Info.Printf("start syncing sensor data with %s sleep", pullPushSleep)
 		for {
-			s.pullDataFromSensor()
+			s.pullDataFromSensors(ctx)
+			s.pullDataFromHost(ctx)
 			s.pushDataToHK()
 			<-time.After(pullPushSleep)
 		}
@@ -128,62 +179,101 @@ func (s *Server) Run(ctx context.Context) error {
 
 		return nil
 	})
+	if s.broker != nil {
+		// go listen broker commands
+		g.Go(func() error {
+			log.Info.Println("start listen broker commands")
+			s.listenBrokerEvents()
+
+			return nil
+		})
+	}
 
 	return g.Wait()
 }
 
-func (s *Server) pullDataFromSensor() {
+func (s *Server) pullDataFromSensors(ctx context.Context) {
+	for _, st := range s.sensors {
+		s.pullDataFromSensor(ctx, st)
+	}
+}
+
+func (s *Server) pullDataFromSensor(ctx context.Context, st *sensorState) {
+	rd, err := s.readSensor(ctx, st)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	// runs last, after the status is set
+	defer s.publish(st)
 
-	var (
-		err  error
-		t, h float64
-	)
-
-	defer func() {
-		if err != nil {
-			log.Erro.Printf("can't get sensor data: %s", err.Error())
-			s.sensorStatus = OFFLINE
-			s.sensorErr = err
-			go func() {
-				if s.notifier != nil {
-					err := s.notifier.Notify("Sensor Error", err.Error())
-					if err != nil {
-						log.Erro.Printf("can't send notification: %s", err.Error())
-					} else {
-						log.Info.Println("notification sent")
-					}
+	if err != nil {
+		log.Erro.Printf("can't get sensor data from %q: %s", st.Room, err.Error())
+		st.status = OFFLINE
+		st.err = err
+		go func() {
+			if s.notifier != nil {
+				err := s.notifier.Notify("Sensor Error: "+st.Room, err.Error())
+				if err != nil {
+					log.Erro.Printf("can't send notification: %s", err.Error())
+				} else {
+					log.Info.Println("notification sent")
 				}
-			}()
-		}
-	}()
+			}
+		}()
 
-	t, err = s.climate.CurrentTemperature()
-	if err != nil {
 		return
 	}
-	time.Sleep(3 * time.Second)
-	h, err = s.climate.CurrentHumidity()
-	if err != nil {
+
+	st.status = ONLINE
+	st.err = nil
+
+	if _, ok := st.Climate.(*RemoteSensor); ok {
+		// remote readings are filtered and recorded on arrival, the last one is already in
 		return
 	}
 
-	s.sensorStatus = ONLINE
-	s.sensorErr = nil
+	s.ingest(st, rd)
+}
 
-	s.currT, s.currH = t, h
+// ingest passes the reading through the filters and records accepted values with its own timestamp
+func (s *Server) ingest(st *sensorState, rd sensors.Reading) {
+	unfiltered, rejected := st.update(rd)
+	st.derived = derived.Compute(st.t, st.h, st.p)
 
-	s.metrics.Gauge(temperatureKey, s.currT)
-	s.metrics.Gauge(humidityKey, s.currH)
+	for key, val := range st.readings() {
+		if !rejected[key] {
+			s.metrics.GaugeAt(st.key(key), val, rd.T)
+		}
+	}
+	if st.Filters.KeepRaw {
+		for key, val := range unfiltered {
+			if _, ok := st.pipelines[key]; ok && !math.IsNaN(val) {
+				s.metrics.GaugeAt(st.key(key+unfilteredSuffix), val, rd.T)
+			}
+		}
+	}
+	s.gaugeRaw(st, rd.T)
 }
 
 func (s *Server) pushDataToHK() {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	s.hkSrv.SetCurrentTemperature(s.currT)
-	s.hkSrv.SetCurrentHumidity(s.currH)
+	for _, st := range s.sensors {
+		s.hkSrv.SetCurrentTemperature(st.ID, st.t)
+		if !math.IsNaN(st.h) {
+			s.hkSrv.SetCurrentHumidity(st.ID, st.h)
+		}
+		if !math.IsNaN(st.p) {
+			s.hkSrv.SetCurrentPressure(st.ID, st.p)
+		}
+		if !math.IsNaN(st.co2) {
+			s.hkSrv.SetCurrentCO2(st.ID, st.co2)
+		}
+		if !math.IsNaN(st.aq.GasResistance) {
+			s.hkSrv.SetAirQuality(st.ID, st.aq.IAQ, st.aq.VOC)
+		}
+	}
 }
 
 func (s *Server) listenHapEvents() {
@@ -191,19 +281,29 @@ func (s *Server) listenHapEvents() {
 
 	for v := range powerCh {
 		log.Debg.Printf("got %v from powerCh", v)
+		s.setUSB2Power(v)
+	}
+}
 
-		if !v {
-			err := s.usb2power.On()
-			if err != nil {
-				log.Erro.Printf("can't turn the light on: %s", err.Error())
-			}
-		} else {
-			err := s.usb2power.Off()
-			if err != nil {
-				log.Erro.Printf("can't turn the light off: %s", err.Error())
-			}
+// setUSB2Power turns the power on or off and mirrors the state to the broker
+func (s *Server) setUSB2Power(on bool) bool {
+	if on {
+		if err := s.usb2power.On(); err != nil {
+			log.Erro.Printf("can't turn the light on: %s", err.Error())
+			return false
+		}
+	} else {
+		if err := s.usb2power.Off(); err != nil {
+			log.Erro.Printf("can't turn the light off: %s", err.Error())
+			return false
 		}
 	}
+
+	if s.broker != nil {
+		s.broker.PublishUSB2Power(on)
+	}
+
+	return true
 }
 
 func (s *Server) runWebServer() error {
@@ -216,19 +316,41 @@ func (s *Server) runWebServer() error {
 		s.mu.RLock()
 		defer s.mu.RUnlock()
 
-		temp := s.metrics.Avg(temperatureKey, 24*time.Hour*3)
-		humi := s.metrics.Avg(humidityKey, 24*time.Hour*3)
-
-		_, _ = fmt.Fprintf(
-			w,
-			"%s\nTemp %0.2f °C\nHumi %0.2f %%\n\n%s\n\n%s\n\n",
-			s.title(),
-			s.currT, s.currH,
-			renderHourlyAvgVisualisation(temp, humi),
-			renderHourlyAvgTable(temp, humi),
-		)
+		for _, st := range s.sensors {
+			temp := s.metrics.Avg(st.key(temperatureKey), 24*time.Hour*3)
+			humi := s.metrics.Avg(st.key(humidityKey), 24*time.Hour*3)
+			pres := s.metrics.Avg(st.key(pressureKey), 24*time.Hour*3)
+
+			_, _ = fmt.Fprintf(
+				w,
+				"[ %s ]\n%s\nTemp %s\nHumi %s\nPres %s\n%s%s\n%s\n%s\n\n%s\n\n",
+				st.Room,
+				s.title(st),
+				fmtReading("%0.2f °C", st.t),
+				fmtReading("%0.2f %%", st.h),
+				fmtReading("%0.2f hPa", st.p),
+				renderCO2(st.co2),
+				renderAirQuality(st.aq),
+				renderDerived(st.derived),
+				renderHourlyAvgVisualisation(temp, humi, pres),
+				renderHourlyAvgTable(temp, humi, pres),
+			)
+		}
+
+		if s.host != nil {
+			_, _ = fmt.Fprint(w, renderHost(s.hostStats))
+		}
 	})
 
+	mux.HandleFunc("GET /calibration", s.handleCalibrationShow)
+	mux.HandleFunc("POST /calibration", s.handleCalibrationSet)
+	mux.HandleFunc("POST /calibration/reference", s.handleCalibrationReference)
+	mux.HandleFunc("POST /calibration/co2", s.handleCalibrationCO2)
+	mux.HandleFunc("POST /calibration/selfheating", s.handleCalibrationSelfHeating)
+	mux.HandleFunc("POST /nodes/{id}/readings", s.handleNodeReadings)
+	mux.HandleFunc("GET /diagnostics", s.handleDiagnostics)
+	mux.HandleFunc("POST /diagnostics/selftest", s.handleSelfTest)
+
 	s.webSrv = &http.Server{
 		Addr:              ":80",
 		Handler:           mux,
@@ -242,13 +364,37 @@ func (s *Server) runHapServer(ctx context.Context) error {
 	return s.hkSrv.ListenAndServe(ctx)
 }
 
-func (s *Server) title() string {
+func (s *Server) title(st *sensorState) string {
 	var status, err string
-	if s.sensorStatus == ONLINE {
+	if st.status == ONLINE {
 		status = "🟢 Online"
 	} else {
 		status = "🔴 Offline"
-		err = "Error: " + s.sensorErr.Error() + "\n"
+		err = "Error: " + st.err.Error() + "\n"
+	}
+
+	if st.status != ONLINE && !st.stats.lastOK.IsZero() {
+		err += "Last read: " + st.stats.lastOK.Format(time.DateTime) + "\n"
+	}
+
+	if n := st.rejected(); n > 0 {
+		err += fmt.Sprintf("Filters: %d rejected\n", n)
+	}
+
+	if rr, ok := st.Climate.(RecoveryReporter); ok {
+		if recovery := rr.RecoveryStatus(); recovery != "" {
+			err += "Recovery: " + recovery + "\n"
+		}
+	}
+
+	if sr, ok := st.Climate.(SettingsReporter); ok {
+		if settings := sr.Settings(); settings != "" {
+			err += "Settings: " + settings + "\n"
+		}
+	}
+
+	if gr, ok := st.Climate.(GroupReporter); ok {
+		err += "Members: " + gr.MembersStatus() + "\n"
 	}
 
 	uptime := s.formatUptime()
@@ -275,18 +421,62 @@ func (s *Server) formatUptime() string {
 	return fmt.Sprintf("(uptime: %dd %dh %dm)", days, remainingHours, remainingMinutes)
 }
 
-func renderHourlyAvgTable(hourlyAverageT, hourlyAverageH []metrics.Value) string {
+// renderCO2 shows CO2 line only for sensors measuring it
+func renderCO2(co2 float64) string {
+	if math.IsNaN(co2) {
+		return ""
+	}
+
+	return fmt.Sprintf("CO2  %0.0f ppm\n", co2)
+}
+
+// renderAirQuality shows air quality lines only for gas sensors, IAQ is "n/a" during the burn-in
+func renderAirQuality(aq sensors.AirQuality) string {
+	if math.IsNaN(aq.GasResistance) {
+		return ""
+	}
+
+	return fmt.Sprintf(
+		"IAQ  %s\nVOC  %s\nGas  %0.1f kΩ\n",
+		fmtReading("%0.0f", aq.IAQ),
+		fmtReading("%0.0f µg/m³", aq.VOC),
+		aq.GasResistance/1000,
+	)
+}
+
+// renderDerived shows psychrometric values, unavailable ones are "n/a"
+func renderDerived(v derived.Values) string {
+	return fmt.Sprintf(
+		"DewP %s\nAbsH %s\nHmdx %s\nHIdx %s\nMixR %s\n",
+		fmtReading("%0.2f °C", v.DewPoint),
+		fmtReading("%0.2f g/m³", v.AbsHumidity),
+		fmtReading("%0.2f", v.Humidex),
+		fmtReading("%0.2f °C", v.HeatIndex),
+		fmtReading("%0.2f g/kg", v.MixingRatio),
+	)
+}
+
+// fmtReading formats a reading or shows "n/a" if the sensor can't measure it
+func fmtReading(format string, v float64) string {
+	if math.IsNaN(v) {
+		return "n/a"
+	}
+
+	return fmt.Sprintf(format, v)
+}
+
+func renderHourlyAvgTable(hourlyAverageT, hourlyAverageH, hourlyAverageP []metrics.Value) string {
 	var builder strings.Builder
-	builder.WriteString("+-------------------+---------+---------+\n")
-	builder.WriteString("| Datetime          |    T    |    H    |\n")
-	builder.WriteString("+-------------------+---------+---------+\n")
+	builder.WriteString("+-------------------+---------+---------+---------+\n")
+	builder.WriteString("| Datetime          |    T    |    H    |    P    |\n")
+	builder.WriteString("+-------------------+---------+---------+---------+\n")
 
 	merge := make(map[string][]float64)
 
 	// collect temp
 	for _, v := range hourlyAverageT {
 		if _, ok := merge[v.T.String()]; !ok {
-			merge[v.T.String()] = make([]float64, 2)
+			merge[v.T.String()] = make([]float64, 3)
 		}
 
 		merge[v.T.String()][0] = v.V
@@ -295,19 +485,28 @@ func renderHourlyAvgTable(hourlyAverageT, hourlyAverageH []metrics.Value) string
 	// collect humi
 	for _, v := range hourlyAverageH {
 		if _, ok := merge[v.T.String()]; !ok {
-			merge[v.T.String()] = make([]float64, 2)
+			merge[v.T.String()] = make([]float64, 3)
 		}
 
 		merge[v.T.String()][1] = v.V
 	}
 
+	// collect pres
+	for _, v := range hourlyAverageP {
+		if _, ok := merge[v.T.String()]; !ok {
+			merge[v.T.String()] = make([]float64, 3)
+		}
+
+		merge[v.T.String()][2] = v.V
+	}
+
 	allKeys := make([]string, 0, len(merge))
 	for k := range merge {
 		allKeys = append(allKeys, k)
 	}
 	if len(allKeys) == 0 {
 		// show "nothing to show
-		builder.WriteString("|         -         |    -    |    -    |\n")
+		builder.WriteString("|         -         |    -    |    -    |    -    |\n")
 
 		return builder.String()
 	}
@@ -330,15 +529,15 @@ func renderHourlyAvgTable(hourlyAverageT, hourlyAverageH []metrics.Value) string
 			fmt.Sprint(strings.Split(split[1], ":")[0] + "h"),
 		}, " ")
 		// TODO: put tempProgressionMark back, instead of ""
-		builder.WriteString(fmt.Sprintf("| %-17s | %7.2f | %7.2f |\n", timeMark, val[0], val[1]))
+		builder.WriteString(fmt.Sprintf("| %-17s | %7.2f | %7.2f | %7.2f |\n", timeMark, val[0], val[1], val[2]))
 	}
-	//                      | 2024-11-08 18h    |  34.93  |  54.58  |
-	builder.WriteString("+-------------------+---------+---------+\n")
+	//                      | 2024-11-08 18h    |  34.93  |  54.58  | 1013.25 |
+	builder.WriteString("+-------------------+---------+---------+---------+\n")
 
 	return builder.String()
 }
 
-func renderHourlyAvgVisualisation(hourlyAverageT, hourlyAverageH []metrics.Value) string {
+func renderHourlyAvgVisualisation(hourlyAverageT, hourlyAverageH, hourlyAverageP []metrics.Value) string {
 	tData := make([]float64, 0, len(hourlyAverageT))
 	for _, v := range hourlyAverageT {
 		tData = append(tData, v.V)
@@ -347,6 +546,10 @@ func renderHourlyAvgVisualisation(hourlyAverageT, hourlyAverageH []metrics.Value
 	for _, v := range hourlyAverageH {
 		hData = append(hData, v.V)
 	}
+	pData := make([]float64, 0, len(hourlyAverageP))
+	for _, v := range hourlyAverageP {
+		pData = append(pData, v.V)
+	}
 
-	return bp.SimplePlot(6, tData) + "\n\n" + bp.SimplePlot(6, hData)
+	return bp.SimplePlot(6, tData) + "\n\n" + bp.SimplePlot(6, hData) + "\n\n" + bp.SimplePlot(6, pData)
 }
@@ -1,8 +1,13 @@
 package srv
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/egregors/hk/internal/metrics"
+	"github.com/egregors/hk/internal/sensors"
 )
 
 func TestFormatUptime(t *testing.T) {
@@ -50,11 +55,13 @@ func TestFormatUptimeDays(t *testing.T) {
 func TestTitleWithUptime(t *testing.T) {
 	// Test that title includes uptime
 	server := &Server{
-		sensorStatus: ONLINE,
-		startTime:    time.Now().Add(-45 * time.Minute), // 45 minutes ago
+		startTime: time.Now().Add(-45 * time.Minute), // 45 minutes ago
+	}
+	st := &sensorState{
+		status: ONLINE,
 	}
 
-	title := server.title()
+	title := server.title(st)
 	expected := "Sensor: 🟢 Online (uptime: 45m)\n"
 	
 	if title != expected {
@@ -65,12 +72,14 @@ func TestTitleWithUptime(t *testing.T) {
 func TestTitleOfflineWithUptime(t *testing.T) {
 	// Test that title includes uptime even when offline
 	server := &Server{
-		sensorStatus: OFFLINE,
-		sensorErr:    &testError{msg: "test error"},
-		startTime:    time.Now().Add(-2*time.Hour - 15*time.Minute), // 2 hours, 15 minutes ago
+		startTime: time.Now().Add(-2*time.Hour - 15*time.Minute), // 2 hours, 15 minutes ago
+	}
+	st := &sensorState{
+		status: OFFLINE,
+		err:    &testError{msg: "test error"},
 	}
 
-	title := server.title()
+	title := server.title(st)
 	expected := "Sensor: 🔴 Offline (uptime: 2h 15m)\nError: test error\n"
 	
 	if title != expected {
@@ -78,6 +87,53 @@ func TestTitleOfflineWithUptime(t *testing.T) {
 	}
 }
 
+func TestTitleWithRecoveryStatus(t *testing.T) {
+	server := &Server{
+		startTime: time.Now().Add(-10 * time.Minute),
+	}
+	st := &sensorState{
+		Sensor: Sensor{Climate: &recoveringClimate{status: "attempt 1 failed: no such device, next in 30s"}},
+		status: OFFLINE,
+		err:    &testError{msg: "write /dev/i2c-1: remote I/O error"},
+	}
+
+	title := server.title(st)
+	expected := "Sensor: 🔴 Offline (uptime: 10m)\n" +
+		"Error: write /dev/i2c-1: remote I/O error\n" +
+		"Recovery: attempt 1 failed: no such device, next in 30s\n"
+
+	if title != expected {
+		t.Errorf("Expected title %q, got %q", expected, title)
+	}
+}
+
+func TestTitleWithSettings(t *testing.T) {
+	settings, err := sensors.ParseSettings("t:1,p:1,h:1,mode:normal,standby:1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sensor, err := sensors.Open(sensors.Spec{ID: "lab", Driver: "bme280-emu", Settings: settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		startTime: time.Now().Add(-10 * time.Minute),
+	}
+	st := &sensorState{
+		Sensor: Sensor{Climate: sensors.NewCalibrated(sensor, "lab", nil)},
+		status: ONLINE,
+	}
+
+	title := server.title(st)
+	expected := "Sensor: 🟢 Online (uptime: 10m)\n" +
+		"Settings: BME280 normal, standby 1s, oversampling T ×1 P ×1 H ×1, filter off\n"
+
+	if title != expected {
+		t.Errorf("Expected title %q, got %q", expected, title)
+	}
+}
+
 // Helper type for testing errors
 type testError struct {
 	msg string
@@ -85,4 +141,53 @@ type testError struct {
 
 func (e *testError) Error() string {
 	return e.msg
-}
\ No newline at end of file
+}
+
+func TestRenderHourlyAvgTableWithPressure(t *testing.T) {
+	ts := time.Date(2024, 11, 8, 18, 0, 0, 0, time.UTC)
+	table := renderHourlyAvgTable(
+		[]metrics.Value{{T: ts, V: 21.5}},
+		[]metrics.Value{{T: ts, V: 55.25}},
+		[]metrics.Value{{T: ts, V: 1013.25}},
+	)
+
+	expected := "| 2024-11-08 18h    |   21.50 |   55.25 | 1013.25 |\n"
+	if !strings.Contains(table, expected) {
+		t.Errorf("Expected table to contain %q, got %q", expected, table)
+	}
+}
+
+func TestRenderHourlyAvgTableEmpty(t *testing.T) {
+	table := renderHourlyAvgTable(nil, nil, nil)
+	expected := "|         -         |    -    |    -    |    -    |\n"
+
+	if !strings.Contains(table, expected) {
+		t.Errorf("Expected table to contain %q, got %q", expected, table)
+	}
+}
+
+func TestSensorStateKey(t *testing.T) {
+	legacy := newSensorState(Sensor{ID: "", Room: "Home"})
+	if key := legacy.key(temperatureKey); key != "current_temperature" {
+		t.Errorf("Expected legacy key %q, got %q", "current_temperature", key)
+	}
+
+	kitchen := newSensorState(Sensor{ID: "kitchen", Room: "Kitchen"})
+	if key := kitchen.key(temperatureKey); key != "kitchen/current_temperature" {
+		t.Errorf("Expected key %q, got %q", "kitchen/current_temperature", key)
+	}
+}
+
+// Helper type for testing sensor recovery status
+type recoveringClimate struct {
+	status string
+}
+
+func (c *recoveringClimate) Read(context.Context) (sensors.Reading, error) {
+	rd := sensors.NewReading(time.Now())
+	rd.Temperature, rd.Humidity, rd.Pressure = 0, 0, 0
+
+	return rd, nil
+}
+
+func (c *recoveringClimate) RecoveryStatus() string { return c.status }